@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Access log formats (ACCESS_LOG_FORMAT)
+const (
+	accessLogCommon   = "common"   // Common Log Format
+	accessLogCombined = "combined" // Combined Log Format (adds referrer and user agent)
+)
+
+// accessLogger writes access log lines to stdout, apart from the application log
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// accessLogMiddleware logs each request as an Apache-style access log line in the
+// given format, followed by the request duration in microseconds (like Apache's %D)
+func accessLogMiddleware(format string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		cw := &countingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		accessLogger.Println(accessLogLine(format, r, cw.status, cw.bytes, start, time.Since(start)))
+	})
+}
+
+// accessLogLine formats one access log line
+func accessLogLine(format string, r *http.Request, status int, bytes int64, start time.Time, duration time.Duration) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprint(bytes)
+	}
+
+	line := fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.URL.RequestURI(), r.Proto, status, size)
+	if format == accessLogCombined {
+		line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+	}
+	return fmt.Sprintf("%s %d", line, duration.Microseconds())
+}
+
+// orDash returns s, or "-" for an empty value as access logs expect
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// countingResponseWriter records the status and number of body bytes of a response
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming endpoints working through the access log
+func (w *countingResponseWriter) Flush() {
+	flush(w.ResponseWriter)
+}
+
+// Hijack lets the log stream's WebSocket upgrade through the access log
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogLine(t *testing.T) {
+	start := time.Date(2026, 3, 1, 14, 5, 9, 0, time.FixedZone("", 0))
+	r := httptest.NewRequest(http.MethodPost, "/api/generate?format=env", nil)
+	r.RemoteAddr = "192.0.2.10:54321"
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", "curl/8.5.0")
+
+	tests := []struct {
+		format string
+		status int
+		bytes  int64
+		want   string
+	}{
+		{accessLogCommon, http.StatusCreated, 512,
+			`192.0.2.10 - - [01/Mar/2026:14:05:09 +0000] "POST /api/generate?format=env HTTP/1.1" 201 512 1500`},
+		{accessLogCommon, http.StatusNoContent, 0,
+			`192.0.2.10 - - [01/Mar/2026:14:05:09 +0000] "POST /api/generate?format=env HTTP/1.1" 204 - 1500`},
+		{accessLogCombined, http.StatusCreated, 512,
+			`192.0.2.10 - - [01/Mar/2026:14:05:09 +0000] "POST /api/generate?format=env HTTP/1.1" 201 512 "https://example.com/" "curl/8.5.0" 1500`},
+	}
+	for _, tt := range tests {
+		if got := accessLogLine(tt.format, r, tt.status, tt.bytes, start, 1500*time.Microsecond); got != tt.want {
+			t.Errorf("accessLogLine(%s, %d)\n got %s\nwant %s", tt.format, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	h := setupTest(t, map[string]string{"ACCESS_LOG_FORMAT": accessLogCommon})
+	var buf syncBuffer
+	saved := accessLogger
+	accessLogger = log.New(&buf, "", 0)
+	t.Cleanup(func() { accessLogger = saved })
+	appLogs := captureLogs(t)
+
+	rec := doRequest(h, http.MethodGet, "/api/markets", "")
+	line := strings.TrimSuffix(buf.String(), "\n")
+	pattern := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /api/markets HTTP/1\.1" 200 (\d+) \d+$`)
+	m := pattern.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("access log line %q does not match %s", line, pattern)
+	}
+	if want := rec.Body.Len(); m[1] != strconv.Itoa(want) {
+		t.Errorf("logged %s bytes, response had %d", m[1], want)
+	}
+	if strings.Contains(appLogs.String(), `"GET /api/markets`) {
+		t.Error("access log line was written to the application log")
+	}
+}
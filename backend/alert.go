@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// alertTimeout bounds delivering one alert, which happens off the request path
+const alertTimeout = 5 * time.Second
+
+// alertFallback is the Type of the alert sent when generation falls back to local credentials
+const alertFallback = "fallback"
+
+// alertHTTPClient is used for ALERT_WEBHOOK_URL calls, apart from the MTN client
+var alertHTTPClient = &http.Client{Timeout: alertTimeout}
+
+// FallbackAlert is the body POSTed to ALERT_WEBHOOK_URL. Like CredentialsEvent it is
+// redacted by construction: it never carries the API key or the subscription key.
+type FallbackAlert struct {
+	Type              string    `json:"type"`
+	Timestamp         time.Time `json:"timestamp"`
+	Reason            string    `json:"reason"` // The fallbackReason error code, e.g. MTN_TIMEOUT
+	CallbackHost      string    `json:"callbackHost"`
+	TargetEnvironment string    `json:"targetEnvironment"`
+	// Suppressed counts the fallbacks not alerted since the previous alert, because of ALERT_MIN_INTERVAL
+	Suppressed int `json:"suppressed"`
+}
+
+// alertLimiter lets through at most one alert per ALERT_MIN_INTERVAL, so a sustained
+// MTN outage does not flood the webhook, and counts the ones it holds back
+type alertLimiter struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+var fallbackAlerts alertLimiter
+
+// allow reports whether an alert may be sent now and, if so, how many were suppressed before it
+func (l *alertLimiter) allow(now time.Time, interval time.Duration) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.Sub(l.last) < interval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.last = now
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// alertFallbackUsed notifies ALERT_WEBHOOK_URL that a request fell back to local
+// credentials. The alert is sent in the background so it never delays the response;
+// failures are only logged.
+func alertFallbackUsed(logger *requestLogger, reason, callbackHost, targetEnv string) {
+	if cfg.AlertWebhookURL == "" {
+		return
+	}
+	now := time.Now()
+	ok, suppressed := fallbackAlerts.allow(now, cfg.AlertMinInterval)
+	if !ok {
+		logger.Printf("Fallback alert suppressed (at most one per %s)", cfg.AlertMinInterval)
+		return
+	}
+	alert := FallbackAlert{
+		Type:              alertFallback,
+		Timestamp:         now.UTC(),
+		Reason:            reason,
+		CallbackHost:      callbackHost,
+		TargetEnvironment: targetEnv,
+		Suppressed:        suppressed,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+		if err := postAlert(ctx, cfg.AlertWebhookURL, alert); err != nil {
+			logger.Printf("ERROR: Failed to send fallback alert to %s: %v", webhookHost(cfg.AlertWebhookURL), err)
+			return
+		}
+		logger.Printf("Sent fallback alert (%s) to %s", reason, webhookHost(cfg.AlertWebhookURL))
+	}()
+}
+
+// postAlert POSTs alert as JSON to webhookURL, failing on any non-2xx answer
+func postAlert(ctx context.Context, webhookURL string, alert FallbackAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookHost is what is logged of a webhook URL: its host only, since webhook
+// URLs (Slack, Teams...) usually carry their secret token in the path
+func webhookHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Host
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturedAlert is one request received by the capturing webhook server
+type capturedAlert struct {
+	alert FallbackAlert
+	raw   string
+}
+
+// captureWebhook starts a webhook server that records every alert it receives. Each
+// request is held until release is closed, like a slow webhook.
+func captureWebhook(t *testing.T, release chan struct{}) (*httptest.Server, chan capturedAlert) {
+	t.Helper()
+	alerts := make(chan capturedAlert, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var alert FallbackAlert
+		if err := json.Unmarshal(body, &alert); err != nil {
+			t.Errorf("webhook got an unreadable alert %q: %v", body, err)
+		}
+		alerts <- capturedAlert{alert, string(body)}
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	return srv, alerts
+}
+
+// resetAlertLimiter forgets earlier alerts, for tests to start from a clean limiter
+func resetAlertLimiter(t *testing.T) {
+	t.Helper()
+	fallbackAlerts.mu.Lock()
+	fallbackAlerts.last = time.Time{}
+	fallbackAlerts.suppressed = 0
+	fallbackAlerts.mu.Unlock()
+}
+
+// nextAlert waits for the webhook to receive an alert
+func nextAlert(t *testing.T, alerts chan capturedAlert) capturedAlert {
+	t.Helper()
+	select {
+	case a := <-alerts:
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a fallback alert")
+		return capturedAlert{}
+	}
+}
+
+func TestFallbackAlertWebhook(t *testing.T) {
+	release := make(chan struct{})
+	webhook, alerts := captureWebhook(t, release)
+	h := setupTest(t, map[string]string{"ALERT_WEBHOOK_URL": webhook.URL + "/hooks/secret-token", "ALERT_MIN_INTERVAL": "1h"})
+	resetAlertLimiter(t)
+	fakeMTN(t, mtnStatus(http.StatusServiceUnavailable))
+	logs := captureLogs(t)
+	body := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"shop.example.com"}`, testSubscriptionKey)
+
+	// The webhook holds the alert, but the response does not wait for it
+	resp := generate(t, h, body)
+	if resp.Source != sourceLocal {
+		t.Fatalf("source = %q, want a local fallback", resp.Source)
+	}
+	got := nextAlert(t, alerts)
+	close(release)
+	waitForLog(t, logs, "Sent fallback alert")
+
+	want := FallbackAlert{Type: alertFallback, Reason: errMTNUnavailable, CallbackHost: "shop.example.com", TargetEnvironment: "sandbox"}
+	got.alert.Timestamp = time.Time{}
+	if got.alert != want {
+		t.Errorf("alert = %+v, want %+v", got.alert, want)
+	}
+	for _, secret := range []string{testSubscriptionKey, resp.APIKey, resp.Base64Auth} {
+		if strings.Contains(got.raw, secret) {
+			t.Errorf("alert carries a secret: %s", got.raw)
+		}
+	}
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Error("logs carry the webhook URL's token")
+	}
+
+	// Further fallbacks within ALERT_MIN_INTERVAL are counted, not sent
+	generate(t, h, body)
+	generate(t, h, body)
+	select {
+	case a := <-alerts:
+		t.Fatalf("alert sent within ALERT_MIN_INTERVAL: %s", a.raw)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once the interval has passed, the next alert reports the suppressed ones
+	fallbackAlerts.mu.Lock()
+	fallbackAlerts.last = time.Now().Add(-2 * time.Hour)
+	fallbackAlerts.mu.Unlock()
+	generate(t, h, body)
+	if a := nextAlert(t, alerts); a.alert.Suppressed != 2 {
+		t.Errorf("suppressed = %d, want the 2 fallbacks held back", a.alert.Suppressed)
+	}
+	// Let the second delivery finish before the next test rewires cfg
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(logs.String(), "Sent fallback alert") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the second alert delivery")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
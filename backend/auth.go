@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireAdminToken guards a handler behind the ADMIN_API_TOKEN bearer token.
+// When no token is configured the guarded endpoints are disabled entirely.
+func requireAdminToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AdminAPIToken == "" {
+			log.Printf("ERROR: Rejected %s %s - ADMIN_API_TOKEN is not configured", r.Method, r.URL.Path)
+			sendError(w, r, errAdminDisabled, "This endpoint is disabled because ADMIN_API_TOKEN is not configured", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminAPIToken)) != 1 {
+			log.Printf("ERROR: Rejected %s %s - missing or invalid admin token", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			sendError(w, r, errUnauthorized, "Missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Base64DecodeRequest is the body of POST /api/base64/decode
+type Base64DecodeRequest struct {
+	Base64Auth string `json:"base64Auth"` // base64 of apiUser:apiKey, optionally with a "Basic " prefix
+}
+
+// Base64DecodeResult describes a decoded base64 auth string. The API key itself is
+// never echoed back, only its length.
+type Base64DecodeResult struct {
+	APIUser   string `json:"apiUser"`
+	KeyLength int    `json:"keyLength"`
+	Valid     bool   `json:"valid"`
+	Reason    string `json:"reason,omitempty"` // Why the pair is not valid
+}
+
+// handleBase64Decode checks that a pasted base64 auth string decodes to a usable
+// apiUser:apiKey pair
+func handleBase64Decode(w http.ResponseWriter, r *http.Request) {
+	log.Println("=== New Base64 Decode Request Received ===")
+
+	var req Base64DecodeRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("ERROR: Invalid request format - %v", err)
+		sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	encoded := strings.TrimSpace(req.Base64Auth)
+	encoded = strings.TrimSpace(strings.TrimPrefix(encoded, "Basic "))
+	if encoded == "" {
+		sendError(w, r, errInvalidRequest, "base64Auth is required", http.StatusBadRequest)
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Printf("ERROR: base64Auth is not valid base64 - %v", err)
+		sendError(w, r, errInvalidRequest, "base64Auth is not valid base64", http.StatusBadRequest)
+		return
+	}
+	apiUser, apiKey, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		log.Println("ERROR: Decoded base64Auth has no colon separating apiUser and apiKey")
+		sendError(w, r, errInvalidRequest, "base64Auth does not decode to apiUser:apiKey (no colon)", http.StatusBadRequest)
+		return
+	}
+
+	result := Base64DecodeResult{APIUser: apiUser, KeyLength: len(apiKey), Valid: true}
+	if _, err := uuid.Parse(apiUser); err != nil {
+		result.Valid = false
+		result.Reason = "apiUser is not a UUID"
+	} else if apiKey == "" {
+		result.Valid = false
+		result.Reason = "apiKey is empty"
+	}
+	log.Printf("Decoded base64Auth for API user %q: valid=%t", apiUser, result.Valid)
+
+	sendResponse(w, r, true, "Base64 auth string decoded", result, http.StatusOK)
+}
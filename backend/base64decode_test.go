@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBase64Decode(t *testing.T) {
+	const userID, apiKey = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name       string
+		input      string
+		wantStatus int
+		want       Base64DecodeResult
+	}{
+		{"valid", encode(userID + ":" + apiKey), http.StatusOK, Base64DecodeResult{APIUser: userID, KeyLength: 32, Valid: true}},
+		{"basic prefix", "Basic " + encode(userID+":"+apiKey), http.StatusOK, Base64DecodeResult{APIUser: userID, KeyLength: 32, Valid: true}},
+		{"colon in key", encode(userID + ":a:b"), http.StatusOK, Base64DecodeResult{APIUser: userID, KeyLength: 3, Valid: true}},
+		{"user not a uuid", encode("alice:" + apiKey), http.StatusOK, Base64DecodeResult{APIUser: "alice", KeyLength: 32, Reason: "apiUser is not a UUID"}},
+		{"empty key", encode(userID + ":"), http.StatusOK, Base64DecodeResult{APIUser: userID, Reason: "apiKey is empty"}},
+		{"malformed base64", "not base64!", http.StatusBadRequest, Base64DecodeResult{}},
+		{"no colon", encode(userID + apiKey), http.StatusBadRequest, Base64DecodeResult{}},
+		{"empty", "  ", http.StatusBadRequest, Base64DecodeResult{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			rec := doRequest(h, http.MethodPost, "/api/base64/decode", fmt.Sprintf(`{"base64Auth":%q}`, tt.input))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), apiKey) {
+				t.Errorf("response echoes the API key: %s", rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest || env.Message == "" {
+					t.Errorf("error = %s %q, want %s with a message", env.ErrorCode, env.Message, errInvalidRequest)
+				}
+				return
+			}
+			var got Base64DecodeResult
+			decodeEnvelope(t, rec, &got)
+			if got != tt.want {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// BatchRequest is the body of POST /api/generate/batch: the generate requests to run
+type BatchRequest struct {
+	Requests []json.RawMessage `json:"requests"`
+}
+
+// BatchItemResult is the outcome of one batch item: the status and envelope fields
+// the item would have received from /api/generate on its own
+type BatchItemResult struct {
+	Status    int             `json:"status"`
+	Success   bool            `json:"success"`
+	Message   string          `json:"message"`
+	ErrorCode string          `json:"errorCode,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+
+	// Source and FallbackReason lift the item's credential source (mtn or local) and
+	// fallback error out of Data, so mixed outcomes are visible without parsing it
+	Source         string `json:"source,omitempty"`
+	FallbackReason string `json:"fallbackReason,omitempty"`
+}
+
+// BatchResponse is the data of a batch response
+type BatchResponse struct {
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// activeBatches counts the batch jobs currently running, against MAX_CONCURRENT_BATCHES
+var activeBatches atomic.Int64
+
+// bufferedResponseWriter captures a batch item's response instead of sending it
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header         { return w.header }
+func (w *bufferedResponseWriter) WriteHeader(status int)      { w.status = status }
+func (w *bufferedResponseWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
+
+// defaultBatchConcurrency is BATCH_CONCURRENCY when it is not set: two workers per
+// CPU, as items mostly wait on MTN, but never more than MOMO_MAX_CONCURRENCY, since
+// workers beyond that would only queue on the outbound semaphore.
+func defaultBatchConcurrency(cpus, maxConcurrency int) int {
+	return max(1, min(cpus*2, maxConcurrency))
+}
+
+// generateBatchHandler serves batches, charging limiter (nil for none) once per item
+func generateBatchHandler(limiter *rateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleGenerateBatch(w, r, limiter)
+	}
+}
+
+// handleGenerateBatch runs several generate requests, BATCH_CONCURRENCY at a time.
+// Each item goes through handleGenerateKeys exactly as a single request would, so
+// validation, fallback and storage behave identically.
+func handleGenerateBatch(w http.ResponseWriter, r *http.Request, limiter *rateLimiter) {
+	logger := reqLog(r.Context())
+	logger.Println("=== New Batch Generation Request Received ===")
+
+	var req BatchRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		logger.Printf("ERROR: Invalid request format - %v", err)
+		sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Requests) == 0 || len(req.Requests) > cfg.BatchMaxItems {
+		logger.Printf("ERROR: Batch has %d items (limit %d)", len(req.Requests), cfg.BatchMaxItems)
+		sendError(w, r, errInvalidRequest, fmt.Sprintf("requests must hold between 1 and %d items", cfg.BatchMaxItems), http.StatusBadRequest)
+		return
+	}
+
+	// Bound the number of batch jobs running at once, separately from per-item concurrency
+	if active := activeBatches.Add(1); active > int64(cfg.MaxConcurrentBatches) {
+		activeBatches.Add(-1)
+		logger.Printf("WARNING: Rejected batch - %d batch jobs already running (limit %d)", active-1, cfg.MaxConcurrentBatches)
+		sendError(w, r, errBatchLimit, "Too many batch jobs are running, retry later", http.StatusTooManyRequests)
+		return
+	}
+	defer activeBatches.Add(-1)
+
+	// Each item may create an MTN user, so the batch counts as that many generate requests
+	if !chargeRateLimit(w, r, limiter, len(req.Requests)) {
+		return
+	}
+
+	// Clients asking for text/event-stream get progress events while the batch runs
+	var events *sseWriter
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if events = newSSEWriter(w); events == nil {
+			logger.Println("ERROR: Streaming is not supported by the response writer")
+			sendError(w, r, errInternal, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	logger.Printf("Running batch of %d generate requests (concurrency %d, streaming %t)", len(req.Requests), cfg.BatchConcurrency, events != nil)
+	// Items finish in any order; their index travels with them through the workers so
+	// each result lands at its item's position
+	type indexedResult struct {
+		index  int
+		result BatchItemResult
+	}
+	results := make(chan indexedResult, len(req.Requests))
+	items := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.BatchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range items {
+				results <- indexedResult{index, runBatchItem(r, req.Requests[index])}
+			}
+		}()
+	}
+	go func() {
+		defer close(items)
+		for index := range req.Requests {
+			// Stop handing out work once the client has gone away
+			select {
+			case items <- index:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	resp := BatchResponse{Total: len(req.Requests), Results: make([]BatchItemResult, len(req.Requests))}
+	completed := 0
+	for res := range results {
+		if res.result.Success {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+		resp.Results[res.index] = res.result
+		completed++
+		if events != nil {
+			events.send("progress", BatchProgress{Completed: completed, Total: resp.Total})
+		}
+	}
+	if err := r.Context().Err(); err != nil {
+		logger.Printf("WARNING: Batch cancelled after %d of %d items - %v", completed, resp.Total, err)
+		return
+	}
+
+	logger.Printf("Batch complete: %d succeeded, %d failed", resp.Succeeded, resp.Failed)
+	if events != nil {
+		events.send("result", resp)
+		return
+	}
+	status := http.StatusOK
+	if cfg.BatchMultiStatus && mixedOutcomes(resp.Results) {
+		status = http.StatusMultiStatus
+	}
+	sendResponse(w, r, true, "Batch processed", resp, status)
+}
+
+// mixedOutcomes reports whether batch items ended differently: some succeeded and
+// some failed, or some were registered with MTN and some generated locally
+func mixedOutcomes(results []BatchItemResult) bool {
+	if len(results) == 0 {
+		return false
+	}
+	for _, result := range results[1:] {
+		if result.Success != results[0].Success || result.Source != results[0].Source {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchProgress is the data of a streamed batch's progress events
+type BatchProgress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+// sseWriter writes Server-Sent Events, flushing each one to the client
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter starts an event stream on w, or returns nil if w cannot stream
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep reverse proxies from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+// send writes one event with a JSON data payload
+func (s *sseWriter) send(event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode %s event: %v", event, err)
+		return
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload)
+	s.flusher.Flush()
+}
+
+// runBatchItem runs one batch item through handleGenerateKeys with its own deadline
+func runBatchItem(parent *http.Request, item json.RawMessage) BatchItemResult {
+	ctx, cancel := context.WithTimeout(parent.Context(), liveConfig().GenerateTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, parent.URL.Path, bytes.NewReader(item))
+	if err != nil {
+		return BatchItemResult{Status: http.StatusInternalServerError, Message: "Failed to build batch item request", ErrorCode: errInternal}
+	}
+	req.RemoteAddr = parent.RemoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	if lang := parent.Header.Get("Accept-Language"); lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+
+	rec := newBufferedResponseWriter()
+	handleGenerateKeys(rec, req)
+
+	result := BatchItemResult{Status: rec.status}
+	var envelope struct {
+		Success   bool            `json:"success"`
+		Message   string          `json:"message"`
+		ErrorCode string          `json:"errorCode"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &envelope); err != nil {
+		result.Message = "Batch item returned an unreadable response"
+		result.ErrorCode = errInternal
+		return result
+	}
+	result.Success = envelope.Success
+	result.Message = envelope.Message
+	result.ErrorCode = envelope.ErrorCode
+	result.Data = envelope.Data
+
+	// The data may use either naming style (NAMING_STYLE)
+	var data map[string]interface{}
+	if json.Unmarshal(envelope.Data, &data) == nil {
+		result.Source, _ = data["source"].(string)
+		if result.FallbackReason, _ = data["fallbackReason"].(string); result.FallbackReason == "" {
+			result.FallbackReason, _ = data["fallback_reason"].(string)
+		}
+	}
+	return result
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchBody is a batch of n generate requests
+func batchBody(n int) string {
+	item := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+	body := `{"requests":[`
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			body += ","
+		}
+		body += item
+	}
+	return body + "]}"
+}
+
+func TestConcurrentBatchLimit(t *testing.T) {
+	h := setupTest(t, map[string]string{"MAX_CONCURRENT_BATCHES": "2"})
+	release := make(chan struct{})
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		success(w, r)
+	}))
+
+	// Fill every batch slot with a job held up by MTN
+	var wg sync.WaitGroup
+	running := make([]int, 2)
+	for i := range running {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			running[i] = doRequest(h, http.MethodPost, "/api/generate/batch", batchBody(1)).Code
+		}(i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for activeBatches.Load() < 2 {
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatalf("only %d batch jobs started", activeBatches.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, lang := range []string{"en", "fr"} {
+		rec := doRequest(h, http.MethodPost, "/api/generate/batch", batchBody(1), "Accept-Language", lang)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("batch over the limit: status = %d, want 429 (body %s)", rec.Code, rec.Body)
+		}
+		env := decodeEnvelope(t, rec, nil)
+		if env.ErrorCode != errBatchLimit {
+			t.Errorf("errorCode = %q, want %q", env.ErrorCode, errBatchLimit)
+		}
+		if lang == "fr" && env.Message != frenchMessages[errBatchLimit] {
+			t.Errorf("French message = %q, want %q", env.Message, frenchMessages[errBatchLimit])
+		}
+	}
+
+	close(release)
+	wg.Wait()
+	for i, status := range running {
+		if status != http.StatusOK {
+			t.Errorf("running batch %d: status = %d, want 200", i+1, status)
+		}
+	}
+	// The slots are free again once the jobs finish
+	if rec := doRequest(h, http.MethodPost, "/api/generate/batch", batchBody(1)); rec.Code != http.StatusOK {
+		t.Errorf("batch after the others finished: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if n := activeBatches.Load(); n != 0 {
+		t.Errorf("activeBatches = %d after every batch finished", n)
+	}
+}
+
+// sseEvent is one parsed Server-Sent Event
+type sseEvent struct {
+	name string
+	data string
+}
+
+// parseSSE splits an event stream into its events
+func parseSSE(t *testing.T, stream string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(stream), "\n\n") {
+		var ev sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			default:
+				t.Fatalf("unexpected line %q in event stream", line)
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestBatchStreamsProgressInOrder(t *testing.T) {
+	h := setupTest(t, map[string]string{"BATCH_CONCURRENCY": "2"})
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+	const items = 3
+	rec := doRequest(h, http.MethodPost, "/api/generate/batch", batchBody(items), "Accept", "text/event-stream")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := parseSSE(t, rec.Body.String())
+	if len(events) != items+1 {
+		t.Fatalf("got %d events, want %d progress events and a result: %+v", len(events), items, events)
+	}
+	for i, ev := range events[:items] {
+		var progress BatchProgress
+		if ev.name != "progress" {
+			t.Fatalf("event %d is %q, want progress", i+1, ev.name)
+		}
+		if err := json.Unmarshal([]byte(ev.data), &progress); err != nil {
+			t.Fatalf("event %d data %q: %v", i+1, ev.data, err)
+		}
+		if want := (BatchProgress{Completed: i + 1, Total: items}); progress != want {
+			t.Errorf("event %d = %+v, want %+v", i+1, progress, want)
+		}
+	}
+
+	last := events[items]
+	if last.name != "result" {
+		t.Fatalf("final event is %q, want result", last.name)
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal([]byte(last.data), &resp); err != nil {
+		t.Fatalf("result data %q: %v", last.data, err)
+	}
+	if resp.Total != items || resp.Succeeded != items || resp.Failed != 0 || len(resp.Results) != items {
+		t.Errorf("result = %+v, want %d items all succeeded", resp, items)
+	}
+}
+
+func TestBatchMultiStatus(t *testing.T) {
+	mixed := fmt.Sprintf(`{"requests":[{"primaryKey":%q},{"primaryKey":%q},{"primaryKey":%q,"targetEnvironment":"mtnatlantis"}]}`,
+		testSubscriptionKey, badSubscriptionKey, testSubscriptionKey)
+	tests := []struct {
+		name        string
+		multiStatus string
+		body        string
+		wantStatus  int
+	}{
+		{"mixed outcomes", "true", mixed, http.StatusMultiStatus},
+		{"mixed outcomes, multi-status off", "false", mixed, http.StatusOK},
+		{"uniform outcomes", "true", batchBody(2), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"BATCH_MULTI_STATUS": tt.multiStatus})
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Ocp-Apim-Subscription-Key") == badSubscriptionKey {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				success(w, r)
+			}))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate/batch", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.body != mixed {
+				return
+			}
+
+			var resp BatchResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.Total != 3 || resp.Succeeded != 2 || resp.Failed != 1 {
+				t.Errorf("total %d, succeeded %d, failed %d; want 3, 2 and 1", resp.Total, resp.Succeeded, resp.Failed)
+			}
+			// Each item carries its own status, success flag, source and error, in request order
+			want := []BatchItemResult{
+				{Status: http.StatusCreated, Success: true, Source: sourceMTN},
+				{Status: http.StatusCreated, Success: true, Source: sourceLocal, FallbackReason: errMTNAuthFailed},
+				{Status: http.StatusBadRequest, Success: false, ErrorCode: errInvalidRequest},
+			}
+			for i, w := range want {
+				got := resp.Results[i]
+				if got.Status != w.Status || got.Success != w.Success || got.Source != w.Source || got.FallbackReason != w.FallbackReason || got.ErrorCode != w.ErrorCode {
+					t.Errorf("item %d = status %d success %t source %q fallbackReason %q errorCode %q; want %+v",
+						i, got.Status, got.Success, got.Source, got.FallbackReason, got.ErrorCode, w)
+				}
+				if got.Success != (len(got.Data) > 0) {
+					t.Errorf("item %d: success %t with data %s", i, got.Success, got.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchResultsKeepInputOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []time.Duration // MTN latency of each item, by input position
+	}{
+		{"slowest first", []time.Duration{120 * time.Millisecond, 90 * time.Millisecond, 60 * time.Millisecond, 30 * time.Millisecond, 0}},
+		{"mixed", []time.Duration{60 * time.Millisecond, 0, 120 * time.Millisecond, 30 * time.Millisecond, 90 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := len(tt.latencies)
+			h := setupTest(t, map[string]string{"BATCH_CONCURRENCY": strconv.Itoa(n), "BATCH_MAX_ITEMS": strconv.Itoa(n)})
+			var mu sync.Mutex
+			var completed []string
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/apiuser") {
+					var body struct {
+						ProviderCallbackHost string `json:"providerCallbackHost"`
+					}
+					json.NewDecoder(r.Body).Decode(&body)
+					var i int
+					fmt.Sscanf(body.ProviderCallbackHost, "item%d.example.com", &i)
+					time.Sleep(tt.latencies[i])
+					mu.Lock()
+					completed = append(completed, body.ProviderCallbackHost)
+					mu.Unlock()
+				}
+				success(w, r)
+			}))
+
+			items := make([]string, n)
+			for i := range items {
+				items[i] = fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"item%d.example.com"}`, testSubscriptionKey, i)
+			}
+			rec := doRequest(h, http.MethodPost, "/api/generate/batch", `{"requests":[`+strings.Join(items, ",")+`]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+			}
+			var resp BatchResponse
+			decodeEnvelope(t, rec, &resp)
+			if len(resp.Results) != n {
+				t.Fatalf("%d results, want %d", len(resp.Results), n)
+			}
+			for i, result := range resp.Results {
+				var data MomoKeyResponse
+				if err := json.Unmarshal(result.Data, &data); err != nil {
+					t.Fatalf("result %d data %s: %v", i, result.Data, err)
+				}
+				if want := fmt.Sprintf("item%d.example.com", i); data.CallbackHost != want {
+					t.Errorf("result %d is for %s, want %s", i, data.CallbackHost, want)
+				}
+			}
+			t.Logf("MTN completion order: %v", completed)
+		})
+	}
+}
+
+func TestDefaultBatchConcurrency(t *testing.T) {
+	tests := []struct {
+		cpus, maxConcurrency int
+		want                 int
+	}{
+		{1, 10, 2},
+		{4, 10, 8},
+		{8, 10, 10},
+		{64, 10, 10},
+		{4, 1, 1},
+		{0, 10, 1},
+	}
+	for _, tt := range tests {
+		got := defaultBatchConcurrency(tt.cpus, tt.maxConcurrency)
+		if got != tt.want {
+			t.Errorf("defaultBatchConcurrency(%d CPUs, cap %d) = %d, want %d", tt.cpus, tt.maxConcurrency, got, tt.want)
+		}
+		if got > tt.maxConcurrency {
+			t.Errorf("defaultBatchConcurrency(%d CPUs, cap %d) = %d exceeds the cap", tt.cpus, tt.maxConcurrency, got)
+		}
+	}
+}
+
+func TestBatchConcurrencyConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want int
+	}{
+		{"capped by MOMO_MAX_CONCURRENCY", map[string]string{"MOMO_MAX_CONCURRENCY": "1"}, 1},
+		{"from the CPU count", map[string]string{"MOMO_MAX_CONCURRENCY": strconv.Itoa(runtime.NumCPU()*2 + 5)}, runtime.NumCPU() * 2},
+		{"overridden", map[string]string{"MOMO_MAX_CONCURRENCY": "1", "BATCH_CONCURRENCY": "6"}, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, err := loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if c.BatchConcurrency != tt.want {
+				t.Errorf("BatchConcurrency = %d, want %d", c.BatchConcurrency, tt.want)
+			}
+		})
+	}
+}
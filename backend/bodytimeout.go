@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// withBodyReadTimeout gives a client d to send the whole request body, so one
+// trickling it byte by byte cannot tie up a handler for the length of its route
+// timeout. The body is read up front; a client still sending at the deadline is
+// answered 408 before any handler runs. SERVER_READ_TIMEOUT remains the hard limit
+// on the connection, and is what finally stops the abandoned read. A zero d disables
+// the deadline. Bodies over maxBytes are answered 413 whether or not d is set.
+func withBodyReadTimeout(next http.Handler, d time.Duration, maxBytes int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+		type readResult struct {
+			body []byte
+			err  error
+		}
+		done := make(chan readResult, 1)
+		go func() {
+			body, err := io.ReadAll(r.Body)
+			done <- readResult{body, err}
+		}()
+
+		// A nil channel never fires, leaving only the size cap when d is zero
+		var deadline <-chan time.Time
+		if d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case res := <-done:
+			var tooLarge *http.MaxBytesError
+			if errors.As(res.err, &tooLarge) {
+				log.Printf("ERROR: %s %s body over MAX_BODY_BYTES (%d)", r.Method, r.URL.Path, maxBytes)
+				w.Header().Set("Connection", "close")
+				sendError(w, r, errBodyTooLarge, fmt.Sprintf("Request body must be at most %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// Any other read error is handed on, so handlers report it as they always have
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(res.body), errorReader{res.err}))
+			next.ServeHTTP(w, r)
+		case <-deadline:
+			log.Printf("ERROR: %s %s body not received within BODY_READ_TIMEOUT (%s)", r.Method, r.URL.Path, d)
+			w.Header().Set("Connection", "close")
+			sendError(w, r, errBodyTimeout, "Request body was not received in time", http.StatusRequestTimeout)
+		case <-r.Context().Done():
+			log.Printf("WARNING: Client went away while sending the %s %s body", r.Method, r.URL.Path)
+		}
+	})
+}
+
+// errorReader returns err, or io.EOF when err is nil
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read([]byte) (int, error) {
+	if e.err == nil {
+		return 0, io.EOF
+	}
+	return 0, e.err
+}
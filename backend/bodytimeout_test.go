@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trickleBody is a request body that sends prefix, then nothing more until closed
+func trickleBody(t *testing.T, prefix string) io.Reader {
+	t.Helper()
+	pr, pw := io.Pipe()
+	go pw.Write([]byte(prefix))
+	// Ending the body lets the middleware's abandoned read finish
+	t.Cleanup(func() { pw.Close() })
+	return pr
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+	tests := []struct {
+		name        string
+		timeout     string
+		slow        bool
+		lang        string
+		wantStatus  int
+		wantMessage string
+	}{
+		{"complete body", "100ms", false, "", http.StatusCreated, ""},
+		{"slow body", "100ms", true, "", http.StatusRequestTimeout, "Request body was not received in time"},
+		{"slow body in French", "100ms", true, "fr", http.StatusRequestTimeout, frenchMessages[errBodyTimeout]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"BODY_READ_TIMEOUT": tt.timeout, "GENERATE_TIMEOUT": "5s"})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			var reader io.Reader = strings.NewReader(body)
+			if tt.slow {
+				reader = trickleBody(t, body[:10])
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/generate", reader)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Language", tt.lang)
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusRequestTimeout {
+				return
+			}
+			// Answered at the body deadline, well before the 5s route timeout
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("answered after %s, want about 100ms", elapsed)
+			}
+			env := decodeEnvelope(t, rec, nil)
+			if env.ErrorCode != errBodyTimeout || env.Message != tt.wantMessage {
+				t.Errorf("error = %s %q, want %s %q", env.ErrorCode, env.Message, errBodyTimeout, tt.wantMessage)
+			}
+			if got := rec.Header().Get("Connection"); got != "close" {
+				t.Errorf("Connection = %q, want close", got)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+	oversized := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":%q}`, testSubscriptionKey, strings.Repeat("a", 200))
+	tests := []struct {
+		name        string
+		env         map[string]string
+		body        string
+		lang        string
+		wantStatus  int
+		wantMessage string
+	}{
+		{"under the cap", map[string]string{"MAX_BODY_BYTES": "128"}, body, "", http.StatusCreated, ""},
+		{"over the cap", map[string]string{"MAX_BODY_BYTES": "128"}, oversized, "", http.StatusRequestEntityTooLarge, "Request body must be at most 128 bytes"},
+		{"over the cap in French", map[string]string{"MAX_BODY_BYTES": "128"}, oversized, "fr", http.StatusRequestEntityTooLarge, frenchMessages[errBodyTooLarge]},
+		{"over the cap without a read timeout", map[string]string{"MAX_BODY_BYTES": "128", "BODY_READ_TIMEOUT": "0"}, oversized, "", http.StatusRequestEntityTooLarge, "Request body must be at most 128 bytes"},
+		{"default cap", nil, oversized, "", http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", tt.body, "Accept-Language", tt.lang)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusRequestEntityTooLarge {
+				return
+			}
+			env := decodeEnvelope(t, rec, nil)
+			if env.ErrorCode != errBodyTooLarge || env.Message != tt.wantMessage {
+				t.Errorf("error = %s %q, want %s %q", env.ErrorCode, env.Message, errBodyTooLarge, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytesConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"positive", "4096", false},
+		{"zero", "0", true},
+		{"negative", "-1", true},
+		{"not a number", "1MB", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_BODY_BYTES", tt.value)
+			if _, err := loadConfig(); (err != nil) != tt.wantErr {
+				t.Errorf("loadConfig err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Capabilities describes the optional features enabled on this server, so clients
+// can adapt to its configuration. It must only ever carry non-secret settings.
+type Capabilities struct {
+	Version            string   `json:"version"`
+	Products           []string `json:"products"`
+	TargetEnvironments []string `json:"targetEnvironments"`
+	ResponseFormats    []string `json:"responseFormats"`
+	Base64Formats      []string `json:"base64Formats"`
+	Languages          []string `json:"languages"`
+	NamingStyle        string   `json:"namingStyle"`
+	DateTimeFormat     string   `json:"dateTimeFormat"`
+	MaxKeysPerUser     int      `json:"maxKeysPerUser"`
+
+	// FallbackEnabled reports that credentials are generated locally when MTN fails;
+	// ForceFallback whether clients may request that with forceFallback (DEV_MODE)
+	FallbackEnabled bool `json:"fallbackEnabled"`
+	ForceFallback   bool `json:"forceFallback"`
+
+	// FallbackTargetEnvironments are where fallback applies (FALLBACK_TARGET_ENVS, "*" for all)
+	FallbackTargetEnvironments []string `json:"fallbackTargetEnvironments"`
+
+	// ServerSubscriptionKey reports whether requests may omit the subscription key
+	ServerSubscriptionKey bool `json:"serverSubscriptionKey"`
+	RequireCallbackHost   bool `json:"requireCallbackHost"`
+	Maintenance           bool `json:"maintenance"`
+
+	RateLimit RateLimitCapability `json:"rateLimit"`
+	Batch     BatchCapability     `json:"batch"`
+}
+
+// RateLimitCapability is the /api/generate rate limit
+type RateLimitCapability struct {
+	Enabled       bool `json:"enabled"`
+	PerWindow     int  `json:"perWindow"`
+	WindowSeconds int  `json:"windowSeconds"`
+}
+
+// BatchCapability is the /api/generate/batch configuration
+type BatchCapability struct {
+	MaxItems             int  `json:"maxItems"`
+	Concurrency          int  `json:"concurrency"`
+	MaxConcurrentBatches int  `json:"maxConcurrentBatches"`
+	Streaming            bool `json:"streaming"`
+}
+
+// currentCapabilities builds the capabilities document from the running configuration
+func currentCapabilities() Capabilities {
+	return Capabilities{
+		Version:               version,
+		Products:              momoProducts,
+		TargetEnvironments:    cfg.AllowedTargetEnvs,
+		ResponseFormats:       []string{"json", "env"},
+		Base64Formats:         base64Formats,
+		Languages:             []string{langEnglish, langFrench},
+		NamingStyle:           cfg.NamingStyle,
+		DateTimeFormat:        cfg.DateTimeFormat,
+		MaxKeysPerUser:        maxKeysPerUser,
+		FallbackEnabled:       len(cfg.FallbackTargetEnvs) > 0,
+		ForceFallback:         cfg.DevMode,
+		ServerSubscriptionKey: cfg.SecretProvider != secretProviderEnv || cfg.SubscriptionKey != "",
+		RequireCallbackHost:   cfg.RequireCallbackHost,
+		Maintenance:           maintenanceMode.Load(),
+
+		FallbackTargetEnvironments: cfg.FallbackTargetEnvs,
+		RateLimit: RateLimitCapability{
+			Enabled:       liveConfig().RateLimitPerMinute > 0,
+			PerWindow:     liveConfig().RateLimitPerMinute,
+			WindowSeconds: int(rateLimitWindow / time.Second),
+		},
+		Batch: BatchCapability{
+			MaxItems:             cfg.BatchMaxItems,
+			Concurrency:          cfg.BatchConcurrency,
+			MaxConcurrentBatches: cfg.MaxConcurrentBatches,
+			Streaming:            true,
+		},
+	}
+}
+
+// handleCapabilities returns the capabilities document
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, true, "Server capabilities", currentCapabilities(), http.StatusOK)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCapabilitiesReflectConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, caps Capabilities)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, caps Capabilities) {
+				if !reflect.DeepEqual(caps.TargetEnvironments, []string{"sandbox"}) {
+					t.Errorf("targetEnvironments = %v, want [sandbox]", caps.TargetEnvironments)
+				}
+				if !reflect.DeepEqual(caps.Products, momoProducts) {
+					t.Errorf("products = %v, want %v", caps.Products, momoProducts)
+				}
+				if caps.RateLimit.Enabled || caps.ForceFallback || caps.Maintenance || caps.ServerSubscriptionKey {
+					t.Errorf("optional features enabled by default: %+v", caps)
+				}
+			},
+		},
+		{
+			name: "target environments and fallback",
+			env:  map[string]string{"ALLOWED_TARGET_ENVS": "sandbox,mtnghana", "FALLBACK_TARGET_ENVS": "sandbox", "DEV_MODE": "true"},
+			check: func(t *testing.T, caps Capabilities) {
+				if !reflect.DeepEqual(caps.TargetEnvironments, []string{"sandbox", "mtnghana"}) {
+					t.Errorf("targetEnvironments = %v, want [sandbox mtnghana]", caps.TargetEnvironments)
+				}
+				if !caps.FallbackEnabled || !reflect.DeepEqual(caps.FallbackTargetEnvironments, []string{"sandbox"}) {
+					t.Errorf("fallback = %t %v, want enabled for [sandbox]", caps.FallbackEnabled, caps.FallbackTargetEnvironments)
+				}
+				if !caps.ForceFallback {
+					t.Error("forceFallback = false with DEV_MODE on")
+				}
+			},
+		},
+		{
+			name: "fallback disabled",
+			env:  map[string]string{"FALLBACK_TARGET_ENVS": "none"},
+			check: func(t *testing.T, caps Capabilities) {
+				if caps.FallbackEnabled {
+					t.Error("fallbackEnabled = true with FALLBACK_TARGET_ENVS=none")
+				}
+			},
+		},
+		{
+			name: "rate limit and batches",
+			env:  map[string]string{"RATE_LIMIT_PER_MINUTE": "30", "BATCH_MAX_ITEMS": "10", "BATCH_CONCURRENCY": "3", "MAX_CONCURRENT_BATCHES": "5"},
+			check: func(t *testing.T, caps Capabilities) {
+				if want := (RateLimitCapability{Enabled: true, PerWindow: 30, WindowSeconds: 60}); caps.RateLimit != want {
+					t.Errorf("rateLimit = %+v, want %+v", caps.RateLimit, want)
+				}
+				if want := (BatchCapability{MaxItems: 10, Concurrency: 3, MaxConcurrentBatches: 5, Streaming: true}); caps.Batch != want {
+					t.Errorf("batch = %+v, want %+v", caps.Batch, want)
+				}
+			},
+		},
+		{
+			name: "server subscription key",
+			env:  map[string]string{"MOMO_SUBSCRIPTION_KEY": testSubscriptionKey, "REQUIRE_CALLBACK_HOST": "true", "MAINTENANCE_MODE": "true"},
+			check: func(t *testing.T, caps Capabilities) {
+				if !caps.ServerSubscriptionKey || !caps.RequireCallbackHost || !caps.Maintenance {
+					t.Errorf("serverSubscriptionKey, requireCallbackHost, maintenance = %t, %t, %t; want all true",
+						caps.ServerSubscriptionKey, caps.RequireCallbackHost, caps.Maintenance)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			rec := doRequest(h, http.MethodGet, "/api/capabilities", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+			}
+			// The document is public, so it must never carry the secrets behind the settings
+			if strings.Contains(rec.Body.String(), testSubscriptionKey) {
+				t.Errorf("capabilities expose the subscription key: %s", rec.Body)
+			}
+			var caps Capabilities
+			decodeEnvelope(t, rec, &caps)
+			tt.check(t, caps)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// captureStdout is the REQUEST_CAPTURE value that writes captured requests to stdout
+const captureStdout = "stdout"
+
+// CapturedRequest is one line of the REQUEST_CAPTURE log: just enough to re-issue a
+// generation with the replay subcommand. The subscription key is never recorded.
+type CapturedRequest struct {
+	Timestamp         time.Time `json:"timestamp"`
+	CallbackHost      string    `json:"callbackHost"`
+	Product           string    `json:"product,omitempty"`
+	TargetEnvironment string    `json:"targetEnvironment"`
+	KeyCount          int       `json:"keyCount"`
+}
+
+// captureSink appends captured requests to a file or stdout as JSON lines
+type captureSink struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+// requestCapture is the REQUEST_CAPTURE sink, if capture is enabled
+var requestCapture *captureSink
+
+// openRequestCapture opens the capture sink for dest ("stdout" or a file path)
+func openRequestCapture(dest string) (*captureSink, error) {
+	if dest == captureStdout {
+		return &captureSink{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &captureSink{w: f, c: f}, nil
+}
+
+// Record appends one captured request. Capture failures are logged but never fail the request.
+func (s *captureSink) Record(entry CapturedRequest) {
+	if s == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode captured request: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		log.Printf("ERROR: Failed to write captured request: %v", err)
+	}
+}
+
+// Close closes the capture file, if any
+func (s *captureSink) Close() error {
+	if s == nil || s.c == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.Close()
+}
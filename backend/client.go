@@ -0,0 +1,267 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// momoBaseURL is the MTN MoMo API host all outbound calls are made against
+var momoBaseURL = "https://sandbox.momodeveloper.mtn.com"
+
+// productionBaseURL is MTN's production MoMo API host, shown in productionTestCommand.
+// Production API users are issued through the MTN partner portal, not by this server.
+const productionBaseURL = "https://proxy.momoapi.mtn.com"
+
+// provisioningURL builds a URL on MTN's versioned provisioning API (apiuser, apikey)
+// using the configured MOMO_API_VERSION
+func provisioningURL(path string) string {
+	return momoBaseURL + "/" + cfg.APIVersion + path
+}
+
+// tokenURL returns the collection token endpoint used to test credentials.
+// MTN serves token endpoints per product, without a version segment.
+func tokenURL() string {
+	return productTokenURL("collection")
+}
+
+// productTokenURL returns the token endpoint of an MTN product (collection, disbursement, remittance)
+func productTokenURL(product string) string {
+	return momoBaseURL + "/" + product + "/token/"
+}
+
+// productURL returns the URL of path under an MTN product's MOMO_API_VERSION API
+func productURL(product, path string) string {
+	return momoBaseURL + "/" + product + "/" + cfg.APIVersion + path
+}
+
+// momoHTTPClient is the shared client for all outbound calls to the MTN MoMo API.
+// Sharing one transport lets connections be pooled and reused across requests.
+// It is created once, from the loaded configuration, by initMomoHTTPClient.
+var (
+	momoHTTPClient     *http.Client
+	momoHTTPClientOnce sync.Once
+)
+
+// initMomoHTTPClient creates the shared client from c. Only the first call has any
+// effect, so it is safe even if several goroutines initialize it during startup.
+func initMomoHTTPClient(c Config) {
+	momoHTTPClientOnce.Do(func() {
+		momoHTTPClient = newMomoHTTPClient(c)
+	})
+}
+
+// momoClient returns the shared client, creating it from cfg on first use
+func momoClient() *http.Client {
+	initMomoHTTPClient(cfg)
+	return momoHTTPClient
+}
+
+// newMomoHTTPClient creates the outbound client. HTTP/2 is negotiated through TLS ALPN
+// whenever MTN supports it, so concurrent calls can share a single connection.
+func newMomoHTTPClient(c Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	// Same dial settings as http.DefaultTransport, plus a separate bound on DNS resolution
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialWithDNSTimeout(dialer, c.DNSTimeout)
+	// The client certificate is presented to the fallback gateway too, which is
+	// usually part of the same enterprise setup
+	if tlsConfig, err := loadMomoTLSConfig(c.MomoClientCertFile, c.MomoClientKeyFile, c.MomoCAFile); err != nil {
+		log.Printf("ERROR: Failed to load the MTN client TLS files, using default TLS settings: %v", err)
+	} else if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+		log.Printf("MTN client TLS: certificate %s, custom CA %t", momoClientCertSummary(tlsConfig), tlsConfig.RootCAs != nil)
+	}
+
+	if c.FallbackBaseURL != "" {
+		return &http.Client{Transport: newFailoverTransport(transport, c.FallbackBaseURL), Timeout: c.MomoTimeout}
+	}
+	return &http.Client{Transport: transport, Timeout: c.MomoTimeout}
+}
+
+// dialWithDNSTimeout returns a DialContext that resolves the host within dnsTimeout
+// before dialing, so a flaky resolver fails fast instead of using up the whole
+// request timeout. A zero dnsTimeout dials directly.
+func dialWithDNSTimeout(dialer *net.Dialer, dnsTimeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dnsTimeout == 0 {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(lookupCtx, host)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		// Try each resolved address in turn, like the standard dialer does
+		var conn net.Conn
+		for _, ip := range addrs {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// warmUpMomoConnection makes a lightweight HEAD request to the MTN MoMo host so the
+// TLS handshake is done (and the connection pooled) before the first real request
+func warmUpMomoConnection(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", momoBaseURL+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := momoClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection is returned to the pool for reuse
+	io.Copy(io.Discard, resp.Body)
+	logNegotiatedProtocol(resp)
+	return nil
+}
+
+// waitForMTNPolicy is the backoff between startup reachability probes
+var waitForMTNPolicy = retryPolicy{BaseDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+// waitForMTN probes the MTN MoMo host until it answers (any HTTP status counts) or
+// ctx expires, backing off between attempts. It smooths cold starts where MTN or DNS
+// is not reachable yet when the container starts.
+func waitForMTN(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		probeCtx, cancel := context.WithTimeout(ctx, cfg.MomoTimeout)
+		err := warmUpMomoConnection(probeCtx)
+		cancel()
+		if err == nil {
+			log.Printf("MTN MoMo reachable after %d attempt(s)", attempt)
+			return nil
+		}
+
+		delay := waitForMTNPolicy.backoff(attempt, nil)
+		log.Printf("WAIT: MTN MoMo not reachable yet (attempt %d): %v; retrying in %s", attempt, err, delay)
+		if err := retrySleep(ctx, delay); err != nil {
+			return fmt.Errorf("MTN MoMo still unreachable after %d attempt(s): %w", attempt, err)
+		}
+	}
+}
+
+// momoSemaphore bounds the number of concurrent outbound calls to the MTN MoMo API
+var momoSemaphore = make(chan struct{}, defaultConfig().MaxConcurrency)
+
+// initMomoSemaphore resizes the outbound concurrency limit; call before serving requests
+func initMomoSemaphore(limit int) {
+	momoSemaphore = make(chan struct{}, limit)
+}
+
+// acquireMomoSlot blocks until an outbound call slot is free and returns its release func
+func acquireMomoSlot() func() {
+	momoSemaphore <- struct{}{}
+	return func() { <-momoSemaphore }
+}
+
+// protocolLogOnce ensures the negotiated protocol is only logged for the first connection
+var protocolLogOnce sync.Once
+
+// logNegotiatedProtocol logs which HTTP protocol the first MTN MoMo response used
+func logNegotiatedProtocol(resp *http.Response) {
+	protocolLogOnce.Do(func() {
+		log.Printf("First connection to MTN MoMo API negotiated %s (HTTP/2: %t)", resp.Proto, resp.ProtoMajor == 2)
+	})
+}
+
+// momoAPIError is returned when the MTN MoMo API responds with an unexpected status
+type momoAPIError struct {
+	Operation  string // e.g. "create API user"
+	StatusCode int
+	Body       string // Redacted and shaped by formatErrorBody; empty when omitted
+}
+
+func (e *momoAPIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("failed to %s, status: %d", e.Operation, e.StatusCode)
+	}
+	return fmt.Sprintf("failed to %s: %s, status: %d", e.Operation, e.Body, e.StatusCode)
+}
+
+// responseBody returns the body of an MTN response, transparently decompressing it
+// when MTN (or a proxy in front of it) sent it gzip-encoded
+func responseBody(resp *http.Response) (io.Reader, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body, nil
+	}
+	return gzip.NewReader(resp.Body)
+}
+
+// readErrorBody reads a (possibly gzip-encoded) MTN error body for logs and error
+// messages. Non-JSON bodies, typically a gateway's HTML 502/503 page during an
+// outage, are summarized rather than returned whole (see summarizeErrorBody).
+func readErrorBody(resp *http.Response) []byte {
+	r, err := responseBody(resp)
+	if err != nil {
+		log.Printf("WARNING: Failed to decompress gzip-encoded MTN response body: %v", err)
+		return nil
+	}
+	body, _ := io.ReadAll(r)
+	if contentType := resp.Header.Get("Content-Type"); !isJSONContentType(contentType) && len(body) > 0 {
+		return []byte(summarizeErrorBody(resp.StatusCode, contentType, body))
+	}
+	return body
+}
+
+// maxSummaryLineLength caps the first line quoted in a non-JSON error body summary
+const maxSummaryLineLength = 120
+
+// isJSONContentType reports whether an error response claims to be JSON. MTN
+// sometimes omits the header on JSON errors, so a missing Content-Type counts as JSON.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// htmlTitlePattern extracts the <title> of an HTML error page
+var htmlTitlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// summarizeErrorBody describes a non-JSON error body by status, content type and its
+// first meaningful line: the <title> of an HTML page, or else its first non-blank line
+func summarizeErrorBody(status int, contentType string, body []byte) string {
+	line := ""
+	if m := htmlTitlePattern.FindSubmatch(body); m != nil {
+		line = strings.Join(strings.Fields(string(m[1])), " ")
+	} else {
+		for _, l := range strings.Split(string(body), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				line = l
+				break
+			}
+		}
+	}
+	if len(line) > maxSummaryLineLength {
+		line = line[:maxSummaryLineLength] + "..."
+	}
+	return fmt.Sprintf("non-JSON error body (status %d, %s, %d bytes): %q", status, contentType, len(body), line)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	next     http.RoundTripper
+	requests atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return c.next.RoundTrip(r)
+}
+
+func TestWarmUpUsesSharedClient(t *testing.T) {
+	h := setupTest(t, nil)
+	srv := httptest.NewUnstartedServer(mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	var conns atomic.Int64
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	base := momoBaseURL
+	momoBaseURL = srv.URL
+	t.Cleanup(func() { momoBaseURL = base })
+
+	counter := &countingTransport{next: momoClient().Transport}
+	momoClient().Transport = counter
+
+	if err := warmUpMomoConnection(context.Background()); err != nil {
+		t.Fatalf("warmUpMomoConnection: %v", err)
+	}
+	if got := counter.requests.Load(); got != 1 {
+		t.Fatalf("shared client sent %d requests during warm-up, want 1", got)
+	}
+
+	// The generation after warm-up reuses the warmed connection
+	generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if got := counter.requests.Load(); got != 3 {
+		t.Errorf("shared client sent %d requests in total, want 3 (warm-up, user, key)", got)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("MTN stub accepted %d connections, want the warmed one only", got)
+	}
+}
+
+func TestInitMomoHTTPClientConcurrent(t *testing.T) {
+	setupTest(t, nil)
+	resetMomoHTTPClient()
+
+	const goroutines = 32
+	clients := make([]*http.Client, goroutines)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if i%2 == 0 {
+				initMomoHTTPClient(cfg)
+			}
+			clients[i] = momoClient()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, c := range clients {
+		if c == nil || c != clients[0] {
+			t.Fatalf("goroutine %d got client %p, want the single shared client %p", i, c, clients[0])
+		}
+	}
+	if clients[0].Timeout != cfg.MomoTimeout {
+		t.Errorf("shared client timeout = %s, want MOMO_TIMEOUT %s", clients[0].Timeout, cfg.MomoTimeout)
+	}
+}
+
+func TestProvisioningURLsUseConfiguredAPIVersion(t *testing.T) {
+	for _, version := range []string{"v1_0", "v2_0"} {
+		t.Run(version, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"MOMO_API_VERSION": version})
+			var mu sync.Mutex
+			var paths []string
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				paths = append(paths, r.URL.Path)
+				mu.Unlock()
+				success(w, r)
+			}))
+
+			generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if len(paths) != 2 {
+				t.Fatalf("MTN stub saw %v, want the user and key calls", paths)
+			}
+			for _, p := range paths {
+				if !strings.HasPrefix(p, "/"+version+"/apiuser") {
+					t.Errorf("MTN call to %s, want it under /%s/apiuser", p, version)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidAPIVersionRejected(t *testing.T) {
+	t.Setenv("MOMO_API_VERSION", "1.0")
+	if _, err := loadConfig(); err == nil {
+		t.Error("loadConfig accepted MOMO_API_VERSION=1.0")
+	}
+}
+
+func TestGzipEncodedErrorBody(t *testing.T) {
+	const message = "Callback host is not whitelisted"
+	setupTest(t, nil)
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusBadRequest)
+		gz := gzip.NewWriter(w)
+		fmt.Fprintf(gz, `{"code":"INVALID_CALLBACK_HOST","message":%q}`, message)
+		gz.Close()
+	}))
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"create API user", func() error {
+			_, _, err := createAPIUser(context.Background(), testSubscriptionKey, "example.com", "")
+			return err
+		}},
+		{"create API key", func() error {
+			_, _, err := createAPIKey(context.Background(), testSubscriptionKey, "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if err == nil {
+				t.Fatal("expected an error from the 400 response")
+			}
+			if !strings.Contains(err.Error(), message) {
+				t.Errorf("error %q does not contain the decompressed message", err)
+			}
+		})
+	}
+}
+
+// The transport only decompresses responses to requests it added Accept-Encoding to,
+// so readErrorBody must handle bodies a proxy gzipped regardless
+func TestReadErrorBodyDecompressesGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"message":"Conflict"}`))
+	gz.Close()
+	resp := &http.Response{
+		StatusCode: http.StatusConflict,
+		Header:     http.Header{"Content-Encoding": {"gzip"}, "Content-Type": {"application/json"}},
+		Body:       io.NopCloser(&buf),
+	}
+	if got := string(readErrorBody(resp)); got != `{"message":"Conflict"}` {
+		t.Errorf("readErrorBody = %q", got)
+	}
+}
+
+func TestDNSTimeoutFailsPromptly(t *testing.T) {
+	const dnsTimeout = 200 * time.Millisecond
+	client := newMomoHTTPClient(Config{DNSTimeout: dnsTimeout, MomoTimeout: 30 * time.Second})
+
+	start := time.Now()
+	resp, err := client.Get("http://momo-sandbox.invalid/")
+	elapsed := time.Since(start)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("request to an unresolvable host succeeded")
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Errorf("error = %v, want a DNS error", err)
+	}
+	// Well under MomoTimeout: resolution is bounded by DNS_TIMEOUT alone
+	if elapsed > 10*dnsTimeout {
+		t.Errorf("DNS failure took %s with DNS_TIMEOUT=%s", elapsed, dnsTimeout)
+	}
+}
+
+func TestDialWithDNSTimeoutSkipsLookupForIPs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dial := dialWithDNSTimeout(&net.Dialer{}, time.Nanosecond)
+	conn, err := dial(context.Background(), "tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing %s: %v", srv.Listener.Addr(), err)
+	}
+	conn.Close()
+}
+
+func TestWaitForMTN(t *testing.T) {
+	tests := []struct {
+		name     string
+		onlineAt time.Duration // 0 never comes online
+		wantErr  bool
+	}{
+		{"comes online after a delay", 100 * time.Millisecond, false},
+		{"never comes online", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, nil)
+			// Reserve an address, leaving it closed until the stub comes online
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			addr := l.Addr().String()
+			l.Close()
+			base := momoBaseURL
+			momoBaseURL = "http://" + addr
+			t.Cleanup(func() { momoBaseURL = base })
+
+			var attempts atomic.Int32
+			retrySleep = func(ctx context.Context, d time.Duration) error {
+				attempts.Add(1)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+					return nil
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			result := make(chan error, 1)
+			go func() { result <- waitForMTN(ctx) }()
+
+			if tt.onlineAt > 0 {
+				time.Sleep(tt.onlineAt)
+				l, err := net.Listen("tcp", addr)
+				if err != nil {
+					t.Fatalf("bringing the stub online at %s: %v", addr, err)
+				}
+				srv := httptest.NewUnstartedServer(mtnStatus(http.StatusNotFound))
+				srv.Listener.Close()
+				srv.Listener = l
+				srv.Start()
+				defer srv.Close()
+			}
+
+			err = <-result
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("waitForMTN = %v, want error %t", err, tt.wantErr)
+			}
+			if attempts.Load() == 0 {
+				t.Error("waitForMTN never backed off while the stub was offline")
+			}
+		})
+	}
+}
+
+func TestNonJSONErrorBodySummarized(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html><head><title>502 Bad
+  Gateway</title></head>
+<body><center><h1>502 Bad Gateway</h1></center><hr><center>nginx</center>
+` + "<!-- padding -->\n"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		notWant     string
+	}{
+		{"html page", "text/html", page, `non-JSON error body (status 502, text/html, ` + fmt.Sprint(len(page)) + ` bytes): "502 Bad Gateway"`, "<center>"},
+		{"plain text", "text/plain", "\n\nupstream connect error\nreset reason: overflow\n", `"upstream connect error"`, "overflow"},
+		{"long line", "text/plain", strings.Repeat("x", 200), `"` + strings.Repeat("x", maxSummaryLineLength) + `..."`, ""},
+		{"json kept whole", "application/json", `{"code":"BAD_GATEWAY","message":"upstream"}`, `{"code":"BAD_GATEWAY","message":"upstream"}`, "non-JSON"},
+		{"problem json kept whole", "application/problem+json", `{"title":"upstream"}`, `{"title":"upstream"}`, "non-JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Header:     http.Header{"Content-Type": {tt.contentType}},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			got := string(readErrorBody(resp))
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("readErrorBody = %s, want it to contain %s", got, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("readErrorBody = %s, want no %q", got, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestGenerateSummarizesHTMLErrorPage(t *testing.T) {
+	setupTest(t, nil)
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "<html><head><title>400 Bad Request</title></head><body>"+strings.Repeat("<p>filler</p>", 100)+"</body></html>")
+	}))
+
+	_, _, err := createAPIUser(context.Background(), testSubscriptionKey, "example.com", "")
+	if err == nil || !strings.Contains(err.Error(), `"400 Bad Request"`) || strings.Contains(err.Error(), "filler") {
+		t.Errorf("error = %v, want the page title summarized", err)
+	}
+}
@@ -0,0 +1,873 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the backend settings read from environment variables at startup
+type Config struct {
+	Port string
+	// ListenSocket, when set, is a Unix socket path to listen on instead of the TCP port
+	ListenSocket string
+	// ShutdownTimeout bounds how long in-flight requests may take to finish on shutdown
+	ShutdownTimeout time.Duration
+
+	// APIBasePath prefixes every route (e.g. "/momo") for path-based reverse proxies
+	APIBasePath string
+
+	// MaxCallbackHostLength is the longest callbackHost accepted, matching MTN's hostname limit
+	MaxCallbackHostLength int
+
+	// ServerTimezone, when set, adds a local serverTime next to the UTC dateTime in responses
+	ServerTimezone *time.Location
+
+	// MetricsLatencyBuckets are the histogram buckets (seconds) for MTN call latency
+	MetricsLatencyBuckets []float64
+
+	// SuccessStatusCode is the HTTP status of a successful /api/generate response
+	SuccessStatusCode int
+
+	// GenerateDedupWindow is how long a generate result is reused for repeat requests
+	// with the same callback host and subscription key; 0 disables deduplication
+	GenerateDedupWindow time.Duration
+
+	// AllowedTargetEnvs are the target environments requests may ask for. Only sandbox
+	// is allowed unless ALLOWED_TARGET_ENVS explicitly lists others (such as mtnghana);
+	// each must be one of momoMarkets.
+	AllowedTargetEnvs []string
+
+	// FallbackTargetEnvs are the target environments where a failed MTN call falls back
+	// to local generation; elsewhere it fails the request, since fake credentials are
+	// dangerous in production. "*" allows every environment, none disables fallback.
+	FallbackTargetEnvs []string
+
+	// NamingStyle is the JSON field naming of generate responses (camel or snake)
+	NamingStyle string
+
+	// DateTimeFormat is the preset the dateTime of generate responses is written in
+	// (rfc3339, iso-basic or unix)
+	DateTimeFormat string
+
+	// CORSAllowedOrigins are the browser origins allowed to call the API
+	CORSAllowedOrigins []string
+
+	// ConfigFile is a KEY=VALUE file read into the environment at startup and on
+	// /api/admin/reload; variables set in the real environment take precedence
+	ConfigFile string
+
+	// AccessLogFormat enables access logs in common or combined format; empty disables them
+	AccessLogFormat string
+
+	// LogOutput is a file the log is appended to instead of stderr, if set
+	LogOutput string
+
+	// RequestCapture is where generation requests are captured for replay: "stdout" or
+	// a file path the capture is appended to. Empty disables capture.
+	RequestCapture string
+
+	// IncludeSubscriptionKeySuffix adds the last 4 characters of the subscription key
+	// used to generate responses, never more
+	IncludeSubscriptionKeySuffix bool
+
+	// StrictJSONKeys rejects request bodies that repeat a top-level JSON key instead of
+	// silently keeping the last value
+	StrictJSONKeys bool
+
+	// LogTestCommand logs the generated test curl command, which embeds the base64
+	// credentials and subscription key. Off by default.
+	LogTestCommand bool
+
+	// StrictKeyValidation rejects requests whose secondary key equals the primary key,
+	// instead of only warning
+	StrictKeyValidation bool
+
+	// ResponseSigningKey, when set, is the HMAC-SHA256 key for X-Signature response headers
+	ResponseSigningKey string
+
+	// StoreMaxRecords caps the local credential store; least recently used records are
+	// evicted beyond it. 0 means unbounded.
+	StoreMaxRecords int
+
+	// FallbackKeyBytes is the random entropy of locally generated API keys, in bytes
+	// (the hex key is twice as long)
+	FallbackKeyBytes int
+
+	// RequireCallbackHost rejects generate requests without a callback host instead of
+	// defaulting to example.com
+	RequireCallbackHost bool
+
+	// RequireHTTPS rejects requests that did not arrive over HTTPS. As the server does
+	// not terminate TLS, that is judged by X-Forwarded-Proto, trusted only from
+	// TrustedProxies.
+	RequireHTTPS   bool
+	TrustedProxies []netip.Prefix
+
+	// NormalizeCallbackHost lowercases callback hosts and strips a trailing dot before
+	// they are sent to MTN; off by default so the exact input is registered
+	NormalizeCallbackHost bool
+
+	// ResponseTransformer names the registered ResponseTransformer applied to response payloads
+	ResponseTransformer string
+
+	// EventPublisher names the registered EventPublisher generation events are sent to
+	EventPublisher string
+
+	// AlertWebhookURL, when set, is POSTed an alert whenever generation falls back to
+	// local credentials because MTN failed, at most once per AlertMinInterval
+	AlertWebhookURL  string
+	AlertMinInterval time.Duration
+
+	// ReferenceIDFormat names the registered ReferenceIDFormat new API user IDs
+	// (X-Reference-Id) are generated and validated with
+	ReferenceIDFormat string
+
+	// RateLimitPerMinute caps /api/generate requests per client IP per minute; 0 disables it
+	RateLimitPerMinute int
+
+	// SubscriptionKey is the server-side fallback subscription key, used when a request has none
+	SubscriptionKey string
+
+	// SecretProvider is where the server-side subscription key comes from: env
+	// (MOMO_SUBSCRIPTION_KEY), vault or aws. Secret manager keys are read from
+	// SecretField and refreshed every SecretRefreshInterval.
+	SecretProvider        string
+	SecretField           string
+	SecretRefreshInterval time.Duration
+	VaultAddr             string
+	VaultToken            string
+	VaultSecretPath       string
+	AWSRegion             string
+	AWSSecretID           string
+
+	// DevMode enables developer-only features such as ?debug=true; never enable in production
+	DevMode bool
+
+	// DebugHTTP logs redacted request and response bodies for every request
+	DebugHTTP bool
+
+	// AdminAPIToken is the bearer token required by admin-only endpoints; empty disables them
+	AdminAPIToken string
+
+	// ErrorBodyLogMode controls how MTN error bodies are logged: full, truncate or omit
+	ErrorBodyLogMode string
+	// ErrorBodyLogBytes is the truncation length used in truncate mode
+	ErrorBodyLogBytes int
+
+	// MaxConcurrency bounds the number of simultaneous outbound calls to MTN
+	MaxConcurrency int
+
+	// Batch generation: BatchMaxItems caps the items of one batch, BatchConcurrency the
+	// items of a batch run at once, and MaxConcurrentBatches the batch jobs running at once.
+	// BatchConcurrency defaults from the CPU count and MaxConcurrency (defaultBatchConcurrency).
+	BatchMaxItems        int
+	BatchConcurrency     int
+	MaxConcurrentBatches int
+
+	// BatchMultiStatus answers a batch whose items had different outcomes (some failed,
+	// or some fell back to local generation) with 207 Multi-Status instead of 200
+	BatchMultiStatus bool
+
+	// Retry controls how failed MTN calls are retried
+	Retry retryPolicy
+
+	// ResetRetries are extra retries of idempotent MTN calls whose connection was reset
+	// (EOF, ECONNRESET), made even once Retry's are spent or disabled
+	ResetRetries int
+
+	// PropagationRetry controls how key creation is retried when MTN answers 404 for a
+	// user created moments ago, before it has propagated (jitter is not used)
+	PropagationRetry retryPolicy
+
+	// GenerateRetryBudget caps the retries shared by all MTN calls of one generate
+	// request (user and key creation); 0 leaves each call to MOMO_MAX_RETRIES alone.
+	// GenerateTimeBudget bounds the MTN calls of one generate request as a whole; 0 disables it.
+	GenerateRetryBudget int
+	GenerateTimeBudget  time.Duration
+
+	// APIVersion is the version segment of MTN provisioning URLs, e.g. v1_0
+	APIVersion string
+
+	// MomoClientCertFile and MomoClientKeyFile are the PEM client certificate and key
+	// presented to MTN gateways requiring mutual TLS; MomoCAFile is a PEM CA bundle
+	// trusted on top of the system roots. See loadMomoTLSConfig.
+	MomoClientCertFile string
+	MomoClientKeyFile  string
+	MomoCAFile         string
+
+	// FallbackBaseURL is an MTN-compatible gateway tried when the MTN base URL cannot
+	// be reached or fails server-side, before falling back to local generation
+	FallbackBaseURL string
+
+	// MomoTimeout bounds a single outbound call (one attempt) to MTN
+	MomoTimeout time.Duration
+
+	// GenerateTimeout bounds handlers that call MTN (/api/generate, subscription validation);
+	// RouteTimeout bounds the other non-streaming routes. 0 disables either bound.
+	GenerateTimeout time.Duration
+	RouteTimeout    time.Duration
+
+	// MaxRequestDuration is the server-wide cap on any request, streaming ones included,
+	// enforced as a context deadline behind the route timeouts; 0 disables it
+	MaxRequestDuration time.Duration
+
+	// DNSTimeout bounds resolving the MTN host, within MomoTimeout; 0 leaves it unbounded
+	DNSTimeout time.Duration
+
+	// WaitForMTN delays serving until MTN is reachable, for up to WaitForMTNTimeout
+	WaitForMTN        bool
+	WaitForMTNTimeout time.Duration
+
+	// MaintenanceMode starts the server with /api/generate answering 503 (it can be
+	// toggled at runtime via /api/admin/maintenance); clients are told to retry after
+	// MaintenanceRetryAfter
+	MaintenanceMode       bool
+	MaintenanceRetryAfter time.Duration
+
+	// Warmup makes a startup request to MTN so the first real request skips the TLS handshake
+	Warmup bool
+
+	// Server timeouts. WriteTimeout must exceed the time MTN calls can take
+	// (MomoTimeout per attempt, plus retries), or slow-but-valid responses are cut off.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// BodyReadTimeout bounds reading the request body, answering 408 when it runs out;
+	// it should be shorter than ReadTimeout, which drops the connection instead
+	BodyReadTimeout time.Duration
+	// MaxBodyBytes caps the request body, answering 413 beyond it
+	MaxBodyBytes int
+}
+
+// apiVersionPattern matches MTN API version segments such as v1_0 or v2_1
+var apiVersionPattern = regexp.MustCompile(`^v[0-9]+_[0-9]+$`)
+
+// cfg is the configuration loaded in main
+var cfg = defaultConfig()
+
+// defaultConfig returns the configuration used when no environment overrides are set
+func defaultConfig() Config {
+	return Config{
+		Port:                  "8080",
+		ShutdownTimeout:       30 * time.Second,
+		SuccessStatusCode:     201,
+		MaxCallbackHostLength: 253, // Maximum length of a DNS hostname
+		AllowedTargetEnvs:     []string{defaultTargetEnv},
+		FallbackTargetEnvs:    []string{defaultTargetEnv},
+		NamingStyle:           namingCamel,
+		DateTimeFormat:        dateTimeRFC3339,
+		ResponseTransformer:   "none",
+		EventPublisher:        "none",
+		AlertMinInterval:      5 * time.Minute,
+		ReferenceIDFormat:     "uuid4",
+		SecretProvider:        secretProviderEnv,
+		SecretField:           "subscriptionKey",
+		MetricsLatencyBuckets: defaultLatencyBuckets,
+		ErrorBodyLogMode:      errorBodyTruncate,
+		ErrorBodyLogBytes:     512,
+		MaxConcurrency:        10,
+		StoreMaxRecords:       10000,
+		FallbackKeyBytes:      minFallbackKeyBytes,
+		BatchMaxItems:         50,
+		MaxConcurrentBatches:  2,
+		Retry: retryPolicy{
+			MaxRetries: 2,
+			BaseDelay:  200 * time.Millisecond,
+			MaxDelay:   5 * time.Second,
+			Jitter:     true,
+		},
+		ResetRetries: 1,
+		PropagationRetry: retryPolicy{
+			MaxRetries: 3,
+			BaseDelay:  500 * time.Millisecond,
+			MaxDelay:   2 * time.Second,
+		},
+		APIVersion:   "v1_0",
+		MomoTimeout:  15 * time.Second,
+		DNSTimeout:   5 * time.Second,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+
+		BodyReadTimeout: 5 * time.Second,
+		MaxBodyBytes:    1 << 20,
+
+		GenerateTimeout:   25 * time.Second,
+		WaitForMTNTimeout: 60 * time.Second,
+		RouteTimeout:      10 * time.Second,
+
+		MaxRequestDuration: 60 * time.Second,
+
+		MaintenanceRetryAfter: 5 * time.Minute,
+		SecretRefreshInterval: 5 * time.Minute,
+
+		CORSAllowedOrigins: []string{"http://localhost:3000"},
+	}
+}
+
+// loadConfig reads the configuration from environment variables, falling back to defaults
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+
+	if c.ConfigFile = os.Getenv("CONFIG_FILE"); c.ConfigFile != "" {
+		if err := applyConfigFile(c.ConfigFile); err != nil {
+			return c, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+	}
+
+	if port := os.Getenv("PORT"); port != "" {
+		c.Port = port
+	}
+
+	c.ListenSocket = os.Getenv("LISTEN_SOCKET")
+	c.SubscriptionKey = os.Getenv("MOMO_SUBSCRIPTION_KEY")
+	if err := loadSecretProviderConfig(&c); err != nil {
+		return c, err
+	}
+	c.AdminAPIToken = os.Getenv("ADMIN_API_TOKEN")
+
+	if v := os.Getenv("API_BASE_PATH"); v != "" {
+		if !strings.HasPrefix(v, "/") {
+			return c, fmt.Errorf("API_BASE_PATH must start with /, got %q", v)
+		}
+		c.APIBasePath = strings.TrimRight(v, "/")
+	}
+
+	var err error
+	c.LogOutput = os.Getenv("LOG_OUTPUT")
+	c.RequestCapture = os.Getenv("REQUEST_CAPTURE")
+	if v := os.Getenv("ACCESS_LOG_FORMAT"); v != "" {
+		if v != accessLogCommon && v != accessLogCombined {
+			return c, fmt.Errorf("ACCESS_LOG_FORMAT must be %s or %s, got %q", accessLogCommon, accessLogCombined, v)
+		}
+		c.AccessLogFormat = v
+	}
+	c.ResponseSigningKey = os.Getenv("RESPONSE_SIGNING_KEY")
+	c.MomoClientCertFile = os.Getenv("MOMO_CLIENT_CERT_FILE")
+	c.MomoClientKeyFile = os.Getenv("MOMO_CLIENT_KEY_FILE")
+	c.MomoCAFile = os.Getenv("MOMO_CA_FILE")
+	// Loaded here only to fail startup on unusable files; the client loads them again
+	if _, err := loadMomoTLSConfig(c.MomoClientCertFile, c.MomoClientKeyFile, c.MomoCAFile); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("MOMO_FALLBACK_BASE_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return c, fmt.Errorf("MOMO_FALLBACK_BASE_URL must be an absolute URL, got %q", v)
+		}
+		c.FallbackBaseURL = strings.TrimSuffix(v, "/")
+	}
+	if c.DevMode, err = envBool("DEV_MODE", c.DevMode); err != nil {
+		return c, err
+	}
+	if c.DebugHTTP, err = envBool("DEBUG_HTTP", c.DebugHTTP); err != nil {
+		return c, err
+	}
+	if c.RequireCallbackHost, err = envBool("REQUIRE_CALLBACK_HOST", c.RequireCallbackHost); err != nil {
+		return c, err
+	}
+	if c.RequireHTTPS, err = envBool("REQUIRE_HTTPS", c.RequireHTTPS); err != nil {
+		return c, err
+	}
+	if c.TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return c, err
+	}
+	if c.RequireHTTPS && len(c.TrustedProxies) == 0 {
+		return c, fmt.Errorf("REQUIRE_HTTPS needs TRUSTED_PROXIES, the TLS-terminating proxies allowed to set X-Forwarded-Proto")
+	}
+	if c.NormalizeCallbackHost, err = envBool("NORMALIZE_CALLBACK_HOST", c.NormalizeCallbackHost); err != nil {
+		return c, err
+	}
+	if c.StrictKeyValidation, err = envBool("STRICT_KEY_VALIDATION", c.StrictKeyValidation); err != nil {
+		return c, err
+	}
+	if c.LogTestCommand, err = envBool("LOG_TEST_COMMAND", c.LogTestCommand); err != nil {
+		return c, err
+	}
+	if c.IncludeSubscriptionKeySuffix, err = envBool("INCLUDE_SUBSCRIPTION_KEY_SUFFIX", c.IncludeSubscriptionKeySuffix); err != nil {
+		return c, err
+	}
+	if c.StrictJSONKeys, err = envBool("STRICT_JSON_KEYS", c.StrictJSONKeys); err != nil {
+		return c, err
+	}
+	if c.SuccessStatusCode, err = envInt("SUCCESS_STATUS_CODE", c.SuccessStatusCode); err != nil {
+		return c, err
+	}
+	// 204 and 205 cannot carry the credentials in a body, so they are not allowed
+	if c.SuccessStatusCode < 200 || c.SuccessStatusCode > 299 || c.SuccessStatusCode == 204 || c.SuccessStatusCode == 205 {
+		return c, fmt.Errorf("SUCCESS_STATUS_CODE must be a 2xx status that allows a body, got %d", c.SuccessStatusCode)
+	}
+	if c.GenerateDedupWindow, err = envDuration("GENERATE_DEDUP_WINDOW", c.GenerateDedupWindow); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("NAMING_STYLE"); v != "" {
+		if v != namingCamel && v != namingSnake {
+			return c, fmt.Errorf("NAMING_STYLE must be %s or %s, got %q", namingCamel, namingSnake, v)
+		}
+		c.NamingStyle = v
+	}
+	if v := os.Getenv("DATETIME_FORMAT"); v != "" {
+		if _, ok := dateTimeFormats[v]; !ok {
+			return c, fmt.Errorf("DATETIME_FORMAT must be one of %v, got %q", dateTimeFormatNames(), v)
+		}
+		c.DateTimeFormat = v
+	}
+	if c.RateLimitPerMinute, err = envInt("RATE_LIMIT_PER_MINUTE", c.RateLimitPerMinute); err != nil {
+		return c, err
+	}
+	if c.RateLimitPerMinute < 0 {
+		return c, fmt.Errorf("RATE_LIMIT_PER_MINUTE must not be negative, got %d", c.RateLimitPerMinute)
+	}
+	if c.StoreMaxRecords, err = envInt("STORE_MAX_RECORDS", c.StoreMaxRecords); err != nil {
+		return c, err
+	}
+	if c.StoreMaxRecords < 0 {
+		return c, fmt.Errorf("STORE_MAX_RECORDS must not be negative, got %d", c.StoreMaxRecords)
+	}
+	if c.FallbackKeyBytes, err = envInt("FALLBACK_KEY_BYTES", c.FallbackKeyBytes); err != nil {
+		return c, err
+	}
+	if c.FallbackKeyBytes < minFallbackKeyBytes || c.FallbackKeyBytes > maxFallbackKeyBytes {
+		return c, fmt.Errorf("FALLBACK_KEY_BYTES must be between %d and %d, got %d", minFallbackKeyBytes, maxFallbackKeyBytes, c.FallbackKeyBytes)
+	}
+	if v := os.Getenv("RESPONSE_TRANSFORMER"); v != "" {
+		if _, ok := responseTransformers[v]; !ok {
+			return c, fmt.Errorf("RESPONSE_TRANSFORMER must be one of %v, got %q", responseTransformerNames(), v)
+		}
+		c.ResponseTransformer = v
+	}
+	if v := os.Getenv("EVENT_PUBLISHER"); v != "" {
+		if _, ok := eventPublishers[v]; !ok {
+			return c, fmt.Errorf("EVENT_PUBLISHER must be one of %v, got %q", eventPublisherNames(), v)
+		}
+		c.EventPublisher = v
+	}
+	if v := os.Getenv("ALERT_WEBHOOK_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return c, fmt.Errorf("ALERT_WEBHOOK_URL must be an absolute http or https URL, got %q", v)
+		}
+		c.AlertWebhookURL = v
+	}
+	if c.AlertMinInterval, err = envDuration("ALERT_MIN_INTERVAL", c.AlertMinInterval); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("REFERENCE_ID_FORMAT"); v != "" {
+		if _, ok := referenceIDFormats[v]; !ok {
+			return c, fmt.Errorf("REFERENCE_ID_FORMAT must be one of %v, got %q", referenceIDFormatNames(), v)
+		}
+		c.ReferenceIDFormat = v
+	}
+	if c.MaxCallbackHostLength, err = envInt("MAX_CALLBACK_HOST_LENGTH", c.MaxCallbackHostLength); err != nil {
+		return c, err
+	}
+	if c.MaxCallbackHostLength < 1 {
+		return c, fmt.Errorf("MAX_CALLBACK_HOST_LENGTH must be positive, got %d", c.MaxCallbackHostLength)
+	}
+
+	if v := os.Getenv("MTN_ERROR_BODY_LOG"); v != "" {
+		switch v {
+		case errorBodyFull, errorBodyTruncate, errorBodyOmit:
+			c.ErrorBodyLogMode = v
+		default:
+			return c, fmt.Errorf("MTN_ERROR_BODY_LOG must be one of full, truncate or omit, got %q", v)
+		}
+	}
+	if c.ErrorBodyLogBytes, err = envInt("MTN_ERROR_BODY_LOG_BYTES", c.ErrorBodyLogBytes); err != nil {
+		return c, err
+	}
+	if c.ErrorBodyLogBytes < 1 {
+		return c, fmt.Errorf("MTN_ERROR_BODY_LOG_BYTES must be positive, got %d", c.ErrorBodyLogBytes)
+	}
+
+	if c.MaxConcurrency, err = envInt("MOMO_MAX_CONCURRENCY", c.MaxConcurrency); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrency < 1 {
+		return c, fmt.Errorf("MOMO_MAX_CONCURRENCY must be positive, got %d", c.MaxConcurrency)
+	}
+
+	if c.BatchMaxItems, err = envInt("BATCH_MAX_ITEMS", c.BatchMaxItems); err != nil {
+		return c, err
+	}
+	if c.BatchMaxItems < 1 {
+		return c, fmt.Errorf("BATCH_MAX_ITEMS must be positive, got %d", c.BatchMaxItems)
+	}
+	c.BatchConcurrency = defaultBatchConcurrency(runtime.NumCPU(), c.MaxConcurrency)
+	if c.BatchConcurrency, err = envInt("BATCH_CONCURRENCY", c.BatchConcurrency); err != nil {
+		return c, err
+	}
+	if c.BatchConcurrency < 1 {
+		return c, fmt.Errorf("BATCH_CONCURRENCY must be positive, got %d", c.BatchConcurrency)
+	}
+	if c.MaxConcurrentBatches, err = envInt("MAX_CONCURRENT_BATCHES", c.MaxConcurrentBatches); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrentBatches < 1 {
+		return c, fmt.Errorf("MAX_CONCURRENT_BATCHES must be positive, got %d", c.MaxConcurrentBatches)
+	}
+	if c.BatchMultiStatus, err = envBool("BATCH_MULTI_STATUS", c.BatchMultiStatus); err != nil {
+		return c, err
+	}
+
+	if c.Retry.MaxRetries, err = envInt("MOMO_MAX_RETRIES", c.Retry.MaxRetries); err != nil {
+		return c, err
+	}
+	if c.Retry.MaxRetries < 0 {
+		return c, fmt.Errorf("MOMO_MAX_RETRIES must not be negative, got %d", c.Retry.MaxRetries)
+	}
+	if c.Retry.BaseDelay, err = envDuration("MOMO_RETRY_BASE_DELAY", c.Retry.BaseDelay); err != nil {
+		return c, err
+	}
+	if c.Retry.MaxDelay, err = envDuration("MOMO_RETRY_MAX_DELAY", c.Retry.MaxDelay); err != nil {
+		return c, err
+	}
+	if c.Retry.Jitter, err = envBool("MOMO_RETRY_JITTER", c.Retry.Jitter); err != nil {
+		return c, err
+	}
+	if c.ResetRetries, err = envInt("MOMO_RESET_RETRIES", c.ResetRetries); err != nil {
+		return c, err
+	}
+	if c.ResetRetries < 0 {
+		return c, fmt.Errorf("MOMO_RESET_RETRIES must not be negative, got %d", c.ResetRetries)
+	}
+	if c.PropagationRetry.MaxRetries, err = envInt("MOMO_PROPAGATION_RETRIES", c.PropagationRetry.MaxRetries); err != nil {
+		return c, err
+	}
+	if c.PropagationRetry.MaxRetries < 0 {
+		return c, fmt.Errorf("MOMO_PROPAGATION_RETRIES must not be negative, got %d", c.PropagationRetry.MaxRetries)
+	}
+	if c.GenerateRetryBudget, err = envInt("GENERATE_RETRY_BUDGET", c.GenerateRetryBudget); err != nil {
+		return c, err
+	}
+	if c.GenerateRetryBudget < 0 {
+		return c, fmt.Errorf("GENERATE_RETRY_BUDGET must not be negative, got %d", c.GenerateRetryBudget)
+	}
+	if c.GenerateTimeBudget, err = envDuration("GENERATE_TIME_BUDGET", c.GenerateTimeBudget); err != nil {
+		return c, err
+	}
+
+	if c.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("MOMO_API_VERSION"); v != "" {
+		if !apiVersionPattern.MatchString(v) {
+			return c, fmt.Errorf("MOMO_API_VERSION must look like v1_0, got %q", v)
+		}
+		c.APIVersion = v
+	}
+
+	if c.MomoTimeout, err = envDuration("MOMO_TIMEOUT", c.MomoTimeout); err != nil {
+		return c, err
+	}
+	if c.DNSTimeout, err = envDuration("DNS_TIMEOUT", c.DNSTimeout); err != nil {
+		return c, err
+	}
+	if c.GenerateTimeout, err = envDuration("GENERATE_TIMEOUT", c.GenerateTimeout); err != nil {
+		return c, err
+	}
+	if c.RouteTimeout, err = envDuration("ROUTE_TIMEOUT", c.RouteTimeout); err != nil {
+		return c, err
+	}
+	if c.MaxRequestDuration, err = envDuration("MAX_REQUEST_DURATION", c.MaxRequestDuration); err != nil {
+		return c, err
+	}
+	if c.Warmup, err = envBool("MOMO_WARMUP", c.Warmup); err != nil {
+		return c, err
+	}
+	if c.WaitForMTN, err = envBool("WAIT_FOR_MTN", c.WaitForMTN); err != nil {
+		return c, err
+	}
+	if c.WaitForMTNTimeout, err = envDuration("WAIT_FOR_MTN_TIMEOUT", c.WaitForMTNTimeout); err != nil {
+		return c, err
+	}
+	if c.MaintenanceMode, err = envBool("MAINTENANCE_MODE", c.MaintenanceMode); err != nil {
+		return c, err
+	}
+	if c.MaintenanceRetryAfter, err = envDuration("MAINTENANCE_RETRY_AFTER", c.MaintenanceRetryAfter); err != nil {
+		return c, err
+	}
+	if c.ReadTimeout, err = envDuration("SERVER_READ_TIMEOUT", c.ReadTimeout); err != nil {
+		return c, err
+	}
+	if c.WriteTimeout, err = envDuration("SERVER_WRITE_TIMEOUT", c.WriteTimeout); err != nil {
+		return c, err
+	}
+	if c.IdleTimeout, err = envDuration("SERVER_IDLE_TIMEOUT", c.IdleTimeout); err != nil {
+		return c, err
+	}
+	if c.BodyReadTimeout, err = envDuration("BODY_READ_TIMEOUT", c.BodyReadTimeout); err != nil {
+		return c, err
+	}
+	if c.MaxBodyBytes, err = envInt("MAX_BODY_BYTES", c.MaxBodyBytes); err != nil {
+		return c, err
+	}
+	if c.MaxBodyBytes < 1 {
+		return c, fmt.Errorf("MAX_BODY_BYTES must be positive, got %d", c.MaxBodyBytes)
+	}
+
+	if v := os.Getenv("SERVER_TIMEZONE"); v != "" {
+		if c.ServerTimezone, err = time.LoadLocation(v); err != nil {
+			return c, fmt.Errorf("SERVER_TIMEZONE must be an IANA timezone such as Africa/Accra, got %q", v)
+		}
+	}
+
+	if v := os.Getenv("ALLOWED_TARGET_ENVS"); v != "" {
+		c.AllowedTargetEnvs = nil
+		for _, env := range strings.Split(v, ",") {
+			if env = strings.TrimSpace(env); env != "" {
+				if !knownMarket(env) {
+					return c, fmt.Errorf("ALLOWED_TARGET_ENVS: unknown target environment %q, must be one of %v", env, marketCodes())
+				}
+				c.AllowedTargetEnvs = append(c.AllowedTargetEnvs, env)
+			}
+		}
+		if len(c.AllowedTargetEnvs) == 0 {
+			return c, fmt.Errorf("ALLOWED_TARGET_ENVS must list at least one environment, got %q", v)
+		}
+	}
+	if v := os.Getenv("FALLBACK_TARGET_ENVS"); v != "" {
+		c.FallbackTargetEnvs = []string{}
+		if strings.TrimSpace(v) != "none" {
+			for _, env := range strings.Split(v, ",") {
+				if env = strings.TrimSpace(env); env != "" {
+					if env != "*" && !knownMarket(env) {
+						return c, fmt.Errorf("FALLBACK_TARGET_ENVS: unknown target environment %q, must be * or one of %v", env, marketCodes())
+					}
+					c.FallbackTargetEnvs = append(c.FallbackTargetEnvs, env)
+				}
+			}
+		}
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		c.CORSAllowedOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.CORSAllowedOrigins = append(c.CORSAllowedOrigins, origin)
+			}
+		}
+		if len(c.CORSAllowedOrigins) == 0 {
+			return c, fmt.Errorf("CORS_ALLOWED_ORIGINS must list at least one origin, got %q", v)
+		}
+	}
+	if v := os.Getenv("METRICS_LATENCY_BUCKETS"); v != "" {
+		if c.MetricsLatencyBuckets, err = parseBuckets(v); err != nil {
+			return c, fmt.Errorf("METRICS_LATENCY_BUCKETS: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// logConfig logs the effective configuration (never secrets)
+func logConfig(c Config) {
+	log.Printf("Config: port=%s", c.Port)
+	if c.ListenSocket != "" {
+		log.Printf("Config: listening on unix socket %s instead of TCP", c.ListenSocket)
+	}
+	log.Printf("Config: shutdown timeout=%s", c.ShutdownTimeout)
+	log.Printf("Config: API base path=%q", c.APIBasePath)
+	log.Printf("Config: generate success status=%d", c.SuccessStatusCode)
+	if c.GenerateDedupWindow > 0 {
+		log.Printf("Config: generate dedup window=%s", c.GenerateDedupWindow)
+	}
+	log.Printf("Config: allowed target environments=%v", c.AllowedTargetEnvs)
+	log.Printf("Config: local fallback target environments=%v", c.FallbackTargetEnvs)
+	log.Printf("Config: response naming style=%s", c.NamingStyle)
+	log.Printf("Config: response dateTime format=%s", c.DateTimeFormat)
+	log.Printf("Config: CORS allowed origins=%v", c.CORSAllowedOrigins)
+	if c.ConfigFile != "" {
+		log.Printf("Config: config file=%s (reloadable via /api/admin/reload)", c.ConfigFile)
+	}
+	log.Printf("Config: response transformer=%s", c.ResponseTransformer)
+	log.Printf("Config: event publisher=%s", c.EventPublisher)
+	if c.AlertWebhookURL != "" {
+		log.Printf("Config: fallback alerts to a webhook on %s (at most one per %s)", webhookHost(c.AlertWebhookURL), c.AlertMinInterval)
+	}
+	log.Printf("Config: reference ID format=%s", c.ReferenceIDFormat)
+	log.Printf("Config: response signing enabled=%t", c.ResponseSigningKey != "")
+	log.Printf("Config: strict JSON keys=%t", c.StrictJSONKeys)
+	log.Printf("Config: include subscription key suffix=%t", c.IncludeSubscriptionKeySuffix)
+	if c.LogTestCommand {
+		log.Println("WARNING: LOG_TEST_COMMAND is enabled; logs will contain base64 credentials and subscription keys")
+	}
+	if c.RequestCapture != "" {
+		log.Printf("Config: capturing generation requests to %s", c.RequestCapture)
+	}
+	log.Printf("Config: credential store max records=%d", c.StoreMaxRecords)
+	log.Printf("Config: fallback key entropy=%d bytes", c.FallbackKeyBytes)
+	if c.RateLimitPerMinute > 0 {
+		log.Printf("Config: generate rate limit=%d/min per client IP", c.RateLimitPerMinute)
+	}
+	log.Printf("Config: max callback host length=%d", c.MaxCallbackHostLength)
+	if c.ServerTimezone != nil {
+		log.Printf("Config: server timezone=%s", c.ServerTimezone)
+	}
+	log.Printf("Config: metrics latency buckets=%v", c.MetricsLatencyBuckets)
+	if c.DevMode {
+		log.Println("WARNING: DEV_MODE is enabled; developer-only features are available")
+	}
+	if c.DebugHTTP {
+		log.Println("WARNING: DEBUG_HTTP is enabled; request and response bodies are logged (redacted)")
+	}
+	if c.SecretProvider == secretProviderEnv {
+		log.Printf("Config: server subscription key configured=%t", c.SubscriptionKey != "")
+	} else {
+		log.Printf("Config: server subscription key from the %s secret provider (field %q, refreshed every %s)", c.SecretProvider, c.SecretField, c.SecretRefreshInterval)
+	}
+	log.Printf("Config: admin endpoints enabled=%t", c.AdminAPIToken != "")
+	if c.RequireHTTPS {
+		log.Printf("Config: HTTPS required (X-Forwarded-Proto trusted from %v)", c.TrustedProxies)
+	}
+	log.Printf("Config: max concurrent MTN calls=%d", c.MaxConcurrency)
+	log.Printf("Config: batch max items=%d concurrency=%d max concurrent batches=%d", c.BatchMaxItems, c.BatchConcurrency, c.MaxConcurrentBatches)
+	if os.Getenv("BATCH_CONCURRENCY") == "" {
+		log.Printf("Config: batch concurrency %d chosen from %d CPUs and MOMO_MAX_CONCURRENCY=%d", c.BatchConcurrency, runtime.NumCPU(), c.MaxConcurrency)
+	}
+	log.Printf("Config: batch multi-status enabled=%t", c.BatchMultiStatus)
+	log.Printf("Config: MTN retries=%d (base delay %s, max delay %s, jitter %t)", c.Retry.MaxRetries, c.Retry.BaseDelay, c.Retry.MaxDelay, c.Retry.Jitter)
+	log.Printf("Config: extra retries of idempotent MTN calls after a connection reset=%d", c.ResetRetries)
+	log.Printf("Config: retries of key creation for a just-created user answering 404=%d", c.PropagationRetry.MaxRetries)
+	if c.GenerateRetryBudget > 0 || c.GenerateTimeBudget > 0 {
+		log.Printf("Config: generate budget retries=%d time=%s (0 = unbounded)", c.GenerateRetryBudget, c.GenerateTimeBudget)
+	}
+	log.Printf("Config: MTN API version=%s", c.APIVersion)
+	if c.FallbackBaseURL != "" {
+		log.Printf("Config: fallback MTN gateway=%s", c.FallbackBaseURL)
+	}
+	if c.MomoClientCertFile != "" || c.MomoCAFile != "" {
+		log.Printf("Config: MTN client certificate=%s, custom CA=%s", orDash(c.MomoClientCertFile), orDash(c.MomoCAFile))
+	}
+	log.Printf("Config: MTN call timeout=%s", c.MomoTimeout)
+	log.Printf("Config: DNS timeout=%s", c.DNSTimeout)
+	log.Printf("Config: route timeouts: generate=%s, other=%s", c.GenerateTimeout, c.RouteTimeout)
+	if c.WriteTimeout > 0 && c.GenerateTimeout > c.WriteTimeout {
+		log.Printf("WARNING: GENERATE_TIMEOUT (%s) exceeds SERVER_WRITE_TIMEOUT (%s); slow generate requests will be cut off without a 503", c.GenerateTimeout, c.WriteTimeout)
+	}
+	log.Printf("Config: max request duration=%s", c.MaxRequestDuration)
+	log.Printf("Config: MTN warm-up enabled=%t", c.Warmup)
+	if c.MaintenanceMode {
+		log.Printf("WARNING: Starting in maintenance mode; /api/generate answers 503 (Retry-After %s)", c.MaintenanceRetryAfter)
+	}
+	if c.WaitForMTN {
+		log.Printf("Config: waiting for MTN at startup for up to %s", c.WaitForMTNTimeout)
+	}
+	log.Printf("Config: server timeouts read=%s write=%s idle=%s", c.ReadTimeout, c.WriteTimeout, c.IdleTimeout)
+	log.Printf("Config: body read timeout=%s, max body=%d bytes", c.BodyReadTimeout, c.MaxBodyBytes)
+	if c.ReadTimeout > 0 && c.BodyReadTimeout >= c.ReadTimeout {
+		log.Printf("WARNING: BODY_READ_TIMEOUT (%s) is not below SERVER_READ_TIMEOUT (%s); slow request bodies will be cut off without a 408", c.BodyReadTimeout, c.ReadTimeout)
+	}
+	if c.WriteTimeout > 0 && c.WriteTimeout <= c.MomoTimeout {
+		log.Printf("WARNING: SERVER_WRITE_TIMEOUT (%s) does not exceed MOMO_TIMEOUT (%s); slow MTN responses will be cut off", c.WriteTimeout, c.MomoTimeout)
+	}
+	log.Printf("Config: MTN error body logging=%s (limit %d bytes)", c.ErrorBodyLogMode, c.ErrorBodyLogBytes)
+}
+
+// envInt reads an integer environment variable, returning def when it is unset
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("%s must be an integer, got %q", name, v)
+	}
+	return n, nil
+}
+
+// envBool reads a boolean environment variable, returning def when it is unset
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("%s must be a boolean, got %q", name, v)
+	}
+	return b, nil
+}
+
+// envDuration reads a duration environment variable (e.g. "500ms", "10s"), returning def when it is unset
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("%s must be a duration such as 500ms or 10s, got %q", name, v)
+	}
+	if d < 0 {
+		return def, fmt.Errorf("%s must not be negative, got %q", name, v)
+	}
+	return d, nil
+}
+
+// parseBuckets parses a comma-separated list of histogram bucket bounds in seconds.
+// Buckets must be positive and strictly increasing.
+func parseBuckets(v string) ([]float64, error) {
+	var buckets []float64
+	for _, part := range strings.Split(v, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q", part)
+		}
+		if b <= 0 {
+			return nil, fmt.Errorf("bucket %v must be positive", b)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be sorted in increasing order, %v follows %v", b, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// loadSecretProviderConfig reads the SECRET_PROVIDER settings, checking that the
+// selected provider has what it needs
+func loadSecretProviderConfig(c *Config) error {
+	if v := os.Getenv("SECRET_PROVIDER"); v != "" {
+		c.SecretProvider = v
+	}
+	if v := os.Getenv("SECRET_FIELD"); v != "" {
+		c.SecretField = v
+	}
+	var err error
+	if c.SecretRefreshInterval, err = envDuration("SECRET_REFRESH_INTERVAL", c.SecretRefreshInterval); err != nil {
+		return err
+	}
+	c.VaultAddr = strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	c.VaultToken = os.Getenv("VAULT_TOKEN")
+	c.VaultSecretPath = os.Getenv("VAULT_SECRET_PATH")
+	c.AWSRegion = os.Getenv("AWS_REGION")
+	c.AWSSecretID = os.Getenv("AWS_SECRET_ID")
+
+	switch c.SecretProvider {
+	case secretProviderEnv:
+	case secretProviderVault:
+		if c.VaultAddr == "" || c.VaultToken == "" || c.VaultSecretPath == "" {
+			return fmt.Errorf("SECRET_PROVIDER=vault requires VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH")
+		}
+	case secretProviderAWS:
+		if c.AWSRegion == "" || c.AWSSecretID == "" || os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			return fmt.Errorf("SECRET_PROVIDER=aws requires AWS_REGION, AWS_SECRET_ID, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+	default:
+		return fmt.Errorf("SECRET_PROVIDER must be %s, %s or %s, got %q", secretProviderEnv, secretProviderVault, secretProviderAWS, c.SecretProvider)
+	}
+	if c.SecretRefreshInterval <= 0 {
+		return fmt.Errorf("SECRET_REFRESH_INTERVAL must be positive, got %s", c.SecretRefreshInterval)
+	}
+	return nil
+}
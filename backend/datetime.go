@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DATETIME_FORMAT presets for the dateTime of generate responses
+const (
+	dateTimeRFC3339  = "rfc3339"   // 2006-01-02T15:04:05Z (default)
+	dateTimeISOBasic = "iso-basic" // 20060102T150405Z, ISO 8601 basic format
+	dateTimeUnix     = "unix"      // Seconds since the epoch, as a string: "1136214245"
+)
+
+// dateTimeFormats render a UTC time in each DATETIME_FORMAT preset
+var dateTimeFormats = map[string]func(time.Time) string{
+	dateTimeRFC3339:  func(t time.Time) string { return t.Format(time.RFC3339) },
+	dateTimeISOBasic: func(t time.Time) string { return t.Format("20060102T150405Z") },
+	dateTimeUnix:     func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) },
+}
+
+// dateTimeFormatNames lists the DATETIME_FORMAT presets, for config errors
+func dateTimeFormatNames() []string {
+	names := make([]string, 0, len(dateTimeFormats))
+	for name := range dateTimeFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatDateTime renders t, in UTC, as the response dateTime in the configured DATETIME_FORMAT
+func formatDateTime(t time.Time) string {
+	return dateTimeFormats[cfg.DateTimeFormat](t.UTC())
+}
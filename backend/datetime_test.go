@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDateTimeFormats(t *testing.T) {
+	// 15:04:05 at UTC+1 is 14:04:05 UTC; every preset renders UTC
+	at := time.Date(2006, 1, 2, 15, 4, 5, 0, time.FixedZone("WAT", 3600))
+	tests := []struct {
+		preset string
+		want   string
+	}{
+		{dateTimeRFC3339, "2006-01-02T14:04:05Z"},
+		{dateTimeISOBasic, "20060102T140405Z"},
+		{dateTimeUnix, "1136210645"},
+	}
+	if len(tests) != len(dateTimeFormats) {
+		t.Fatalf("testing %d presets of %d", len(tests), len(dateTimeFormats))
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			setConfig(t, func(c *Config) { c.DateTimeFormat = tt.preset })
+			if got := formatDateTime(at); got != tt.want {
+				t.Errorf("formatDateTime = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateDateTimeFormat(t *testing.T) {
+	tests := []struct {
+		preset string
+		parse  func(string) (time.Time, error)
+	}{
+		{"", func(s string) (time.Time, error) { return time.Parse(time.RFC3339, s) }},
+		{dateTimeRFC3339, func(s string) (time.Time, error) { return time.Parse(time.RFC3339, s) }},
+		{dateTimeISOBasic, func(s string) (time.Time, error) { return time.Parse("20060102T150405Z", s) }},
+		{dateTimeUnix, func(s string) (time.Time, error) {
+			sec, err := strconv.ParseInt(s, 10, 64)
+			return time.Unix(sec, 0), err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("DATETIME_FORMAT="+tt.preset, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"DATETIME_FORMAT": tt.preset})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			got, err := tt.parse(resp.DateTime)
+			if err != nil {
+				t.Fatalf("dateTime %q is not in the %q preset: %v", resp.DateTime, tt.preset, err)
+			}
+			if d := time.Since(got); d < -time.Minute || d > time.Minute {
+				t.Errorf("dateTime %q is %s from now", resp.DateTime, d)
+			}
+		})
+	}
+}
+
+func TestInvalidDateTimeFormat(t *testing.T) {
+	t.Setenv("DATETIME_FORMAT", "iso-extended")
+	if _, err := loadConfig(); err == nil {
+		t.Error("loadConfig accepted an unknown DATETIME_FORMAT preset")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// OutboundRequest describes one request sent to MTN MoMo, with secrets redacted
+type OutboundRequest struct {
+	Operation string            `json:"operation"`
+	Attempt   int               `json:"attempt"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body,omitempty"`
+}
+
+// DebugInfo is attached to generate responses when ?debug=true is honored
+type DebugInfo struct {
+	OutboundRequests []OutboundRequest `json:"outboundRequests"`
+}
+
+// secretHeaders are outbound headers whose values are always redacted in debug output
+var secretHeaders = map[string]bool{
+	"Ocp-Apim-Subscription-Key": true,
+	"Authorization":             true,
+}
+
+// outboundTrace collects the MTN requests made while serving one client request
+type outboundTrace struct {
+	mu       sync.Mutex
+	requests []OutboundRequest
+}
+
+type outboundTraceKey struct{}
+
+// withOutboundTrace returns a context that records every MTN request made with it
+func withOutboundTrace(ctx context.Context) (context.Context, *outboundTrace) {
+	trace := &outboundTrace{}
+	return context.WithValue(ctx, outboundTraceKey{}, trace), trace
+}
+
+// recordOutbound adds an outbound MTN request to the context's trace, if it has one.
+// Header values and the body are redacted before they are stored.
+func recordOutbound(ctx context.Context, operation string, attempt int, req *http.Request, body []byte, secrets ...string) {
+	trace, ok := ctx.Value(outboundTraceKey{}).(*outboundTrace)
+	if !ok {
+		return
+	}
+
+	headers := make(map[string]string, len(req.Header))
+	for name := range req.Header {
+		if secretHeaders[http.CanonicalHeaderKey(name)] {
+			headers[name] = redactedPlaceholder
+		} else {
+			headers[name] = redactSecrets(req.Header.Get(name), secrets...)
+		}
+	}
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.requests = append(trace.requests, OutboundRequest{
+		Operation: operation,
+		Attempt:   attempt,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Headers:   headers,
+		Body:      redactSecrets(string(body), secrets...),
+	})
+}
+
+// Requests returns the outbound requests recorded so far
+func (t *outboundTrace) Requests() []OutboundRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]OutboundRequest(nil), t.requests...)
+}
+
+// RawResponse is MTN's status and (redacted) body for one call, returned when
+// includeRawResponse is honored
+type RawResponse struct {
+	Operation  string `json:"operation"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"statusCode"`
+	Body       string `json:"body"`
+}
+
+// rawResponseTrace collects MTN responses made while serving one client request
+type rawResponseTrace struct {
+	mu        sync.Mutex
+	responses []RawResponse
+}
+
+type rawResponseTraceKey struct{}
+
+// withRawResponses returns a context that captures every MTN response received with it
+func withRawResponses(ctx context.Context) (context.Context, *rawResponseTrace) {
+	trace := &rawResponseTrace{}
+	return context.WithValue(ctx, rawResponseTraceKey{}, trace), trace
+}
+
+// captureRawResponse records MTN's status and body in the context's trace, if it has one.
+// The (decoded) body is buffered and put back on resp so the normal parse path still
+// reads it; secrets are redacted only in the captured copy.
+func captureRawResponse(ctx context.Context, operation string, attempt int, resp *http.Response, secrets ...string) {
+	trace, ok := ctx.Value(rawResponseTraceKey{}).(*rawResponseTrace)
+	if !ok {
+		return
+	}
+
+	r, err := responseBody(resp)
+	if err != nil {
+		return // Left as is; the normal path reports the decoding error
+	}
+	body, _ := io.ReadAll(r)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.Header.Del("Content-Encoding") // Already decoded
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.responses = append(trace.responses, RawResponse{
+		Operation:  operation,
+		Attempt:    attempt,
+		StatusCode: resp.StatusCode,
+		Body:       redactSecrets(string(body), secrets...),
+	})
+}
+
+// Responses returns the MTN responses captured so far
+func (t *rawResponseTrace) Responses() []RawResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]RawResponse(nil), t.responses...)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// debugHTTPMaxBodyBytes caps how much of each request and response body is logged
+// when DEBUG_HTTP is enabled. Bodies are always passed through in full.
+const debugHTTPMaxBodyBytes = 4096
+
+// debugHTTPMiddleware logs the (redacted) request and response bodies of every request.
+// The request body is restored for the downstream handler, and the response is teed
+// rather than buffered so streaming endpoints still flush as they write.
+func debugHTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				log.Printf("DEBUG: %s %s failed to read request body: %v", r.Method, r.URL.Path, err)
+			}
+			// Whatever was read is handed on, so the handler sees the same body (or the same short read)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			log.Printf("DEBUG: %s %s request body: %s", r.Method, r.URL.Path, debugBody(body, len(body)))
+		}
+
+		tw := &teeResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(tw, r)
+		log.Printf("DEBUG: %s %s response status=%d body: %s", r.Method, r.URL.Path, tw.status, debugBody(tw.body.Bytes(), tw.size))
+	})
+}
+
+// debugBody formats a captured body for logs; size is the full length of the body
+func debugBody(captured []byte, size int) string {
+	if size == 0 {
+		return "(empty)"
+	}
+	if len(captured) > debugHTTPMaxBodyBytes {
+		captured = captured[:debugHTTPMaxBodyBytes]
+	}
+	s := redactSecrets(string(captured))
+	if size > len(captured) {
+		s += "...(truncated)"
+	}
+	return s
+}
+
+// teeResponseWriter passes a response through while keeping a copy of its
+// first debugHTTPMaxBodyBytes bytes for logging
+type teeResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	size        int
+}
+
+func (w *teeResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *teeResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	if room := debugHTTPMaxBodyBytes - w.body.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		w.body.Write(p[:room])
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+// Flush keeps streaming endpoints (such as the credential export) working through the tee
+func (w *teeResponseWriter) Flush() {
+	flush(w.ResponseWriter)
+}
+
+// Hijack lets the log stream's WebSocket upgrade through the tee
+func (w *teeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHTTPMiddlewareKeepsBodyReadable(t *testing.T) {
+	setupTest(t, nil)
+	logs := captureLogs(t)
+	body := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"example.com"}`, testSubscriptionKey)
+
+	var downstream string
+	h := debugHTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("downstream read: %v", err)
+		}
+		downstream = string(b)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"apiKey":"a1b2c3d4e5f60718293a4b5c6d7e8f90"}`)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+
+	if downstream != body {
+		t.Errorf("downstream handler read %q, want the original body %q", downstream, body)
+	}
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), "a1b2c3d4") {
+		t.Errorf("response passed through as %d %s", rec.Code, rec.Body)
+	}
+
+	out := logs.String()
+	for _, secret := range []string{testSubscriptionKey, "a1b2c3d4e5f60718293a4b5c6d7e8f90"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("debug log contains the secret %s:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "example.com") || !strings.Contains(out, "response status=201") {
+		t.Errorf("debug log lacks the request or response:\n%s", out)
+	}
+}
+
+func TestDebugBodyTruncates(t *testing.T) {
+	long := strings.Repeat("x", debugHTTPMaxBodyBytes+10)
+	got := debugBody([]byte(long), len(long))
+	if want := strings.Repeat("x", debugHTTPMaxBodyBytes) + "...(truncated)"; got != want {
+		t.Errorf("debugBody kept %d characters, want %d and a marker", len(got), debugHTTPMaxBodyBytes)
+	}
+	if got := debugBody(nil, 0); got != "(empty)" {
+		t.Errorf("debugBody of an empty body = %q", got)
+	}
+}
+
+func TestDebugHTTPRedactsSnakeCaseResponse(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	h := setupTest(t, map[string]string{"DEBUG_HTTP": "true", "NAMING_STYLE": "snake", "LOG_TEST_COMMAND": "false"})
+	fakeMTN(t, mtnSuccess(apiKey))
+	logs := captureLogs(t)
+
+	rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"base64_auth"`) {
+		t.Fatalf("generate answered %d %s, want a snake_case response", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data struct {
+			Base64Auth string `json:"base64_auth"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	out := strings.Join(linesWith(logs.String(), "response status=201"), "\n")
+	if out == "" {
+		t.Fatalf("no debug line for the response:\n%s", logs)
+	}
+	for _, secret := range []string{apiKey, resp.Data.Base64Auth, testSubscriptionKey} {
+		if strings.Contains(out, secret) {
+			t.Errorf("debug log of the snake_case response carries the secret %s:\n%s", secret, out)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// duplicateKeyError reports a JSON key given more than once in a request body
+type duplicateKeyError struct {
+	Key string
+}
+
+func (e *duplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate JSON key %q", e.Key)
+}
+
+// decodeJSONBody decodes a request body into v. The standard decoder silently keeps
+// the last value of a repeated key, so with STRICT_JSON_KEYS a body repeating a
+// top-level key (say, two primaryKey fields) is rejected with a duplicateKeyError.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	if !cfg.StrictJSONKeys {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := checkDuplicateKeys(body); err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
+
+// checkDuplicateKeys scans the top-level object of body for repeated keys. Anything
+// that is not a well-formed object is left for the real decode to reject.
+func checkDuplicateKeys(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil
+		}
+		if seen[key] {
+			return &duplicateKeyError{Key: key}
+		}
+		seen[key] = true
+		// Skip the value, however deeply nested
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// decodeErrorMessage is the client-facing message for a body that failed to decode
+func decodeErrorMessage(err error) string {
+	if dup, ok := err.(*duplicateKeyError); ok {
+		return "Invalid request format: " + dup.Error()
+	}
+	if field, ok := err.(*requestFieldError); ok {
+		return "Invalid request format: " + field.Error()
+	}
+	return "Invalid request format"
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantKey string
+	}{
+		{"distinct keys", `{"primaryKey":"a","secondaryKey":"b"}`, ""},
+		{"duplicate top-level key", `{"primaryKey":"a","callbackHost":"x","primaryKey":"b"}`, "primaryKey"},
+		{"duplicate key in a nested object", `{"options":{"a":1,"a":2},"primaryKey":"a"}`, ""},
+		{"same key at different levels", `{"a":{"a":1},"b":[{"a":2}]}`, ""},
+		{"empty object", `{}`, ""},
+		{"not an object", `["a","a"]`, ""},
+		{"malformed", `{"a":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDuplicateKeys([]byte(tt.body))
+			if tt.wantKey == "" {
+				if err != nil {
+					t.Errorf("checkDuplicateKeys(%s) = %v, want nil", tt.body, err)
+				}
+				return
+			}
+			dup, ok := err.(*duplicateKeyError)
+			if !ok || dup.Key != tt.wantKey {
+				t.Errorf("checkDuplicateKeys(%s) = %v, want a duplicate %q", tt.body, err, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestGenerateDuplicateKeys(t *testing.T) {
+	body := fmt.Sprintf(`{"primaryKey":%q,"primaryKey":%q}`, "ffffffffffffffffffffffffffffffff", testSubscriptionKey)
+	tests := []struct {
+		name       string
+		strict     string
+		wantStatus int
+	}{
+		{"lenient by default", "", http.StatusCreated},
+		{"strict", "true", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			if tt.strict != "" {
+				env["STRICT_JSON_KEYS"] = tt.strict
+			}
+			h := setupTest(t, env)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusCreated {
+				return
+			}
+			got := decodeEnvelope(t, rec, nil)
+			if got.ErrorCode != errInvalidRequest {
+				t.Errorf("errorCode = %q, want %q", got.ErrorCode, errInvalidRequest)
+			}
+			if !strings.Contains(got.Message, `"primaryKey"`) {
+				t.Errorf("message %q does not name the duplicate key", got.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// generateDedup collapses duplicate /api/generate requests within GENERATE_DEDUP_WINDOW
+var generateDedup = newDedupCache()
+
+// generateDedupKey identifies requests that would create "the same" user: same
+// callback host, in the same target environment, with the same subscription key
+// and response shape (see dedupResponseShape). The subscription key is hashed so
+// it is never held in memory as a map key.
+func generateDedupKey(callbackHost string, targetEnv string, subscriptionKey string, shape string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(callbackHost) + "\x00" + targetEnv + "\x00" + subscriptionKey + "\x00" + shape))
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupResponseShape joins the request options that shape a generate response, so
+// only requests that would receive the same response are coalesced. Above all, a
+// request whose key must be encrypted to its publicKey never gets another's plaintext.
+func dedupResponseShape(publicKey string, keyCount int, format string, base64Format string) string {
+	return strings.Join([]string{publicKey, strconv.Itoa(keyCount), format, base64Format}, "\x00")
+}
+
+// idempotencyKeyHeader lets clients mark retries of the same generate request
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds Idempotency-Key values
+const maxIdempotencyKeyLength = 255
+
+// idempotencyDedupKey identifies requests sharing an Idempotency-Key. Keys are scoped
+// to the subscription key so two tenants choosing the same key are never coalesced,
+// and to the response shape like generateDedupKey.
+func idempotencyDedupKey(idempotencyKey string, subscriptionKey string, shape string) string {
+	sum := sha256.Sum256([]byte("idempotency\x00" + idempotencyKey + "\x00" + subscriptionKey + "\x00" + shape))
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupEntry is one in-flight or recently completed request. done is closed once
+// the first request's response has been recorded.
+type dedupEntry struct {
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// dedupCache tracks in-flight and recent requests by key
+type dedupCache struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+func newDedupCache() *dedupCache {
+	return &dedupCache{entries: make(map[string]*dedupEntry)}
+}
+
+// begin returns the entry for key. leader is true if the caller is the first
+// request in the window and must produce the response (and call finish);
+// otherwise the caller should wait on entry.done and replay the response.
+func (c *dedupCache) begin(key string) (entry *dedupEntry, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	e := &dedupEntry{done: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+// finish records the leader's response, keeps it for window, and releases any waiters
+func (c *dedupCache) finish(e *dedupEntry, rec *recordingResponseWriter, window time.Duration) {
+	c.mu.Lock()
+	e.status = rec.status
+	e.header = rec.Header().Clone()
+	e.body = rec.body.Bytes()
+	e.expires = time.Now().Add(window)
+	c.mu.Unlock()
+	close(e.done)
+}
+
+// fail records an internal error for a leader that did not complete (it panicked),
+// so waiters get a 500 rather than an empty response. The entry is dropped, so the
+// next request in the window tries again instead of replaying the failure.
+func (c *dedupCache) fail(key string, e *dedupEntry, r *http.Request) {
+	buf := newBufferedResponseWriter()
+	sendError(buf, r, errInternal, "The original request failed unexpectedly", http.StatusInternalServerError)
+	c.mu.Lock()
+	e.status = buf.status
+	e.header = buf.header
+	e.body = buf.body.Bytes()
+	if c.entries[key] == e {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
+
+// replay writes a recorded response to a duplicate request
+func (e *dedupEntry) replay(w http.ResponseWriter) {
+	for name, values := range e.header {
+		// CORS, request ID and rate limit headers describe the duplicate request itself and
+		// were already set on it by the middleware; replaying the leader's would overwrite its
+		// own request ID and remaining quota. Header names are canonical, hence X-Ratelimit-.
+		if strings.HasPrefix(name, "Access-Control-") || strings.HasPrefix(name, "X-Ratelimit-") || name == requestIDHeader {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Deduplicated", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// recordingResponseWriter passes a response through while keeping a full copy of it
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countUserCreates wraps an MTN stub, counting the API users it is asked to create
+func countUserCreates(h http.Handler) (http.Handler, *atomic.Int32) {
+	var n atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/apiuser") {
+			n.Add(1)
+		}
+		h.ServeHTTP(w, r)
+	}), &n
+}
+
+func TestGenerateDedupWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      string
+		hosts       [2]string
+		pause       time.Duration
+		wantCreates int32
+		wantDedup   bool
+	}{
+		{"within window", "1m", [2]string{"example.com", "EXAMPLE.com"}, 0, 1, true},
+		{"outside window", "50ms", [2]string{"example.com", "example.com"}, 100 * time.Millisecond, 2, false},
+		{"other callback host", "1m", [2]string{"example.com", "example.org"}, 0, 2, false},
+		{"disabled", "0", [2]string{"example.com", "example.com"}, 0, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"GENERATE_DEDUP_WINDOW": tt.window})
+			mtn, creates := countUserCreates(mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+			fakeMTN(t, mtn)
+
+			body := func(host string) string {
+				return fmt.Sprintf(`{"primaryKey":%q,"callbackHost":%q}`, testSubscriptionKey, host)
+			}
+			first := doRequest(h, http.MethodPost, "/api/generate", body(tt.hosts[0]))
+			time.Sleep(tt.pause)
+			second := doRequest(h, http.MethodPost, "/api/generate", body(tt.hosts[1]))
+
+			if got := creates.Load(); got != tt.wantCreates {
+				t.Errorf("MTN users created = %d, want %d", got, tt.wantCreates)
+			}
+			if got := second.Header().Get("X-Deduplicated") == "true"; got != tt.wantDedup {
+				t.Errorf("second response deduplicated = %t, want %t", got, tt.wantDedup)
+			}
+			var a, b MomoKeyResponse
+			decodeEnvelope(t, first, &a)
+			decodeEnvelope(t, second, &b)
+			if same := a.UserID == b.UserID; same != tt.wantDedup {
+				t.Errorf("user IDs %s and %s: same = %t, want %t", a.UserID, b.UserID, same, tt.wantDedup)
+			}
+			if second.Code != first.Code {
+				t.Errorf("second status = %d, first %d", second.Code, first.Code)
+			}
+		})
+	}
+}
+
+func TestGenerateDedupPerTargetEnvironment(t *testing.T) {
+	h := setupTest(t, map[string]string{"GENERATE_DEDUP_WINDOW": "1m", "ALLOWED_TARGET_ENVS": "sandbox,mtnghana"})
+	mtn, creates := countUserCreates(mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	fakeMTN(t, mtn)
+
+	body := func(env string) string {
+		return fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"example.com","targetEnvironment":%q}`, testSubscriptionKey, env)
+	}
+	first := doRequest(h, http.MethodPost, "/api/generate", body("sandbox"))
+	second := doRequest(h, http.MethodPost, "/api/generate", body("mtnghana"))
+	if second.Header().Get("X-Deduplicated") != "" {
+		t.Fatal("request for mtnghana got the sandbox request's response")
+	}
+	var a, b MomoKeyResponse
+	decodeEnvelope(t, first, &a)
+	decodeEnvelope(t, second, &b)
+	if a.UserID == b.UserID || b.TargetEnv != "mtnghana" {
+		t.Errorf("second response is user %s for %s, want its own mtnghana user", b.UserID, b.TargetEnv)
+	}
+	if got := creates.Load(); got != 2 {
+		t.Errorf("MTN users created = %d, want 2", got)
+	}
+
+	// An omitted targetEnvironment resolves to sandbox, so it is a duplicate
+	third := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"example.com"}`, testSubscriptionKey))
+	if third.Header().Get("X-Deduplicated") != "true" {
+		t.Error("request with the default target environment was not deduplicated with the sandbox one")
+	}
+}
+
+func TestConcurrentIdenticalRequestsCoalesce(t *testing.T) {
+	const n = 10
+	tests := []struct {
+		name        string
+		key         func(i int) string
+		wantCreates int32
+	}{
+		{"same idempotency key", func(int) string { return "order-42" }, 1},
+		{"distinct idempotency keys", func(i int) string { return fmt.Sprintf("order-%d", i) }, n},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			// A slow MTN keeps the first request in flight while the others arrive
+			mtn, creates := countUserCreates(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+				success(w, r)
+			}))
+			fakeMTN(t, mtn)
+
+			body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+			userIDs := make([]string, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					rec := doRequest(h, http.MethodPost, "/api/generate", body, idempotencyKeyHeader, tt.key(i))
+					var resp MomoKeyResponse
+					decodeEnvelope(t, rec, &resp)
+					userIDs[i] = resp.UserID
+				}(i)
+			}
+			wg.Wait()
+
+			if got := creates.Load(); got != tt.wantCreates {
+				t.Errorf("MTN users created = %d, want %d", got, tt.wantCreates)
+			}
+			distinct := make(map[string]bool)
+			for _, id := range userIDs {
+				distinct[id] = true
+			}
+			if int32(len(distinct)) != tt.wantCreates {
+				t.Errorf("%d requests got %d distinct user IDs, want %d", n, len(distinct), tt.wantCreates)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey),
+		idempotencyKeyHeader, strings.Repeat("k", maxIdempotencyKeyLength+1))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+// panicOnceTransformer panics on the first response it transforms, like a handler
+// bug hit by one request
+type panicOnceTransformer struct{ panicked *atomic.Bool }
+
+func (p panicOnceTransformer) Transform(_ *http.Request, data interface{}) interface{} {
+	if p.panicked.CompareAndSwap(false, true) {
+		panic("transformer bug")
+	}
+	return data
+}
+
+func TestDedupLeaderPanic(t *testing.T) {
+	h := setupTest(t, map[string]string{"GENERATE_DEDUP_WINDOW": "1m"})
+	logs := captureLogs(t)
+	transformer := responseTransformer
+	responseTransformer = panicOnceTransformer{new(atomic.Bool)}
+	t.Cleanup(func() { responseTransformer = transformer })
+
+	release := make(chan struct{})
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	mtn, creates := countUserCreates(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		success(w, r)
+	}))
+	fakeMTN(t, mtn)
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+
+	// The leader is held at MTN until the follower waits on it, then panics
+	leader := make(chan *httptest.ResponseRecorder)
+	go func() { leader <- doRequest(h, http.MethodPost, "/api/generate", body) }()
+	waitForLog(t, logs, "STEP 1/2: Creating API User")
+	follower := make(chan *httptest.ResponseRecorder)
+	go func() { follower <- doRequest(h, http.MethodPost, "/api/generate", body) }()
+	waitForLog(t, logs, "Duplicate request for callback host")
+	close(release)
+
+	if rec := <-leader; rec.Code != http.StatusInternalServerError {
+		t.Errorf("leader status = %d, want 500", rec.Code)
+	}
+	rec := <-follower
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("follower status = %d, want 500 (body %s)", rec.Code, rec.Body)
+	}
+	if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInternal {
+		t.Errorf("follower errorCode = %q, want %s", env.ErrorCode, errInternal)
+	}
+
+	// The failure is not replayed: the next request in the window starts afresh
+	retry := doRequest(h, http.MethodPost, "/api/generate", body)
+	if retry.Code != http.StatusCreated || retry.Header().Get("X-Deduplicated") != "" {
+		t.Errorf("retry status = %d, deduplicated %q; want a fresh 201", retry.Code, retry.Header().Get("X-Deduplicated"))
+	}
+	if got := creates.Load(); got != 2 {
+		t.Errorf("MTN users created = %d, want 2", got)
+	}
+}
+
+func TestDedupKeepsResponseShapesApart(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	publicKey := func() string {
+		der, err := x509.MarshalPKIXPublicKey(&testRSAKey(t, 2048).PublicKey)
+		if err != nil {
+			t.Fatalf("marshal public key: %v", err)
+		}
+		return pemBlock("PUBLIC KEY", der)
+	}
+	keyA, keyB := publicKey(), publicKey()
+	request := func(fields map[string]interface{}) string {
+		fields["primaryKey"] = testSubscriptionKey
+		fields["callbackHost"] = "example.com"
+		body, _ := json.Marshal(fields)
+		return string(body)
+	}
+	plain := request(map[string]interface{}{})
+	encryptedA := request(map[string]interface{}{"publicKey": keyA})
+
+	tests := []struct {
+		name      string
+		first     string
+		second    string
+		headers   []string
+		wantDedup bool
+	}{
+		{"plain then publicKey", plain, encryptedA, nil, false},
+		{"publicKey then plain", encryptedA, plain, nil, false},
+		{"plain then publicKey, same Idempotency-Key", plain, encryptedA, []string{idempotencyKeyHeader, "order-42"}, false},
+		{"different publicKeys", encryptedA, request(map[string]interface{}{"publicKey": keyB}), nil, false},
+		{"different keyCount", plain, request(map[string]interface{}{"keyCount": 2}), nil, false},
+		{"different base64Format", plain, request(map[string]interface{}{"base64Format": base64SubscriptionKey}), nil, false},
+		{"same publicKey", encryptedA, request(map[string]interface{}{"publicKey": keyA}), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"GENERATE_DEDUP_WINDOW": "1m"})
+			fakeMTN(t, mtnSuccess(apiKey))
+
+			doRequest(h, http.MethodPost, "/api/generate", tt.first, tt.headers...)
+			second := doRequest(h, http.MethodPost, "/api/generate", tt.second, tt.headers...)
+			if got := second.Header().Get("X-Deduplicated") == "true"; got != tt.wantDedup {
+				t.Fatalf("second response deduplicated = %t, want %t", got, tt.wantDedup)
+			}
+			// A request with a publicKey only ever sees its key encrypted
+			if strings.Contains(tt.second, "publicKey") && strings.Contains(second.Body.String(), apiKey) {
+				t.Errorf("publicKey request got the plaintext API key: %s", second.Body)
+			}
+		})
+	}
+}
+
+func TestDedupReplayKeepsPerRequestHeaders(t *testing.T) {
+	h := setupTest(t, map[string]string{"GENERATE_DEDUP_WINDOW": "1m", "RATE_LIMIT_PER_MINUTE": "10"})
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+	first := doRequest(h, http.MethodPost, "/api/generate", body, requestIDHeader, "leader-request")
+	second := doRequest(h, http.MethodPost, "/api/generate", body, requestIDHeader, "duplicate-request")
+
+	if second.Header().Get("X-Deduplicated") != "true" {
+		t.Fatalf("second response was not deduplicated (status %d)", second.Code)
+	}
+	tests := []struct {
+		header      string
+		first, want string
+	}{
+		{requestIDHeader, "leader-request", "duplicate-request"},
+		{"X-RateLimit-Remaining", "9", "8"},
+	}
+	for _, tt := range tests {
+		if got := first.Header().Get(tt.header); got != tt.first {
+			t.Errorf("first %s = %q, want %q", tt.header, got, tt.first)
+		}
+		if got := second.Header().Get(tt.header); got != tt.want {
+			t.Errorf("replayed %s = %q, want the duplicate's own %q", tt.header, got, tt.want)
+		}
+	}
+}
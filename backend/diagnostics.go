@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// diagnosticsWindowSize is how many recent generate requests the diagnostics summary covers
+const diagnosticsWindowSize = 100
+
+// diagnostics holds recent generate outcomes for GET /api/diagnostics
+var diagnostics = newOutcomeRing(diagnosticsWindowSize)
+
+// generateOutcome is the result of one /api/generate request
+type generateOutcome struct {
+	At      time.Time
+	Source  string        // sourceMTN or sourceLocal
+	Latency time.Duration // Time spent generating, including MTN calls and retries
+	Err     error         // Last MTN error, if any
+}
+
+// outcomeRing is a bounded ring buffer of recent outcomes
+type outcomeRing struct {
+	mu      sync.Mutex
+	buf     []generateOutcome
+	next    int
+	total   int64
+	lastErr *generateOutcome
+}
+
+func newOutcomeRing(size int) *outcomeRing {
+	return &outcomeRing{buf: make([]generateOutcome, 0, size)}
+}
+
+// Record adds an outcome, overwriting the oldest once the buffer is full
+func (r *outcomeRing) Record(o generateOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.buf) < cap(r.buf) {
+		r.buf = append(r.buf, o)
+	} else {
+		r.buf[r.next] = o
+	}
+	r.next = (r.next + 1) % cap(r.buf)
+	r.total++
+	if o.Err != nil {
+		r.lastErr = &o
+	}
+}
+
+// DiagnosticsSummary is the response of GET /api/diagnostics. Rates and latency
+// cover the last WindowSize requests; TotalRequests covers the process lifetime.
+type DiagnosticsSummary struct {
+	TotalRequests    int64   `json:"totalRequests"`
+	WindowSize       int     `json:"windowSize"`
+	MTNSuccessRate   float64 `json:"mtnSuccessRate"`
+	FallbackRate     float64 `json:"fallbackRate"`
+	AverageLatencyMS float64 `json:"averageLatencyMs"`
+	LastError        string  `json:"lastError,omitempty"`
+	LastErrorAt      string  `json:"lastErrorAt,omitempty"`
+}
+
+// Summary computes the rolling summary over the buffered outcomes
+func (r *outcomeRing) Summary() DiagnosticsSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := DiagnosticsSummary{TotalRequests: r.total, WindowSize: len(r.buf)}
+	if r.lastErr != nil {
+		summary.LastError = r.lastErr.Err.Error()
+		summary.LastErrorAt = r.lastErr.At.UTC().Format(time.RFC3339)
+	}
+	if len(r.buf) == 0 {
+		return summary
+	}
+
+	var mtn int
+	var latency time.Duration
+	for _, o := range r.buf {
+		if o.Source == sourceMTN {
+			mtn++
+		}
+		latency += o.Latency
+	}
+	n := float64(len(r.buf))
+	summary.MTNSuccessRate = float64(mtn) / n
+	summary.FallbackRate = float64(len(r.buf)-mtn) / n
+	summary.AverageLatencyMS = float64(latency.Milliseconds()) / n
+	return summary
+}
+
+// handleDiagnostics returns an at-a-glance summary of recent MTN interaction health
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	log.Println("=== Diagnostics Request Received ===")
+	sendResponse(w, r, true, "Recent MTN MoMo interaction summary", diagnostics.Summary(), http.StatusOK)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOutcomeRingSummary(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	mtn := func(ms int) generateOutcome {
+		return generateOutcome{At: at, Source: sourceMTN, Latency: time.Duration(ms) * time.Millisecond}
+	}
+	local := func(ms int, err string) generateOutcome {
+		return generateOutcome{At: at.Add(time.Minute), Source: sourceLocal, Latency: time.Duration(ms) * time.Millisecond, Err: errors.New(err)}
+	}
+
+	tests := []struct {
+		name     string
+		size     int
+		outcomes []generateOutcome
+		want     DiagnosticsSummary
+	}{
+		{"empty", 4, nil, DiagnosticsSummary{}},
+		{
+			"all mtn", 4,
+			[]generateOutcome{mtn(100), mtn(300)},
+			DiagnosticsSummary{TotalRequests: 2, WindowSize: 2, MTNSuccessRate: 1, AverageLatencyMS: 200},
+		},
+		{
+			"mixed", 4,
+			[]generateOutcome{mtn(100), local(50, "MTN MoMo API returned status 503"), mtn(200), mtn(50)},
+			DiagnosticsSummary{
+				TotalRequests: 4, WindowSize: 4, MTNSuccessRate: 0.75, FallbackRate: 0.25, AverageLatencyMS: 100,
+				LastError: "MTN MoMo API returned status 503", LastErrorAt: "2026-03-01T12:01:00Z",
+			},
+		},
+		{
+			// The error stays reported after it falls out of the window
+			"wrapped window", 2,
+			[]generateOutcome{local(10, "timeout"), mtn(100), mtn(300)},
+			DiagnosticsSummary{
+				TotalRequests: 3, WindowSize: 2, MTNSuccessRate: 1, AverageLatencyMS: 200,
+				LastError: "timeout", LastErrorAt: "2026-03-01T12:01:00Z",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := newOutcomeRing(tt.size)
+			for _, o := range tt.outcomes {
+				ring.Record(o)
+			}
+			if got := ring.Summary(); got != tt.want {
+				t.Errorf("Summary() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsEndpoint(t *testing.T) {
+	h := setupTest(t, nil)
+	saved := diagnostics
+	diagnostics = newOutcomeRing(diagnosticsWindowSize)
+	t.Cleanup(func() { diagnostics = saved })
+	diagnostics.Record(generateOutcome{At: time.Now(), Source: sourceMTN, Latency: 40 * time.Millisecond})
+	diagnostics.Record(generateOutcome{At: time.Now(), Source: sourceLocal, Latency: 20 * time.Millisecond, Err: errors.New("boom")})
+
+	rec := doRequest(h, http.MethodGet, "/api/diagnostics", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var got DiagnosticsSummary
+	decodeEnvelope(t, rec, &got)
+	if got.TotalRequests != 2 || got.MTNSuccessRate != 0.5 || got.FallbackRate != 0.5 || got.AverageLatencyMS != 30 || got.LastError != "boom" {
+		t.Errorf("summary = %+v", got)
+	}
+}
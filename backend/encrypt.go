@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// encryptionAlgorithm names how encryptedApiKey is produced: RSA-OAEP with SHA-256
+// (as both the hash and the MGF1 hash) and an empty label, base64 encoded (std).
+// It is the JWA name of the algorithm.
+const encryptionAlgorithm = "RSA-OAEP-256"
+
+// minClientKeyBits is the smallest RSA public key accepted as publicKey
+const minClientKeyBits = 2048
+
+// parseClientPublicKey parses the PEM publicKey of a generate request: an RSA key as a
+// PKIX "PUBLIC KEY" or PKCS #1 "RSA PUBLIC KEY" block, of at least minClientKeyBits
+func parseClientPublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("publicKey must be a PEM encoded RSA public key")
+	}
+
+	var key *rsa.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("publicKey is not a valid public key: %v", err)
+		}
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("publicKey must be an RSA public key")
+		}
+		key = rsaKey
+	case "RSA PUBLIC KEY":
+		parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("publicKey is not a valid RSA public key: %v", err)
+		}
+		key = parsed
+	default:
+		return nil, fmt.Errorf("publicKey must be a PUBLIC KEY or RSA PUBLIC KEY PEM block, got %s", block.Type)
+	}
+
+	if key.N.BitLen() < minClientKeyBits {
+		return nil, fmt.Errorf("publicKey must be at least %d bits, got %d", minClientKeyBits, key.N.BitLen())
+	}
+	return key, nil
+}
+
+// encryptAPIKey encrypts apiKey to key with encryptionAlgorithm
+func encryptAPIKey(key *rsa.PublicKey, apiKey string) (string, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, []byte(apiKey), nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// encryptResponseKeys replaces every plaintext copy of the API key(s) in resp with
+// encryptedApiKey. base64Auth, the QR code and the test command embed the key too,
+// so they are dropped.
+func encryptResponseKeys(resp *MomoKeyResponse, key *rsa.PublicKey) error {
+	encrypted, err := encryptAPIKey(key, resp.APIKey)
+	if err != nil {
+		return err
+	}
+	resp.EncryptedAPIKey = encrypted
+	resp.EncryptionAlgorithm = encryptionAlgorithm
+	resp.APIKey = ""
+	resp.Base64Auth = ""
+	resp.QRCode = ""
+	resp.TestCommand = ""
+
+	for i := range resp.Keys {
+		if resp.Keys[i].EncryptedAPIKey, err = encryptAPIKey(key, resp.Keys[i].APIKey); err != nil {
+			return err
+		}
+		resp.Keys[i].APIKey = ""
+		resp.Keys[i].Base64Auth = ""
+	}
+	return nil
+}
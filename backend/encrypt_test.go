@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testRSAKey generates an RSA key of bits for the test
+func testRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return key
+}
+
+// pemBlock encodes der as a PEM block of typ
+func pemBlock(typ string, der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+}
+
+// decryptAPIKey reverses encryptAPIKey with the matching private key
+func decryptAPIKey(t *testing.T, key *rsa.PrivateKey, encrypted string) string {
+	t.Helper()
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatalf("encryptedApiKey is not base64: %v", err)
+	}
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), nil, key, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt encryptedApiKey: %v", err)
+	}
+	return string(plaintext)
+}
+
+func TestEncryptedAPIKeyRoundTrip(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	private := testRSAKey(t, 2048)
+	pkix, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	tests := []struct {
+		name      string
+		publicKey string
+		keyCount  int
+	}{
+		{"PKIX public key", pemBlock("PUBLIC KEY", pkix), 1},
+		{"PKCS #1 public key", pemBlock("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&private.PublicKey)), 1},
+		{"several keys", pemBlock("PUBLIC KEY", pkix), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnSuccess(apiKey))
+
+			body, _ := json.Marshal(map[string]interface{}{"primaryKey": testSubscriptionKey, "publicKey": tt.publicKey, "keyCount": tt.keyCount})
+			rec := doRequest(h, http.MethodPost, "/api/generate", string(body))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want 201 (body %s)", rec.Code, rec.Body)
+			}
+			if raw := rec.Body.String(); strings.Contains(raw, apiKey) {
+				t.Fatalf("response carries the plaintext API key: %s", raw)
+			}
+
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			// base64Auth and the test command embed the key too
+			if resp.Base64Auth != "" || resp.TestCommand != "" {
+				t.Errorf("base64Auth = %q, testCommand = %q; want both dropped", resp.Base64Auth, resp.TestCommand)
+			}
+			if resp.EncryptionAlgorithm != "RSA-OAEP-256" {
+				t.Errorf("encryptionAlgorithm = %q, want RSA-OAEP-256", resp.EncryptionAlgorithm)
+			}
+			if got := decryptAPIKey(t, private, resp.EncryptedAPIKey); got != apiKey {
+				t.Errorf("decrypted encryptedApiKey = %q, want %q", got, apiKey)
+			}
+			for i, k := range resp.Keys {
+				if got := decryptAPIKey(t, private, k.EncryptedAPIKey); got != apiKey {
+					t.Errorf("keys[%d]: decrypted encryptedApiKey = %q, want %q", i, got, apiKey)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidPublicKey(t *testing.T) {
+	small := testRSAKey(t, 1024)
+	smallDER, _ := x509.MarshalPKIXPublicKey(&small.PublicKey)
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	ecDER, _ := x509.MarshalPKIXPublicKey(&ec.PublicKey)
+	tests := []struct {
+		name      string
+		publicKey string
+		wantMsg   string
+	}{
+		{"not PEM", "ssh-rsa AAAAB3NzaC1yc2E", "PEM"},
+		{"too small", pemBlock("PUBLIC KEY", smallDER), "2048 bits"},
+		{"not RSA", pemBlock("PUBLIC KEY", ecDER), "RSA"},
+		{"private key block", pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(small)), "RSA PRIVATE KEY"},
+		{"corrupt key", pemBlock("PUBLIC KEY", []byte("corrupt")), "not a valid public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			body, _ := json.Marshal(map[string]string{"primaryKey": testSubscriptionKey, "publicKey": tt.publicKey})
+			rec := doRequest(h, http.MethodPost, "/api/generate", string(body))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+			}
+			if env := decodeEnvelope(t, rec, nil); !strings.Contains(env.Message, tt.wantMsg) {
+				t.Errorf("message = %q, want it to mention %q", env.Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestPublicKeyWithEnvFormat(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	private := testRSAKey(t, 2048)
+
+	body, _ := json.Marshal(map[string]string{
+		"primaryKey": testSubscriptionKey,
+		"publicKey":  pemBlock("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&private.PublicKey)),
+	})
+	if rec := doRequest(h, http.MethodPost, "/api/generate?format=env", string(body)); rec.Code != http.StatusBadRequest {
+		t.Errorf("format=env with a publicKey: status = %d, want 400", rec.Code)
+	}
+}
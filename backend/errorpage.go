@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// prefersHTML reports whether the client's Accept header ranks text/html above JSON,
+// as a browser's does. JSON is the default: a missing Accept, */* or a tie all mean JSON.
+func prefersHTML(r *http.Request) bool {
+	htmlQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return htmlQ > jsonQ
+}
+
+// writeHTMLError writes a minimal HTML error page for browsers
+func writeHTMLError(w http.ResponseWriter, resp Response, statusCode int) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>%d %s</title></head>
+<body>
+<h1>%d %s</h1>
+<p>%s</p>
+<p><small>Error code: %s</small></p>
+</body>
+</html>
+`, statusCode, http.StatusText(statusCode), statusCode, http.StatusText(statusCode),
+		html.EscapeString(resp.Message), html.EscapeString(resp.ErrorCode))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	setSignature(w, buf.Bytes())
+	w.WriteHeader(statusCode)
+	w.Write(buf.Bytes())
+}
+
+// recoverPanic turns a panicking handler into a 500 INTERNAL_ERROR response instead
+// of a dropped connection, logging the stack trace. http.ErrAbortHandler is
+// re-panicked, since it is how handlers deliberately abort a response.
+func recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			log.Printf("ERROR: Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			sendError(w, r, errInternal, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
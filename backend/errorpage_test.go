@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrefersHTML(t *testing.T) {
+	tests := map[string]bool{
+		"":                 false,
+		"*/*":              false,
+		"application/json": false,
+		"text/html":        true,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": true,
+		"application/json, text/html":                                     false,
+		"text/html;q=0.5, application/json":                               false,
+		"application/json;q=0.5, text/html":                               true,
+	}
+	for accept, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		if got := prefersHTML(r); got != want {
+			t.Errorf("prefersHTML(Accept: %q) = %t, want %t", accept, got, want)
+		}
+	}
+}
+
+func TestRecoverPanicHonorsAccept(t *testing.T) {
+	setupTest(t, nil)
+	h := recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom <script>")
+	}))
+
+	tests := []struct {
+		accept          string
+		wantContentType string
+	}{
+		{"", "application/json"},
+		{"application/json", "application/json"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run("Accept="+tt.accept, func(t *testing.T) {
+			rec := doRequest(h, http.MethodGet, "/api/markets", "", "Accept", tt.accept)
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.wantContentType) {
+				t.Fatalf("Content-Type = %q, want %q", ct, tt.wantContentType)
+			}
+			body := rec.Body.String()
+			if strings.HasPrefix(tt.wantContentType, "text/html") {
+				if !strings.HasPrefix(body, "<!DOCTYPE html>") || !strings.Contains(body, "500 Internal Server Error") || !strings.Contains(body, errInternal) {
+					t.Errorf("HTML error page = %s", body)
+				}
+				return
+			}
+			var env testEnvelope
+			if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+				t.Fatalf("JSON error body %q: %v", body, err)
+			}
+			if env.Success || env.ErrorCode != errInternal {
+				t.Errorf("envelope = %+v, want an %s error", env, errInternal)
+			}
+			// The panic value is logged, never returned
+			if strings.Contains(body, "boom") {
+				t.Errorf("response leaks the panic value: %s", body)
+			}
+		})
+	}
+}
+
+func TestRecoverPanicRepanicsAbort(t *testing.T) {
+	h := recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler re-panicked", rec)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
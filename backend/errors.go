@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error codes set in Response.ErrorCode. They are a stable contract for programmatic
+// clients, unlike the human-readable Message, and must not be renamed.
+const (
+	errInvalidRequest         = "INVALID_REQUEST"          // Malformed body or invalid parameters
+	errMissingSubscriptionKey = "MISSING_SUBSCRIPTION_KEY" // No subscription key was supplied
+	errInvalidCallbackHost    = "INVALID_CALLBACK_HOST"    // callbackHost failed validation
+	errNotFound               = "NOT_FOUND"                // The requested record does not exist
+	errUnauthorized           = "UNAUTHORIZED"             // Missing or invalid admin token
+	errAdminDisabled          = "ADMIN_DISABLED"           // Admin endpoints are disabled (no token configured)
+	errInternal               = "INTERNAL_ERROR"           // Unexpected server-side failure
+	errMTNUnavailable         = "MTN_UNAVAILABLE"          // MTN MoMo could not be reached or failed
+	errMTNAuthFailed          = "MTN_AUTH_FAILED"          // MTN MoMo rejected the subscription key
+	errTargetEnvNotAllowed    = "TARGET_ENV_NOT_ALLOWED"   // targetEnvironment is not in ALLOWED_TARGET_ENVS
+	errRequestTimeout         = "REQUEST_TIMEOUT"          // The handler ran over its route timeout
+	errRateLimited            = "RATE_LIMITED"             // The client exceeded RATE_LIMIT_PER_MINUTE
+	errMaintenance            = "MAINTENANCE"              // Generation is paused by maintenance mode
+	errBatchLimit             = "BATCH_LIMIT_EXCEEDED"     // MAX_CONCURRENT_BATCHES batch jobs are already running
+	errInvalidConfig          = "INVALID_CONFIG"           // A reloaded configuration failed validation
+	errBodyTimeout            = "BODY_READ_TIMEOUT"        // The request body did not arrive within BODY_READ_TIMEOUT
+	errBodyTooLarge           = "BODY_TOO_LARGE"           // The request body is over MAX_BODY_BYTES
+	errHTTPSRequired          = "HTTPS_REQUIRED"           // REQUIRE_HTTPS is set and the request came over plain HTTP
+)
+
+// fallbackForced is the fallbackReason when a dev-mode client forced local generation
+const fallbackForced = "FALLBACK_FORCED"
+
+// mtnErrorCode classifies a failed MTN MoMo call into an error code
+func mtnErrorCode(err error) string {
+	var apiErr *momoAPIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		return errMTNAuthFailed
+	}
+	return errMTNUnavailable
+}
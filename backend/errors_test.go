@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	withKey := func(extra string) string {
+		return fmt.Sprintf(`{"primaryKey":%q%s}`, testSubscriptionKey, extra)
+	}
+	tests := []struct {
+		name       string
+		env        map[string]string
+		mtnStatus  int
+		method     string
+		path       string
+		body       string
+		headers    []string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "malformed body", method: http.MethodPost, path: "/api/generate", body: `{"primaryKey":`,
+			wantStatus: http.StatusBadRequest, wantCode: errInvalidRequest},
+		{name: "unknown field", method: http.MethodPost, path: "/api/generate", body: withKey(`,"bogus":1`),
+			wantStatus: http.StatusBadRequest, wantCode: errInvalidRequest},
+		{name: "no subscription key", method: http.MethodPost, path: "/api/generate", body: `{}`,
+			wantStatus: http.StatusBadRequest, wantCode: errMissingSubscriptionKey},
+		{name: "plain http callback URL", method: http.MethodPost, path: "/api/generate", body: withKey(`,"callbackUrl":"http://example.com/cb"`),
+			wantStatus: http.StatusBadRequest, wantCode: errInvalidCallbackHost},
+		{name: "target environment not allowed", env: map[string]string{"ALLOWED_TARGET_ENVS": "sandbox"},
+			method: http.MethodPost, path: "/api/generate", body: withKey(`,"targetEnvironment":"mtnghana"`),
+			wantStatus: http.StatusBadRequest, wantCode: errTargetEnvNotAllowed},
+		{name: "MTN down without fallback", env: map[string]string{"FALLBACK_TARGET_ENVS": "none"}, mtnStatus: http.StatusServiceUnavailable,
+			method: http.MethodPost, path: "/api/generate", body: withKey(""),
+			wantStatus: http.StatusBadGateway, wantCode: errMTNUnavailable},
+		{name: "MTN rejects the key without fallback", env: map[string]string{"FALLBACK_TARGET_ENVS": "none"}, mtnStatus: http.StatusUnauthorized,
+			method: http.MethodPost, path: "/api/generate", body: withKey(""),
+			wantStatus: http.StatusBadGateway, wantCode: errMTNAuthFailed},
+		{name: "maintenance", env: map[string]string{"MAINTENANCE_MODE": "true"},
+			method: http.MethodPost, path: "/api/generate", body: withKey(""),
+			wantStatus: http.StatusServiceUnavailable, wantCode: errMaintenance},
+		{name: "record not found", method: http.MethodGet, path: "/api/credentials/5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d",
+			wantStatus: http.StatusNotFound, wantCode: errNotFound},
+		{name: "missing admin token", env: map[string]string{"ADMIN_API_TOKEN": testAdminToken},
+			method: http.MethodGet, path: "/api/admin/maintenance",
+			wantStatus: http.StatusUnauthorized, wantCode: errUnauthorized},
+		{name: "admin endpoints disabled", env: map[string]string{"ADMIN_API_TOKEN": ""},
+			method: http.MethodGet, path: "/api/admin/maintenance", headers: []string{"Authorization", "Bearer x"},
+			wantStatus: http.StatusForbidden, wantCode: errAdminDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			status := tt.mtnStatus
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			fakeMTN(t, mtnStatus(status))
+
+			rec := doRequest(h, tt.method, tt.path, tt.body, tt.headers...)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			env := decodeEnvelope(t, rec, nil)
+			if env.Success || env.ErrorCode != tt.wantCode {
+				t.Errorf("success = %t, errorCode = %q; want failure with %q (message %q)", env.Success, env.ErrorCode, tt.wantCode, env.Message)
+			}
+		})
+	}
+}
+
+func TestMTNErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&momoAPIError{StatusCode: http.StatusUnauthorized}, errMTNAuthFailed},
+		{&momoAPIError{StatusCode: http.StatusForbidden}, errMTNAuthFailed},
+		{fmt.Errorf("wrapped: %w", &momoAPIError{StatusCode: http.StatusUnauthorized}), errMTNAuthFailed},
+		{&momoAPIError{StatusCode: http.StatusServiceUnavailable}, errMTNUnavailable},
+		{&momoAPIError{StatusCode: http.StatusBadRequest}, errMTNUnavailable},
+		{errors.New("dial tcp: connection refused"), errMTNUnavailable},
+	}
+	for _, tt := range tests {
+		if got := mtnErrorCode(tt.err); got != tt.want {
+			t.Errorf("mtnErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
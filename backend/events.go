@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+)
+
+// eventPublishTimeout bounds publishing one event, which happens off the request path
+const eventPublishTimeout = 5 * time.Second
+
+// eventCredentialsGenerated is the Type of the event published after each generation
+const eventCredentialsGenerated = "credentials.generated"
+
+// CredentialsEvent is the payload published after credentials are generated. It is
+// redacted by construction: it never carries the API key or the subscription key.
+type CredentialsEvent struct {
+	Type              string    `json:"type"`
+	UserID            string    `json:"userId"`
+	CallbackHost      string    `json:"callbackHost"`
+	TargetEnvironment string    `json:"targetEnvironment"`
+	Source            string    `json:"source"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// EventPublisher delivers generation events to a message queue such as Kafka, NATS
+// or SNS. Like ResponseTransformer it is an extension point: register an
+// implementation from an init function with registerEventPublisher and select it
+// with EVENT_PUBLISHER.
+type EventPublisher interface {
+	Publish(ctx context.Context, event CredentialsEvent) error
+}
+
+// noopPublisher drops every event
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(context.Context, CredentialsEvent) error { return nil }
+
+// logPublisher writes each event to the log as a JSON line, for debugging or log shipping
+type logPublisher struct{}
+
+func (logPublisher) Publish(_ context.Context, event CredentialsEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("EVENT: %s", line)
+	return nil
+}
+
+// eventPublishers are the publishers selectable with EVENT_PUBLISHER, by name
+var eventPublishers = map[string]EventPublisher{
+	"none": noopPublisher{},
+	"log":  logPublisher{},
+}
+
+// registerEventPublisher makes a publisher selectable by name. It must be called
+// before configuration is loaded, i.e. from an init function.
+func registerEventPublisher(name string, p EventPublisher) {
+	eventPublishers[name] = p
+}
+
+// eventPublisherNames lists the registered publisher names, for config errors
+func eventPublisherNames() []string {
+	names := make([]string, 0, len(eventPublishers))
+	for name := range eventPublishers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// eventPublisher is the EventPublisher generation events are sent to
+var eventPublisher EventPublisher = noopPublisher{}
+
+// publishEvent sends event in the background so a slow queue never delays the response.
+// Failures are logged; the credentials have already been generated either way.
+func publishEvent(event CredentialsEvent) {
+	publisher := eventPublisher
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+		defer cancel()
+		if err := publisher.Publish(ctx, event); err != nil {
+			log.Printf("ERROR: Failed to publish %s event for user %s: %v", event.Type, event.UserID, err)
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturingPublisher hands every published event to a channel
+type capturingPublisher struct {
+	events chan CredentialsEvent
+	err    error
+}
+
+func (p *capturingPublisher) Publish(_ context.Context, event CredentialsEvent) error {
+	p.events <- event
+	return p.err
+}
+
+// usePublisher registers p under a test name and selects it for the rest of the test
+func usePublisher(t *testing.T, p EventPublisher) map[string]string {
+	t.Helper()
+	registerEventPublisher("test-capture", p)
+	t.Cleanup(func() { delete(eventPublishers, "test-capture") })
+	return map[string]string{"EVENT_PUBLISHER": "test-capture"}
+}
+
+// nextEvent waits for the publisher to receive an event
+func nextEvent(t *testing.T, p *capturingPublisher) CredentialsEvent {
+	t.Helper()
+	select {
+	case e := <-p.events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was published")
+		return CredentialsEvent{}
+	}
+}
+
+func TestGeneratePublishesEvent(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	p := &capturingPublisher{events: make(chan CredentialsEvent, 1)}
+	h := setupTest(t, usePublisher(t, p))
+	fakeMTN(t, mtnSuccess(apiKey))
+
+	resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"example.com"}`, testSubscriptionKey))
+	e := nextEvent(t, p)
+	if e.Type != eventCredentialsGenerated || e.UserID != resp.UserID || e.CallbackHost != "example.com" ||
+		e.TargetEnvironment != defaultTargetEnv || e.Source != sourceMTN || e.Timestamp.IsZero() {
+		t.Errorf("event = %+v, want one describing generated user %s", e, resp.UserID)
+	}
+	if line := fmt.Sprintf("%+v", e); strings.Contains(line, apiKey) || strings.Contains(line, testSubscriptionKey) {
+		t.Errorf("event carries a secret: %s", line)
+	}
+}
+
+func TestPublishFailureIsLogged(t *testing.T) {
+	p := &capturingPublisher{events: make(chan CredentialsEvent, 1), err: errors.New("queue unavailable")}
+	h := setupTest(t, usePublisher(t, p))
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	logs := captureLogs(t)
+
+	// The failure does not affect the response
+	generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	nextEvent(t, p)
+	waitForLog(t, logs, "Failed to publish credentials.generated event")
+}
+
+func TestUnknownEventPublisher(t *testing.T) {
+	t.Setenv("EVENT_PUBLISHER", "kafka")
+	if _, err := loadConfig(); err == nil {
+		t.Error("loadConfig accepted an unregistered EVENT_PUBLISHER")
+	}
+}
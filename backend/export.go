@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// exportFlushEvery is how many rows are written between flushes to the client
+const exportFlushEvery = 100
+
+// exportColumns are the CSV columns of a credential export. API keys are never exported.
+var exportColumns = []string{"userId", "callbackHost", "targetEnvironment", "source", "createdAt", "keyFingerprint"}
+
+// handleExportCredentials streams the credential store (without any secrets) as CSV
+// (?format=csv, the default) or as a JSON array (?format=json). It lists every user
+// we issued credentials to, so it must be guarded by requireAdminToken.
+func handleExportCredentials(w http.ResponseWriter, r *http.Request) {
+	logger := reqLog(r.Context())
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	logger.Printf("=== Credential Export Request Received (format: %s) ===", format)
+
+	switch format {
+	case "csv":
+		exportCredentialsCSV(r.Context(), w)
+	case "json":
+		exportCredentialsJSON(r.Context(), w)
+	default:
+		logger.Printf("ERROR: Unsupported export format %q", format)
+		sendError(w, r, errInvalidRequest, "Unsupported export format, use csv or json", http.StatusBadRequest)
+		return
+	}
+
+	logger.Println("=== Credential Export Request Completed ===")
+}
+
+// flush pushes buffered output to the client if the writer supports it
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// exportStopped reports whether the export must stop early because ctx is done:
+// the route timeout passed or the client went away
+func exportStopped(ctx context.Context, written int) bool {
+	if err := ctx.Err(); err != nil {
+		reqLog(ctx).Printf("ERROR: Credential export stopped after %d records - %v", written, err)
+		return true
+	}
+	return false
+}
+
+// exportCredentialsCSV writes the export as CSV, flushing rows as it goes
+func exportCredentialsCSV(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="credentials.csv"`)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		reqLog(ctx).Printf("ERROR: Failed to write CSV export header: %v", err)
+		return
+	}
+
+	for i, rec := range store.List() {
+		if exportStopped(ctx, i) {
+			cw.Flush()
+			return
+		}
+		row := []string{rec.UserID, rec.CallbackHost, rec.TargetEnv, rec.Source, rec.CreatedAt.UTC().Format(time.RFC3339), rec.KeyFingerprint}
+		if err := cw.Write(row); err != nil {
+			reqLog(ctx).Printf("ERROR: Failed to write CSV export row: %v", err)
+			return
+		}
+		if (i+1)%exportFlushEvery == 0 {
+			cw.Flush()
+			flush(w)
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		reqLog(ctx).Printf("ERROR: Failed to flush CSV export: %v", err)
+	}
+}
+
+// exportCredentialsJSON writes the export as a JSON array, one element at a time.
+// An export stopped early is left unterminated, so clients cannot mistake it for complete.
+func exportCredentialsJSON(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="credentials.json"`)
+
+	if _, err := w.Write([]byte("[")); err != nil {
+		reqLog(ctx).Printf("ERROR: Failed to write JSON export: %v", err)
+		return
+	}
+
+	for i, rec := range store.List() {
+		if exportStopped(ctx, i) {
+			return
+		}
+		if i > 0 {
+			w.Write([]byte(","))
+		}
+		// CredentialRecord only serializes non-secret fields
+		row, err := json.Marshal(rec)
+		if err != nil {
+			reqLog(ctx).Printf("ERROR: Failed to encode JSON export row: %v", err)
+			return
+		}
+		if _, err := w.Write(row); err != nil {
+			reqLog(ctx).Printf("ERROR: Failed to write JSON export row: %v", err)
+			return
+		}
+		if (i+1)%exportFlushEvery == 0 {
+			flush(w)
+		}
+	}
+
+	w.Write([]byte("]\n"))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportCredentialsCSV(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	rec := CredentialRecord{
+		UserID:         "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d",
+		CallbackHost:   "callbacks.example.com",
+		TargetEnv:      "sandbox",
+		Source:         sourceMTN,
+		CreatedAt:      created,
+		KeyFingerprint: keyFingerprint(apiKey),
+	}
+	if err := store.Save(rec, apiKey); err != nil {
+		t.Fatalf("store.Save: %v", err)
+	}
+
+	resp := doRequest(h, http.MethodGet, "/api/credentials/export", "", "Authorization", "Bearer "+testAdminToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.Code, resp.Body)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+	if strings.Contains(resp.Body.String(), apiKey) {
+		t.Error("export contains the API key")
+	}
+
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV: %v", err)
+	}
+	want := [][]string{
+		{"userId", "callbackHost", "targetEnvironment", "source", "createdAt", "keyFingerprint"},
+		{rec.UserID, "callbacks.example.com", "sandbox", "mtn", "2026-03-04T05:06:07Z", keyFingerprint(apiKey)},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows %q, want %d", len(rows), rows, len(want))
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d = %q, want %q", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestExportCredentialsJSON(t *testing.T) {
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+	saveTestRecord(t, "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	saveTestRecord(t, "0b9e3f6a-1c2d-4e5f-8a9b-0c1d2e3f4a5b", "0f1e2d3c4b5a69788796a5b4c3d2e1f0")
+
+	resp := doRequest(h, http.MethodGet, "/api/credentials/export?format=json", "", "Authorization", "Bearer "+testAdminToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.Code, resp.Body)
+	}
+	var records []map[string]interface{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &records); err != nil {
+		t.Fatalf("decoding %s: %v", resp.Body, err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	for _, field := range exportColumns {
+		if _, ok := records[0][field]; !ok {
+			t.Errorf("JSON record lacks %q", field)
+		}
+	}
+}
+
+func TestExportCredentialsRequiresAdminToken(t *testing.T) {
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+	saveTestRecord(t, "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90")
+
+	resp := doRequest(h, http.MethodGet, "/api/credentials/export", "")
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.Code)
+	}
+	if strings.Contains(resp.Body.String(), "5f8c2d2e") {
+		t.Error("rejected export lists a stored user")
+	}
+}
+
+func TestExportCredentialsStopsWhenContextDone(t *testing.T) {
+	setupTest(t, nil)
+	saveTestRecord(t, "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	exportCredentialsCSV(ctx, w)
+	if got := strings.TrimSpace(w.Body.String()); got != strings.Join(exportColumns, ",") {
+		t.Errorf("export after the deadline = %q, want only the header", got)
+	}
+}
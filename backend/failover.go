@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// failoverTransport sends MTN requests to each base URL in order (the primary
+// momoBaseURL first, then MOMO_FALLBACK_BASE_URL) until one answers with something
+// other than a network error, 429 or 5xx. The last base URL's result is returned as is.
+// Requests to any other host pass straight through.
+type failoverTransport struct {
+	next      http.RoundTripper
+	fallbacks []string
+}
+
+// newFailoverTransport wraps next so requests fail over to the given MTN-compatible base URLs
+func newFailoverTransport(next http.RoundTripper, fallbacks ...string) *failoverTransport {
+	return &failoverTransport{next: next, fallbacks: fallbacks}
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.String()
+	if !strings.HasPrefix(target, momoBaseURL) || len(t.fallbacks) == 0 {
+		return t.next.RoundTrip(req)
+	}
+	suffix := strings.TrimPrefix(target, momoBaseURL)
+
+	resp, err := t.next.RoundTrip(req)
+	for _, base := range t.fallbacks {
+		if !shouldFailover(resp, err) {
+			return resp, err
+		}
+		// The body was consumed by the failed attempt, so it must be replayable
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		u, parseErr := url.Parse(base + suffix)
+		if parseErr != nil {
+			return resp, err
+		}
+		if err != nil {
+			log.Printf("FAILOVER: %s %s failed (%v), trying fallback gateway %s", req.Method, target, err, base)
+		} else {
+			log.Printf("FAILOVER: %s %s returned %d, trying fallback gateway %s", req.Method, target, resp.StatusCode, base)
+			resp.Body.Close()
+		}
+
+		next := req.Clone(req.Context())
+		next.URL = u
+		next.Host = ""
+		if req.GetBody != nil {
+			if next.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.next.RoundTrip(next)
+	}
+	return resp, err
+}
+
+// shouldFailover reports whether a gateway's answer is worth retrying on the next
+// one: it could not be reached, is rate limiting us, or failed server-side
+func shouldFailover(resp *http.Response, err error) bool {
+	if err != nil {
+		// A cancelled or timed-out request has nothing left to fail over with
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
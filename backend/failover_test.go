@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFailoverToFallbackGateway(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	tests := []struct {
+		name          string
+		primary       int
+		wantSource    string
+		wantFallbacks bool
+	}{
+		{"primary 503", http.StatusServiceUnavailable, sourceMTN, true},
+		{"primary 429", http.StatusTooManyRequests, sourceMTN, true},
+		{"primary 400 is final", http.StatusBadRequest, sourceLocal, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fallbackCalls atomic.Int32
+			success := mtnSuccess(apiKey)
+			fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fallbackCalls.Add(1)
+				success(w, r)
+			}))
+			defer fallback.Close()
+
+			h := setupTest(t, map[string]string{"MOMO_FALLBACK_BASE_URL": fallback.URL})
+			fakeMTN(t, mtnStatus(tt.primary))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if resp.Source != tt.wantSource {
+				t.Errorf("source = %q, want %q", resp.Source, tt.wantSource)
+			}
+			if tt.wantSource == sourceMTN && resp.APIKey != apiKey {
+				t.Errorf("apiKey = %q, want the fallback gateway's key", resp.APIKey)
+			}
+			if got := fallbackCalls.Load() > 0; got != tt.wantFallbacks {
+				t.Errorf("fallback gateway called = %t, want %t", got, tt.wantFallbacks)
+			}
+		})
+	}
+}
+
+func TestFailoverLeavesOtherHostsAlone(t *testing.T) {
+	var fallbackCalls atomic.Int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fallbackCalls.Add(1) }))
+	defer fallback.Close()
+	other := httptest.NewServer(mtnStatus(http.StatusServiceUnavailable))
+	defer other.Close()
+
+	client := &http.Client{Transport: newFailoverTransport(http.DefaultTransport, fallback.URL)}
+	resp, err := client.Get(other.URL + "/apiuser")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || fallbackCalls.Load() != 0 {
+		t.Errorf("non-MTN request got %d with %d fallback calls, want 503 and none", resp.StatusCode, fallbackCalls.Load())
+	}
+}
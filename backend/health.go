@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// healthTimeout is the budget of the health check, which should answer instantly
+const healthTimeout = 2 * time.Second
+
+// handleHealthz reports that the server is up. It makes no outbound calls, and
+// stays healthy in maintenance mode: the process is alive, generation is just paused.
+// Like handleVersion it is also served for HEAD, where net/http drops the body.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, true, "OK", map[string]interface{}{"status": "ok", "maintenance": maintenanceMode.Load()}, http.StatusOK)
+}
+
+// version is the build version, set at build time with
+// -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// handleVersion reports the build version and Go runtime
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, true, "OK", map[string]string{"version": version, "goVersion": runtime.Version()}, http.StatusOK)
+}
+
+// routeTimeoutBody is the (envelope-shaped) body of a 503 sent when a handler runs over its budget
+const routeTimeoutBody = `{"success":false,"message":"Request timed out","errorCode":"` + errRequestTimeout + `"}`
+
+// withTimeout bounds how long a handler may run, answering 503 once d has passed
+// so a single slow handler cannot hold a connection beyond its budget. The
+// handler's context is cancelled at the deadline. A zero d disables the bound.
+// Streaming handlers must not be wrapped: the response is buffered until the handler returns.
+func withTimeout(h http.HandlerFunc, d time.Duration) http.Handler {
+	if d == 0 {
+		return h
+	}
+	return http.TimeoutHandler(h, d, routeTimeoutBody)
+}
+
+// withStreamTimeout is withTimeout for streaming handlers: the handler's context is
+// cancelled once d has passed, but nothing is buffered, so the handler must check the
+// context between writes and stop. A response that has started cannot become a 503,
+// so it is cut short instead. A zero d disables the bound.
+func withStreamTimeout(h http.HandlerFunc, d time.Duration) http.Handler {
+	if d == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies parses TRUSTED_PROXIES: comma-separated IPs or CIDR ranges
+func parseTrustedProxies(v string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES entries must be IPs or CIDR ranges, got %q", part)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES entries must be IPs or CIDR ranges, got %q", part)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// fromTrustedProxy reports whether the request's connection comes from one of proxies
+func fromTrustedProxy(r *http.Request, proxies []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTTPS reports whether the client reached us over HTTPS: either the connection
+// itself is TLS, or a trusted proxy terminated TLS and says so in X-Forwarded-Proto.
+// The header is ignored from anyone else, since a client can set it to anything.
+func isHTTPS(r *http.Request, proxies []netip.Prefix) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !fromTrustedProxy(r, proxies) {
+		return false
+	}
+	// A chain of proxies may list several protocols; the first is the client's
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// withRequireHTTPS rejects requests that did not arrive over HTTPS with 426, so a
+// client misconfigured to use http:// learns of it instead of its subscription key
+// crossing the network in clear text (again). /healthz is exempt, as load balancer
+// probes usually reach the server directly.
+func withRequireHTTPS(next http.Handler, proxies []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHTTPS(r, proxies) || r.URL.Path == routePath("/healthz") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		log.Printf("ERROR: Rejected %s %s from %s - not over HTTPS (X-Forwarded-Proto %q)", r.Method, r.URL.Path, r.RemoteAddr, r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("Upgrade", "TLS/1.2, HTTP/1.1")
+		w.Header().Set("Connection", "Upgrade")
+		sendError(w, r, errHTTPSRequired, "This server only accepts requests over HTTPS", http.StatusUpgradeRequired)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireHTTPS(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		wantStatus int
+	}{
+		{"https from a trusted proxy", "/api/markets", "10.1.2.3:40000", "https", false, http.StatusOK},
+		{"https from a trusted proxy IP", "/api/markets", "192.0.2.7:40000", "HTTPS", false, http.StatusOK},
+		{"https first in a proxy chain", "/api/markets", "10.1.2.3:40000", "https, http", false, http.StatusOK},
+		{"http from a trusted proxy", "/api/markets", "10.1.2.3:40000", "http", false, http.StatusUpgradeRequired},
+		{"no header from a trusted proxy", "/api/markets", "10.1.2.3:40000", "", false, http.StatusUpgradeRequired},
+		{"https claimed by an untrusted client", "/api/markets", "203.0.113.9:40000", "https", false, http.StatusUpgradeRequired},
+		{"direct TLS connection", "/api/markets", "203.0.113.9:40000", "", true, http.StatusOK},
+		{"plain HTTP health check", "/healthz", "203.0.113.9:40000", "", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"REQUIRE_HTTPS": "true", "TRUSTED_PROXIES": "10.0.0.0/8, 192.0.2.7"})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusUpgradeRequired {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errHTTPSRequired {
+					t.Errorf("errorCode = %q, want %s", env.ErrorCode, errHTTPSRequired)
+				}
+				if got := rec.Header().Get("Upgrade"); got == "" {
+					t.Error("426 response lacks an Upgrade header")
+				}
+			}
+		})
+	}
+}
+
+func TestRequireHTTPSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"without trusted proxies", map[string]string{"REQUIRE_HTTPS": "true"}, true},
+		{"invalid proxy", map[string]string{"REQUIRE_HTTPS": "true", "TRUSTED_PROXIES": "10.0.0.0/8,proxy.internal"}, true},
+		{"invalid CIDR", map[string]string{"TRUSTED_PROXIES": "10.0.0.0/33"}, true},
+		{"IPv6 proxy", map[string]string{"REQUIRE_HTTPS": "true", "TRUSTED_PROXIES": "::1"}, false},
+		{"off", map[string]string{"REQUIRE_HTTPS": "false"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := loadConfig(); (err != nil) != tt.wantErr {
+				t.Errorf("loadConfig err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Languages error messages can be returned in, selected with Accept-Language
+const (
+	langEnglish = "en"
+	langFrench  = "fr"
+)
+
+// frenchMessages is the French error message catalog, keyed by error code. Codes
+// stay language-independent; only the human-readable Message is translated. A code
+// covers several English messages (e.g. every INVALID_REQUEST), so the French
+// message describes the code as a whole.
+var frenchMessages = map[string]string{
+	errInvalidRequest:         "Requête invalide : vérifiez le format et les paramètres",
+	errMissingSubscriptionKey: "Aucune clé d'abonnement fournie dans la requête ou la configuration du serveur",
+	errInvalidCallbackHost:    "Hôte de rappel (callbackHost) invalide ou manquant",
+	errNotFound:               "Enregistrement introuvable",
+	errUnauthorized:           "Jeton d'administration manquant ou invalide",
+	errAdminDisabled:          "Ce point d'accès est désactivé car ADMIN_API_TOKEN n'est pas configuré",
+	errInternal:               "Erreur interne du serveur",
+	errMTNUnavailable:         "MTN MoMo est injoignable ou a échoué",
+	errMTNAuthFailed:          "MTN MoMo a rejeté la clé d'abonnement",
+	errTargetEnvNotAllowed:    "Cet environnement cible n'est pas autorisé sur ce serveur",
+	errRequestTimeout:         "Délai de la requête dépassé",
+	errRateLimited:            "Limite de requêtes dépassée, réessayez après la réinitialisation de la fenêtre",
+	errMaintenance:            "La génération d'identifiants est suspendue pour maintenance, réessayez plus tard",
+	errBatchLimit:             "Trop de traitements par lots sont en cours, réessayez plus tard",
+	errInvalidConfig:          "La configuration rechargée est invalide, la configuration en cours est conservée",
+	errBodyTimeout:            "Le corps de la requête n'a pas été reçu à temps",
+	errBodyTooLarge:           "Le corps de la requête est trop volumineux",
+	errHTTPSRequired:          "Ce serveur n'accepte que les requêtes en HTTPS",
+}
+
+// preferredLanguage picks English or French from the Accept-Language header by
+// quality, falling back to English (the default) for anything else
+func preferredLanguage(r *http.Request) string {
+	best, bestQ := langEnglish, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if (primary == langEnglish || primary == langFrench) && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// localizeError returns the error message for code in the client's language. English
+// messages are returned as given; French ones come from frenchMessages.
+func localizeError(w http.ResponseWriter, r *http.Request, code string, message string) string {
+	if preferredLanguage(r) != langFrench {
+		return message
+	}
+	if fr, ok := frenchMessages[code]; ok {
+		w.Header().Set("Content-Language", langFrench)
+		return fr
+	}
+	return message
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                      langEnglish,
+		"en-US":                 langEnglish,
+		"fr":                    langFrench,
+		"fr-CI, en;q=0.5":       langFrench,
+		"en, fr;q=0.9":          langEnglish,
+		"en;q=0.4, fr-FR;q=0.8": langFrench,
+		"de-DE":                 langEnglish,
+		"de, fr;q=0.3":          langFrench,
+		"fr;q=bad, en;q=0.1":    langEnglish,
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", header)
+		if got := preferredLanguage(r); got != want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	tests := []struct {
+		language            string
+		wantMessage         string
+		wantContentLanguage string
+	}{
+		{"", "Credential record not found", ""},
+		{"en-GB", "Credential record not found", ""},
+		{"fr-FR", frenchMessages[errNotFound], langFrench},
+	}
+	for _, tt := range tests {
+		t.Run("Accept-Language="+tt.language, func(t *testing.T) {
+			h := setupTest(t, nil)
+			rec := doRequest(h, http.MethodGet, "/api/credentials/5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "", "Accept-Language", tt.language)
+			env := decodeEnvelope(t, rec, nil)
+			if env.ErrorCode != errNotFound {
+				t.Errorf("errorCode = %q, want the language-independent %q", env.ErrorCode, errNotFound)
+			}
+			if env.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", env.Message, tt.wantMessage)
+			}
+			if got := rec.Header().Get("Content-Language"); got != tt.wantContentLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantContentLanguage)
+			}
+		})
+	}
+}
+
+// TestEveryErrorCodeTranslated keeps frenchMessages in step with the error codes
+// declared in errors.go, so a new code cannot ship without its French message
+func TestEveryErrorCodeTranslated(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse errors.go: %v", err)
+	}
+	codes := 0
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range spec.Names {
+			if !strings.HasPrefix(name.Name, "err") || i >= len(spec.Values) {
+				continue
+			}
+			lit, ok := spec.Values[i].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				continue
+			}
+			code, _ := strconv.Unquote(lit.Value)
+			codes++
+			if frenchMessages[code] == "" {
+				t.Errorf("error code %s (%s) has no French message in frenchMessages", code, name.Name)
+			}
+		}
+		return true
+	})
+	if codes == 0 {
+		t.Fatal("found no error codes in errors.go")
+	}
+	if len(frenchMessages) != codes {
+		t.Errorf("frenchMessages has %d entries for %d error codes", len(frenchMessages), codes)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// KeyLookupResponse is the response of GET /api/key/{userId}
+type KeyLookupResponse struct {
+	UserID    string `json:"userId"`
+	APIKey    string `json:"apiKey"`
+	KeyMasked bool   `json:"keyMasked"`
+	Source    string `json:"source"`
+
+	// MTNRegistered reports whether MTN still knows the API user. It is only set for
+	// MTN-registered credentials when a server subscription key is configured.
+	MTNRegistered *bool `json:"mtnRegistered,omitempty"`
+}
+
+// handleGetKey returns the API key we stored for a user, for the "I lost my key"
+// case. MTN only returns a key when it is created, so this is our local copy, masked
+// like every stored key read; the admin reveal endpoint returns it in full.
+func handleGetKey(w http.ResponseWriter, r *http.Request) {
+	logger := reqLog(r.Context())
+	userID := mux.Vars(r)["userId"]
+	logger.Printf("=== API Key Lookup Request Received for user %s ===", userID)
+
+	rec, ok := store.Get(userID)
+	if !ok {
+		logger.Printf("No stored credential record found for user %s", userID)
+		sendError(w, r, errNotFound, "No stored API key for this user", http.StatusNotFound)
+		return
+	}
+
+	maskedKey, err := store.MaskedAPIKey(rec)
+	if err != nil {
+		logger.Printf("ERROR: Failed to decrypt stored API key for user %s: %v", userID, err)
+		sendError(w, r, errInternal, "Failed to read stored API key", http.StatusInternalServerError)
+		return
+	}
+
+	resp := KeyLookupResponse{UserID: rec.UserID, APIKey: maskedKey, KeyMasked: true, Source: rec.Source}
+
+	// Confirm the user still exists at MTN, so a stale local key is not mistaken for a live one
+	if key := serverSubscriptionKey(r.Context()); rec.Source == sourceMTN && key != "" {
+		_, err := getAPIUser(r.Context(), key, rec.UserID)
+		var apiErr *momoAPIError
+		switch {
+		case err == nil:
+			registered := true
+			resp.MTNRegistered = &registered
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			registered := false
+			resp.MTNRegistered = &registered
+			logger.Printf("WARNING: API user %s is no longer registered with MTN MoMo", rec.UserID)
+		default:
+			logger.Printf("WARNING: Could not verify API user %s with MTN MoMo: %v", rec.UserID, err)
+		}
+	}
+
+	sendResponse(w, r, true, "Stored API key found", resp, http.StatusOK)
+}
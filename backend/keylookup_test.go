@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetKey(t *testing.T) {
+	const userID, apiKey = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	registered, unregistered := true, false
+	tests := []struct {
+		name           string
+		source         string // "" for no stored record
+		serverKey      string
+		mtnStatus      int
+		wantStatus     int
+		wantRegistered *bool
+	}{
+		{"absent record", "", "", 0, http.StatusNotFound, nil},
+		{"local record", sourceLocal, testSubscriptionKey, http.StatusOK, http.StatusOK, nil},
+		{"mtn record without server key", sourceMTN, "", http.StatusOK, http.StatusOK, nil},
+		{"mtn record still registered", sourceMTN, testSubscriptionKey, http.StatusOK, http.StatusOK, &registered},
+		{"mtn record gone from MTN", sourceMTN, testSubscriptionKey, http.StatusNotFound, http.StatusOK, &unregistered},
+		{"mtn unreachable", sourceMTN, testSubscriptionKey, http.StatusInternalServerError, http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"MOMO_SUBSCRIPTION_KEY": tt.serverKey})
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.mtnStatus)
+				if tt.mtnStatus == http.StatusOK {
+					w.Write([]byte(`{"providerCallbackHost":"example.com","targetEnvironment":"sandbox"}`))
+				}
+			}))
+			if tt.source != "" {
+				rec := CredentialRecord{UserID: userID, Source: tt.source, CreatedAt: time.Now()}
+				if err := store.Save(rec, apiKey); err != nil {
+					t.Fatalf("store.Save: %v", err)
+				}
+			}
+
+			rec := doRequest(h, http.MethodGet, "/api/key/"+userID, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp KeyLookupResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.APIKey != maskSecret(apiKey) || !resp.KeyMasked {
+				t.Errorf("apiKey = %q (masked %t), want %q", resp.APIKey, resp.KeyMasked, maskSecret(apiKey))
+			}
+			if resp.Source != tt.source {
+				t.Errorf("source = %q, want %q", resp.Source, tt.source)
+			}
+			if (resp.MTNRegistered == nil) != (tt.wantRegistered == nil) ||
+				(resp.MTNRegistered != nil && *resp.MTNRegistered != *tt.wantRegistered) {
+				t.Errorf("mtnRegistered = %v, want %v", resp.MTNRegistered, tt.wantRegistered)
+			}
+		})
+	}
+}
+
+// Both stored-key read endpoints mask the key the same way
+func TestStoredKeyReadsShareMasking(t *testing.T) {
+	const userID, apiKey = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	h := setupTest(t, map[string]string{"MOMO_SUBSCRIPTION_KEY": ""})
+	saveTestRecord(t, userID, apiKey)
+
+	var key KeyLookupResponse
+	decodeEnvelope(t, doRequest(h, http.MethodGet, "/api/key/"+userID, ""), &key)
+	var cred CredentialResponse
+	decodeEnvelope(t, doRequest(h, http.MethodGet, "/api/credentials/"+userID, ""), &cred)
+	if key.APIKey != cred.APIKey || key.KeyMasked != cred.KeyMasked {
+		t.Errorf("/api/key returned %q (masked %t), /api/credentials %q (masked %t)", key.APIKey, key.KeyMasked, cred.APIKey, cred.KeyMasked)
+	}
+}
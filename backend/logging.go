@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// logOutput is the LOG_OUTPUT file, if one is in use
+var logOutput *guardedWriter
+
+// guardedWriter serializes writes to the log file and drops writes made after it
+// has been closed, so a handler still logging during shutdown cannot fail or panic
+type guardedWriter struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	closed bool
+}
+
+func (g *guardedWriter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return len(p), nil
+	}
+	return g.w.Write(p)
+}
+
+// Close closes the underlying writer once; later writes are discarded
+func (g *guardedWriter) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	return g.w.Close()
+}
+
+// openLogOutput switches the standard logger to append to the file at path
+func openLogOutput(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	logOutput = &guardedWriter{w: f}
+	setLogOutput(logOutput)
+	return nil
+}
+
+// setLogOutput points the standard logger at w, still teeing every line to the
+// log stream (GET /api/admin/logs/stream)
+func setLogOutput(w io.Writer) {
+	log.SetOutput(io.MultiWriter(w, logStream))
+}
+
+// closeLogOutput closes the log file, if any. It must only be called once the
+// server has fully drained; it points the logger back at stderr first so that
+// anything logged afterwards is not lost.
+func closeLogOutput() {
+	if logOutput == nil {
+		return
+	}
+	setLogOutput(os.Stderr)
+	if err := logOutput.Close(); err != nil {
+		log.Printf("ERROR: Failed to close log file %s: %v", cfg.LogOutput, err)
+	}
+}
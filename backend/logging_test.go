@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingWriteCloser counts the writes that reach it
+type countingWriteCloser struct {
+	writes int
+}
+
+func (c *countingWriteCloser) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func (c *countingWriteCloser) Close() error { return nil }
+
+func TestGuardedWriterDropsWritesAfterClose(t *testing.T) {
+	w := &countingWriteCloser{}
+	g := &guardedWriter{w: w}
+	g.Write([]byte("before\n"))
+	if err := g.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := g.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if n, err := g.Write([]byte("after\n")); n != len("after\n") || err != nil {
+		t.Errorf("Write after Close = %d, %v, want the write silently dropped", n, err)
+	}
+	if w.writes != 1 {
+		t.Errorf("underlying writer saw %d writes, want 1", w.writes)
+	}
+}
+
+func TestShutdownWhileRequestIsLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := openLogOutput(path); err != nil {
+		t.Fatalf("openLogOutput: %v", err)
+	}
+	t.Cleanup(func() {
+		logOutput = nil
+		setLogOutput(io.Discard)
+	})
+
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		// A logger that captured the file before shutdown, like a request-scoped one
+		requestLogger := log.New(logOutput, "", 0)
+		close(started)
+		for i := 0; ; i++ {
+			log.Printf("request still working (%d)", i)
+			requestLogger.Printf("request-scoped line %d", i)
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	go http.Get(srv.URL)
+	<-started
+
+	// Shutdown gives up on the still-running handler, then the log file is closed under it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want the deadline to pass with the request in flight", err)
+	}
+	closeLogOutput()
+	setLogOutput(io.Discard)
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	<-done
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading log file: %v", err)
+	}
+	if !strings.Contains(string(data), "request still working (0)") || !strings.Contains(string(data), "request-scoped line 0") {
+		t.Errorf("log file lacks the lines written before close:\n%s", data)
+	}
+}
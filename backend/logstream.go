@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// logStreamBuffer bounds the lines queued for one log stream client. A client that
+// falls further behind misses lines rather than slowing down the logger.
+const logStreamBuffer = 256
+
+// Keepalive of log stream connections, so a client that vanished without closing is noticed
+const (
+	logStreamPingInterval = 30 * time.Second
+	logStreamWriteTimeout = 10 * time.Second
+)
+
+// logHub fans the standard logger's output out to the connected log stream
+// clients. setLogOutput tees every log line into it.
+type logHub struct {
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+// logStream is the hub behind GET /api/admin/logs/stream
+var logStream = &logHub{clients: make(map[chan string]struct{})}
+
+// Write redacts a log line and queues it for every client. It never blocks and
+// never fails, so the log stream cannot hold up or break regular logging.
+func (h *logHub) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.clients) == 0 {
+		return len(p), nil
+	}
+	line := redactSecrets(string(p))
+	for c := range h.clients {
+		select {
+		case c <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// subscribe registers a client; its channel is closed by unsubscribe or closeAll
+func (h *logHub) subscribe() chan string {
+	c := make(chan string, logStreamBuffer)
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+// unsubscribe removes a client, if closeAll has not already
+func (h *logHub) unsubscribe(c chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+// closeAll disconnects every client. Hijacked connections are not drained by
+// server.Shutdown, so it is registered to run on shutdown.
+func (h *logHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c)
+	}
+}
+
+// logStreamUpgrader upgrades GET /api/admin/logs/stream. Browsers may only connect
+// from the CORS allowed origins; clients that send no Origin (CLI tools) are fine.
+var logStreamUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range liveConfig().CORSAllowedOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	},
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		log.Printf("ERROR: Log stream upgrade failed: %v", reason)
+		sendError(w, r, errInvalidRequest, "A WebSocket upgrade is required: "+reason.Error(), status)
+	},
+}
+
+// handleLogStream tails the server log, redacted, to a WebSocket client. Each log
+// line is sent as one text message, from the moment the client connects. The
+// client only listens; the stream ends when it closes the connection, stops
+// answering pings, or the server shuts down.
+func handleLogStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := logStreamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered
+		return
+	}
+	defer conn.Close()
+
+	lines := logStream.subscribe()
+	defer logStream.unsubscribe(lines)
+	log.Printf("Log stream client connected from %s", r.RemoteAddr)
+
+	// Reading is needed to process the client's close and pong frames; any
+	// read error means the client is gone. A client must answer pings to stay.
+	conn.SetReadDeadline(time.Now().Add(2 * logStreamPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * logStreamPingInterval))
+	})
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(logStreamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			conn.SetWriteDeadline(time.Now().Add(logStreamWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+				log.Printf("Log stream client %s disconnected: %v", r.RemoteAddr, err)
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWriteTimeout)); err != nil {
+				log.Printf("Log stream client %s disconnected: %v", r.RemoteAddr, err)
+				return
+			}
+		case <-gone:
+			log.Printf("Log stream client %s disconnected", r.RemoteAddr)
+			return
+		}
+	}
+}
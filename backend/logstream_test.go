@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// logStreamClients returns the number of connected log stream clients
+func logStreamClients() int {
+	logStream.mu.Lock()
+	defer logStream.mu.Unlock()
+	return len(logStream.clients)
+}
+
+// dialLogStream connects to the log stream of srv with token
+func dialLogStream(srv *httptest.Server, token string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/admin/logs/stream"
+	return websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+}
+
+func TestLogStreamDeliversLines(t *testing.T) {
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, _, err := dialLogStream(srv, testAdminToken)
+	if err != nil {
+		t.Fatalf("dial log stream: %v", err)
+	}
+	log.Printf("log stream test line Ocp-Apim-Subscription-Key: %s", testSubscriptionKey)
+
+	// Lines logged before the test line (the connect message) may come first
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read log stream: %v", err)
+		}
+		line := string(msg)
+		if !strings.Contains(line, "log stream test line") {
+			continue
+		}
+		if strings.Contains(line, testSubscriptionKey) || !strings.Contains(line, redactedPlaceholder) {
+			t.Errorf("streamed line is not redacted: %s", line)
+		}
+		break
+	}
+
+	// A client that closes its end is dropped from the hub
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for logStreamClients() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d log stream clients still registered after disconnect", logStreamClients())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLogStreamRequiresAdminToken(t *testing.T) {
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, resp, err := dialLogStream(srv, "wrong-token")
+	if err == nil {
+		conn.Close()
+		t.Fatal("connected to the log stream with a wrong token")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("handshake response = %v, want 401", resp)
+	}
+	if n := logStreamClients(); n != 0 {
+		t.Errorf("%d log stream clients registered after a rejected handshake", n)
+	}
+}
@@ -2,26 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
+	"github.com/skip2/go-qrcode"
 )
 
 // Response structure for API
 type Response struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Success   bool        `json:"success"`
+	Message   string      `json:"message"`
+	ErrorCode string      `json:"errorCode,omitempty"` // Stable machine-readable code, set on errors
+	Data      interface{} `json:"data,omitempty"`
 }
 
 // MomoKeyRequest structure for incoming requests
@@ -29,8 +36,149 @@ type MomoKeyRequest struct {
 	PrimaryKey   string `json:"primaryKey"`   // Subscription Key (Ocp-Apim-Subscription-Key)
 	SecondaryKey string `json:"secondaryKey"` // Optional secondary key
 	CallbackHost string `json:"callbackHost"` // Provider callback host
+	IncludeQR    bool   `json:"includeQR"`    // Optional PNG QR code of the base64 auth string
+
+	// KeyCount is the number of API keys to create (default 1, max 2). It is decoded as a
+	// json.Number so non-integer values are rejected with a clear error (see intField).
+	KeyCount json.Number `json:"keyCount"`
+
+	// ForceFallback skips MTN and generates credentials locally (only honored with DEV_MODE)
+	ForceFallback bool `json:"forceFallback"`
+
+	// IncludeRawResponse returns MTN's raw (redacted) responses in rawResponses (only honored with DEV_MODE)
+	IncludeRawResponse bool `json:"includeRawResponse"`
+
+	// CallbackURL is a full https callback URL (with path) for markets that accept one.
+	// It takes precedence over CallbackHost and is sent as providerCallbackHost.
+	CallbackURL string `json:"callbackUrl"`
+
+	// TargetEnvironment is the MTN target environment (default sandbox); it must be in ALLOWED_TARGET_ENVS
+	TargetEnvironment string `json:"targetEnvironment"`
+
+	// Product optionally names the MTN product the subscription key belongs to
+	// (collection, disbursement or remittance). It is informational: recorded by REQUEST_CAPTURE.
+	Product string `json:"product"`
+
+	// Base64Format selects what base64Auth encodes: user:key (default, what MTN expects)
+	// or subscriptionKey:key for tooling that wants it
+	Base64Format string `json:"base64Format"`
+
+	// ReferenceID optionally fixes the X-Reference-Id (the new user's ID) instead of
+	// letting the server generate one; it must match REFERENCE_ID_FORMAT
+	ReferenceID string `json:"referenceId"`
+
+	// PublicKey is an optional PEM RSA public key; when given the API key is returned
+	// only encrypted to it, as encryptedApiKey (see encryptResponseKeys)
+	PublicKey string `json:"publicKey"`
+
+	// IncludeProductionHint adds productionTestCommand, the production version of the
+	// test command, to sandbox responses
+	IncludeProductionHint bool `json:"includeProductionHint"`
+
+	// IncludeTimings adds timings, where the request's time went, to the response
+	IncludeTimings bool `json:"includeTimings"`
+
+	// ProductKeys maps products (collection, disbursement, remittance) to their
+	// subscription keys, to create credentials for each in one call. When given it
+	// replaces primaryKey, secondaryKey and product (see handleGenerateProducts).
+	ProductKeys map[string]string `json:"productKeys,omitempty"`
+}
+
+// Bounds of FALLBACK_KEY_BYTES; MTN's own API keys carry 16 bytes
+const (
+	minFallbackKeyBytes = 16
+	maxFallbackKeyBytes = 64
+)
+
+// maxKeysPerUser is the most API keys a single request may create for one user
+const maxKeysPerUser = 2
+
+// intField validates an integer request field decoded as a json.Number, so fractional
+// (1.5) and oversized (1e30) values get a clear error instead of being rounded or
+// failing the whole decode. An absent or zero value means def.
+func intField(name string, n json.Number, def, min, max int) (int, error) {
+	if n == "" || n == "0" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(string(n), 10, 64)
+	if err != nil {
+		if f, ferr := n.Float64(); ferr != nil || f != math.Trunc(f) {
+			return 0, fmt.Errorf("%s must be an integer", name)
+		}
+		return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+	}
+	if v < int64(min) || v > int64(max) {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+	}
+	return int(v), nil
+}
+
+// Compositions of the base64 auth string selectable with base64Format. MTN's token
+// endpoints take HTTP Basic auth of apiUser:apiKey, so only user:key authenticates
+// with MTN; subscriptionKey:key exists for third-party tooling that expects it.
+const (
+	base64UserKey         = "user:key"
+	base64SubscriptionKey = "subscriptionKey:key"
+)
+
+// base64Formats are the valid base64Format values
+var base64Formats = []string{base64UserKey, base64SubscriptionKey}
+
+// composeBase64Auth encodes the credentials in the given composition
+func composeBase64Auth(format, apiUser, apiKey, subscriptionKey string) string {
+	first := apiUser
+	if format == base64SubscriptionKey {
+		first = subscriptionKey
+	}
+	return base64.StdEncoding.EncodeToString([]byte(first + ":" + apiKey))
+}
+
+// normalizeCallbackHost lowercases a callback host and strips a trailing dot (the
+// DNS root), so Example.COM. and example.com register the same. For a callback URL
+// only the host part is normalized; the path is case-sensitive. A www. prefix is
+// kept, since it names a different host.
+func normalizeCallbackHost(host string) string {
+	if u, err := url.Parse(host); err == nil && u.IsAbs() && u.Host != "" {
+		hostname := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		if strings.Contains(hostname, ":") {
+			hostname = "[" + hostname + "]" // IPv6 literal
+		}
+		if port := u.Port(); port != "" {
+			hostname += ":" + port
+		}
+		u.Host = hostname
+		return u.String()
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
 }
 
+// defaultTargetEnv is the target environment used when a request does not name one
+const defaultTargetEnv = "sandbox"
+
+// fallbackAllowed reports whether a request for env may fall back to local
+// generation, per FALLBACK_TARGET_ENVS
+func fallbackAllowed(env string) bool {
+	for _, allowed := range cfg.FallbackTargetEnvs {
+		if allowed == "*" || env == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// targetEnvAllowed reports whether env is listed in ALLOWED_TARGET_ENVS
+func targetEnvAllowed(env string) bool {
+	for _, allowed := range cfg.AllowedTargetEnvs {
+		if env == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// store holds the local copies of generated credentials
+var store *credentialStore
+
 // CreateUserResponse structure for API user creation response
 type CreateUserResponse struct {
 	UserID       string `json:"userId"`
@@ -45,7 +193,7 @@ type CreateKeyResponse struct {
 
 // MomoKeyResponse structure for generated keys
 type MomoKeyResponse struct {
-	APIKey       string `json:"apiKey"`
+	APIKey       string `json:"apiKey,omitempty"` // Omitted when encrypted to a client publicKey
 	APIUser      string `json:"apiUser"`
 	UserID       string `json:"userId"`
 	CallbackHost string `json:"callbackHost"`
@@ -53,121 +201,335 @@ type MomoKeyResponse struct {
 	TargetEnv    string `json:"targetEnvironment"`
 	TestCommand  string `json:"testCommand,omitempty"` // Optional curl command for testing
 	Base64Auth   string `json:"base64Auth,omitempty"`  // Base64 encoded auth string (apiUser:apiKey)
+	QRCode       string `json:"qrCode,omitempty"`      // PNG data URI encoding Base64Auth, when requested
+
+	// ProductionTestCommand shows how testCommand differs in production, with placeholders
+	ProductionTestCommand string `json:"productionTestCommand,omitempty"`
+
+	// Attempts reports how many tries the MTN calls needed, surfacing flakiness early
+	Attempts CallAttempts `json:"attempts"`
+	// Source is where the credentials came from: "mtn" (registered) or "local" (fallback)
+	Source string `json:"source"`
+	// ServerTime is DateTime in SERVER_TIMEZONE, only set when that is configured
+	ServerTime string `json:"serverTime,omitempty"`
+	// FallbackReason is the error code (e.g. MTN_UNAVAILABLE) explaining a local fallback
+	FallbackReason string `json:"fallbackReason,omitempty"`
+	// Keys lists every created key when keyCount > 1. MTN only keeps the most recently
+	// created key active, so earlier keys are usually invalidated by later ones.
+	Keys []IssuedKey `json:"keys,omitempty"`
+	// Debug holds the (redacted) outbound MTN requests when ?debug=true in dev mode
+	Debug *DebugInfo `json:"debug,omitempty"`
+
+	// RawResponses holds MTN's raw (redacted) responses when includeRawResponse is honored
+	RawResponses []RawResponse `json:"rawResponses,omitempty"`
+
+	// Warnings are non-fatal problems with the request, such as identical primary and secondary keys
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Base64Format is the composition base64Auth encodes (see composeBase64Auth)
+	Base64Format string `json:"base64Format"`
+
+	// SubscriptionKeySuffix is the last 4 characters of the subscription key used, when
+	// INCLUDE_SUBSCRIPTION_KEY_SUFFIX is set, to tell credentials from different keys apart
+	SubscriptionKeySuffix string `json:"subscriptionKeySuffix,omitempty"`
+
+	// EncryptedAPIKey is the API key encrypted to the request's publicKey with
+	// EncryptionAlgorithm, in place of apiKey
+	EncryptedAPIKey     string `json:"encryptedApiKey,omitempty"`
+	EncryptionAlgorithm string `json:"encryptionAlgorithm,omitempty"`
+
+	// Timings is where the request's time went, when includeTimings is set
+	Timings *Timings `json:"timings,omitempty"`
+
+	// Verified reports whether ?verify=true proved the credentials work with a token
+	// and an authenticated call; it is absent when verification was not requested
+	Verified *bool `json:"verified,omitempty"`
+	// VerificationError explains why verification failed
+	VerificationError string `json:"verificationError,omitempty"`
 }
 
-// createAPIUser calls the MTN MoMo API to create an API user
-func createAPIUser(subscriptionKey string, callbackHost string) (string, error) {
-	// Generate a UUID for the API user
-	apiUser := uuid.New().String()
-	log.Printf("Generated new API User UUID: %s", apiUser)
+// IssuedKey is one API key created for the user
+type IssuedKey struct {
+	APIKey          string `json:"apiKey,omitempty"`
+	Base64Auth      string `json:"base64Auth,omitempty"`
+	EncryptedAPIKey string `json:"encryptedApiKey,omitempty"` // In place of apiKey when a publicKey was given
+	Active          bool   `json:"active"`                    // Only the most recently created key is active at MTN
+}
+
+// CallAttempts reports how many tries each MTN call needed (0 if it was never made)
+type CallAttempts struct {
+	UserCreate int `json:"userCreate"`
+	KeyCreate  int `json:"keyCreate"`
+}
+
+// Timings breaks down where a generate request's time went, in milliseconds. The MTN
+// calls include their retries; totalMs also covers validation, storage and the rest.
+type Timings struct {
+	UserCreateMs int64 `json:"userCreateMs"`
+	KeyCreateMs  int64 `json:"keyCreateMs"` // All keys together, with propagation retries
+	TotalMs      int64 `json:"totalMs"`
+}
+
+// createAPIUser calls the MTN MoMo API to create an API user with the given
+// X-Reference-Id, or a newly generated one when referenceID is empty.
+// It also returns the number of attempts the creation took.
+func createAPIUser(ctx context.Context, subscriptionKey string, callbackHost string, referenceID string) (string, int, error) {
+	logger := reqLog(ctx)
+	apiUser := referenceID
+	if apiUser == "" {
+		apiUser = referenceIDFormat.New()
+		logger.Printf("Generated new API User reference ID: %s", apiUser)
+	} else {
+		logger.Printf("Using client-supplied API User reference ID: %s", apiUser)
+	}
 
 	// Create the request URL
-	url := "https://sandbox.momodeveloper.mtn.com/v1_0/apiuser"
-	log.Printf("Preparing API request to: %s", url)
+	url := provisioningURL("/apiuser")
+	logger.Printf("Preparing API request to: %s", url)
 
 	// Create the request body
 	requestBody := map[string]string{
 		"providerCallbackHost": callbackHost,
 	}
-	log.Printf("Request body includes callback host: %s", callbackHost)
+	logger.Printf("Request body includes callback host: %s", callbackHost)
 
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
-		log.Printf("ERROR: Failed to marshal request body: %v", err)
-		return "", err
+		logger.Printf("ERROR: Failed to marshal request body: %v", err)
+		return "", 0, err
 	}
 
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	// The same X-Reference-Id is reused on every attempt, so retries cannot create a second
+	// user. Connection reset retries are kept to client-supplied IDs, where idempotency
+	// is guaranteed end to end.
+	attempts, err := withRetry(ctx, "create API user", referenceID != "", func(attempt int) error {
+		// Create the HTTP request
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			logger.Printf("ERROR: Failed to create HTTP request: %v", err)
+			return err
+		}
+
+		// Add headers
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Ocp-Apim-Subscription-Key", subscriptionKey)
+		req.Header.Set("X-Reference-Id", apiUser)
+		logger.Println("Added required headers: Content-Type, Ocp-Apim-Subscription-Key, X-Reference-Id")
+		recordOutbound(ctx, "create API user", attempt, req, jsonBody, subscriptionKey)
+
+		// Send the request
+		logger.Println("Sending API User creation request to MTN MoMo API...")
+		release := acquireMomoSlot()
+		defer release()
+		resp, err := momoClient().Do(req)
+		if err != nil {
+			logger.Printf("ERROR: HTTP request failed: %v", err)
+			return err
+		}
+		defer resp.Body.Close()
+		logNegotiatedProtocol(resp)
+		captureRawResponse(ctx, "create API user", attempt, resp, subscriptionKey)
+
+		// Check response status
+		logger.Printf("Received response with status code: %d", resp.StatusCode)
+		if resp.StatusCode == http.StatusConflict && attempt > 1 {
+			// A previous attempt reached MTN even though we never saw its response
+			logger.Printf("API User %s already exists from a previous attempt", apiUser)
+			return nil
+		}
+		if resp.StatusCode != http.StatusCreated {
+			body := readErrorBody(resp)
+			apiErr := &momoAPIError{Operation: "create API user", StatusCode: resp.StatusCode, Body: formatErrorBody(body, subscriptionKey)}
+			if apiErr.Body != "" {
+				logger.Printf("ERROR: API returned non-success status: %d, body: %s", resp.StatusCode, apiErr.Body)
+			} else {
+				logger.Printf("ERROR: API returned non-success status: %d", resp.StatusCode)
+			}
+			return apiErr
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("ERROR: Failed to create HTTP request: %v", err)
-		return "", err
+		return "", attempts, err
 	}
 
-	// Add headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Ocp-Apim-Subscription-Key", subscriptionKey)
-	req.Header.Set("X-Reference-Id", apiUser)
-	log.Println("Added required headers: Content-Type, Ocp-Apim-Subscription-Key, X-Reference-Id")
+	logger.Printf("API User created successfully with ID: %s (attempts: %d)", apiUser, attempts)
+	return apiUser, attempts, nil
+}
 
-	// Send the request
-	log.Println("Sending API User creation request to MTN MoMo API...")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// createAPIKey calls the MTN MoMo API to create an API key for the given API user.
+// It also returns the number of attempts the creation took.
+func createAPIKey(ctx context.Context, subscriptionKey string, apiUser string) (string, int, error) {
+	logger := reqLog(ctx)
+	// Create the request URL
+	url := provisioningURL(fmt.Sprintf("/apiuser/%s/apikey", apiUser))
+	logger.Printf("Preparing API Key request for user %s", apiUser)
+	logger.Printf("Request URL: %s", url)
+
+	var apiKey string
+	// MTN keeps only the newest key active, so a repeated creation still ends with one usable key
+	attempts, err := withRetry(ctx, "create API key", true, func(attempt int) error {
+		// Create the HTTP request
+		req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+		if err != nil {
+			logger.Printf("ERROR: Failed to create HTTP request for API Key: %v", err)
+			return err
+		}
+
+		// Add headers
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Ocp-Apim-Subscription-Key", subscriptionKey)
+		logger.Println("Added required headers: Content-Type, Ocp-Apim-Subscription-Key")
+		recordOutbound(ctx, "create API key", attempt, req, nil, subscriptionKey)
+
+		// Send the request
+		logger.Println("Sending API Key creation request to MTN MoMo API...")
+		release := acquireMomoSlot()
+		defer release()
+		resp, err := momoClient().Do(req)
+		if err != nil {
+			logger.Printf("ERROR: HTTP request for API Key failed: %v", err)
+			return err
+		}
+		defer resp.Body.Close()
+		logNegotiatedProtocol(resp)
+		captureRawResponse(ctx, "create API key", attempt, resp, subscriptionKey)
+
+		// Check response status
+		logger.Printf("Received API Key response with status code: %d", resp.StatusCode)
+		if resp.StatusCode != http.StatusCreated {
+			body := readErrorBody(resp)
+			apiErr := &momoAPIError{Operation: "create API key", StatusCode: resp.StatusCode, Body: formatErrorBody(body, subscriptionKey)}
+			if apiErr.Body != "" {
+				logger.Printf("ERROR: API Key creation failed with status: %d, body: %s", resp.StatusCode, apiErr.Body)
+			} else {
+				logger.Printf("ERROR: API Key creation failed with status: %d", resp.StatusCode)
+			}
+			return apiErr
+		}
+
+		// Parse the response
+		var result struct {
+			APIKey string `json:"apiKey"`
+		}
+
+		body, err := responseBody(resp)
+		if err != nil {
+			logger.Printf("ERROR: Failed to decompress API Key response: %v", err)
+			return err
+		}
+		if err := json.NewDecoder(body).Decode(&result); err != nil {
+			logger.Printf("ERROR: Failed to parse API Key response: %v", err)
+			return err
+		}
+		apiKey = result.APIKey
+		return nil
+	})
 	if err != nil {
-		log.Printf("ERROR: HTTP request failed: %v", err)
-		return "", err
+		return "", attempts, err
 	}
-	defer resp.Body.Close()
 
-	// Check response status
-	log.Printf("Received response with status code: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("ERROR: API returned non-success status: %d, body: %s", resp.StatusCode, string(body))
-		return "", fmt.Errorf("failed to create API user: %s, status: %d", string(body), resp.StatusCode)
-	}
+	logger.Printf("Successfully retrieved API Key from MTN MoMo API (attempts: %d)", attempts)
+	// We don't log the actual API key for security reasons
+	return apiKey, attempts, nil
+}
 
-	log.Printf("API User created successfully with ID: %s", apiUser)
-	return apiUser, nil
+// APIUserDetails is the MTN MoMo representation of an existing API user
+type APIUserDetails struct {
+	UserID               string `json:"userId,omitempty"` // Only echoed by some markets
+	ProviderCallbackHost string `json:"providerCallbackHost"`
+	TargetEnvironment    string `json:"targetEnvironment"`
 }
 
-// createAPIKey calls the MTN MoMo API to create an API key for the given API user
-func createAPIKey(subscriptionKey string, apiUser string) (string, error) {
+// getAPIUser calls the MTN MoMo API to look up an existing API user
+func getAPIUser(ctx context.Context, subscriptionKey string, apiUser string) (*APIUserDetails, error) {
+	logger := reqLog(ctx)
 	// Create the request URL
-	url := fmt.Sprintf("https://sandbox.momodeveloper.mtn.com/v1_0/apiuser/%s/apikey", apiUser)
-	log.Printf("Preparing API Key request for user %s", apiUser)
-	log.Printf("Request URL: %s", url)
+	url := provisioningURL("/apiuser/" + apiUser)
+	logger.Printf("Preparing API User lookup request for user %s", apiUser)
 
 	// Create the HTTP request
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		log.Printf("ERROR: Failed to create HTTP request for API Key: %v", err)
-		return "", err
+		logger.Printf("ERROR: Failed to create HTTP request for API User lookup: %v", err)
+		return nil, err
 	}
 
 	// Add headers
-	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Ocp-Apim-Subscription-Key", subscriptionKey)
-	log.Println("Added required headers: Content-Type, Ocp-Apim-Subscription-Key")
 
 	// Send the request
-	log.Println("Sending API Key creation request to MTN MoMo API...")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	release := acquireMomoSlot()
+	defer release()
+	resp, err := momoClient().Do(req)
 	if err != nil {
-		log.Printf("ERROR: HTTP request for API Key failed: %v", err)
-		return "", err
+		logger.Printf("ERROR: HTTP request for API User lookup failed: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
+	logNegotiatedProtocol(resp)
 
 	// Check response status
-	log.Printf("Received API Key response with status code: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("ERROR: API Key creation failed with status: %d, body: %s", resp.StatusCode, string(body))
-		return "", fmt.Errorf("failed to create API key: %s, status: %d", string(body), resp.StatusCode)
+	logger.Printf("Received API User lookup response with status code: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		body := readErrorBody(resp)
+		return nil, &momoAPIError{Operation: "get API user", StatusCode: resp.StatusCode, Body: formatErrorBody(body, subscriptionKey)}
 	}
 
-	// Parse the response
-	var result struct {
-		APIKey string `json:"apiKey"`
+	var details APIUserDetails
+	body, err := responseBody(resp)
+	if err != nil {
+		logger.Printf("ERROR: Failed to decompress API User lookup response: %v", err)
+		return nil, err
+	}
+	if err := json.NewDecoder(body).Decode(&details); err != nil {
+		logger.Printf("ERROR: Failed to parse API User lookup response: %v", err)
+		return nil, err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("ERROR: Failed to parse API Key response: %v", err)
-		return "", err
+	// Some markets echo the user ID back normalized (case, hyphens), so compare as UUIDs
+	if details.UserID != "" && !sameUserID(details.UserID, apiUser) {
+		logger.Printf("ERROR: API User lookup returned a different user: requested %s, got %s", apiUser, details.UserID)
+		return nil, fmt.Errorf("API user mismatch: requested %s, MTN returned %s", apiUser, details.UserID)
 	}
+	return &details, nil
+}
 
-	log.Println("Successfully retrieved API Key from MTN MoMo API")
-	// We don't log the actual API key for security reasons
-	return result.APIKey, nil
+// sameUserID compares two API user IDs, tolerating differences in case and UUID
+// formatting (e.g. hyphens stripped). Non-UUID values fall back to a case-insensitive match.
+func sameUserID(a, b string) bool {
+	ua, errA := uuid.Parse(strings.TrimSpace(a))
+	ub, errB := uuid.Parse(strings.TrimSpace(b))
+	if errA == nil && errB == nil {
+		return ua == ub
+	}
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// Generator creates the credentials used by the local fallback path.
+// Replace generator to produce custom credential formats required by internal gateways.
+type Generator interface {
+	NewUserID() string
+	NewAPIKey() string
 }
 
+// defaultGenerator produces MTN-style credentials: a UUID user and a hex key (32 characters by default, see FALLBACK_KEY_BYTES)
+type defaultGenerator struct{}
+
+func (defaultGenerator) NewUserID() string { return fallbackGenerateAPIUser() }
+func (defaultGenerator) NewAPIKey() string { return fallbackGenerateAPIKey() }
+
+// generator is the Generator used for local fallback credentials
+var generator Generator = defaultGenerator{}
+
 // fallbackGenerateAPIKey creates an API key locally as a fallback
 func fallbackGenerateAPIKey() string {
-	// Generate a random API key (32 hex characters)
-	randomBytes := make([]byte, 16) // 16 bytes will generate 32 hex characters
+	// Generate a random API key (two hex characters per byte, 32 by default)
+	n := cfg.FallbackKeyBytes
+	if n == 0 {
+		n = minFallbackKeyBytes
+	}
+	randomBytes := make([]byte, n)
 	_, err := rand.Read(randomBytes)
 	if err != nil {
 		log.Fatal(err)
@@ -184,177 +546,992 @@ func fallbackGenerateAPIUser() string {
 	return uuid.New().String()
 }
 
+// qrCodeDataURI encodes content as a PNG QR code and returns it as a data URI
+func qrCodeDataURI(content string) (string, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// resolveSubscriptionKey picks the subscription key to use, in order of precedence:
+// the request's primary key, the request's secondary key, then the server's key
+// (MOMO_SUBSCRIPTION_KEY or the SECRET_PROVIDER). It also returns a description of
+// where the key came from (never the key itself).
+func resolveSubscriptionKey(ctx context.Context, req MomoKeyRequest) (string, string) {
+	switch {
+	case req.PrimaryKey != "":
+		return req.PrimaryKey, "request primaryKey"
+	case req.SecondaryKey != "":
+		return req.SecondaryKey, "request secondaryKey"
+	}
+	if key := serverSubscriptionKey(ctx); key != "" {
+		if cfg.SecretProvider == secretProviderEnv {
+			return key, "server configuration (MOMO_SUBSCRIPTION_KEY)"
+		}
+		return key, fmt.Sprintf("server secret provider (%s)", cfg.SecretProvider)
+	}
+	return "", ""
+}
+
+// validateCallbackURL checks that a callback URL is an absolute https URL with a host
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("callbackUrl must be an absolute URL")
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("callbackUrl must use https")
+	}
+	return nil
+}
+
 // handleGenerateKeys handles the key generation request
 func handleGenerateKeys(w http.ResponseWriter, r *http.Request) {
-	log.Println("=== New API Key Generation Request Received ===")
+	handlerStart := time.Now()
+	logger := reqLog(r.Context())
+	logger.Println("=== New API Key Generation Request Received ===")
 
 	var req MomoKeyRequest
 
 	// Parse JSON request body
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeJSONBody(r, &req)
 	if err != nil {
-		log.Printf("ERROR: Invalid request format - %v", err)
-		sendResponse(w, false, "Invalid request format", nil, http.StatusBadRequest)
+		logger.Printf("ERROR: Invalid request format - %v", err)
+		sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.ProductKeys) > 0 {
+		handleGenerateProducts(w, r, req)
 		return
 	}
 
 	// Validate input
-	if req.PrimaryKey == "" {
-		log.Println("ERROR: Missing required field - Subscription Key (Primary Key)")
-		sendResponse(w, false, "Subscription Key (Primary Key) is required", nil, http.StatusBadRequest)
+	subscriptionKey, keySource := resolveSubscriptionKey(r.Context(), req)
+	if subscriptionKey == "" {
+		logger.Println("ERROR: No subscription key in the request (primary or secondary) or in MOMO_SUBSCRIPTION_KEY")
+		sendError(w, r, errMissingSubscriptionKey, "no subscription key available from request or server configuration", http.StatusBadRequest)
+		return
+	}
+	logger.Printf("INFO: Using subscription key from %s", keySource)
+
+	// Keys not shaped like MTN's are almost always copy-paste slips (a truncated key,
+	// the key name pasted instead); strict mode catches them before any MTN call
+	if cfg.StrictKeyValidation {
+		for _, k := range []struct{ field, key string }{{"primaryKey", req.PrimaryKey}, {"secondaryKey", req.SecondaryKey}} {
+			if field, key := k.field, k.key; key != "" && !subscriptionKeyPattern.MatchString(key) {
+				logger.Printf("ERROR: %s is not shaped like an MTN subscription key (%d characters)", field, len(key))
+				sendError(w, r, errInvalidRequest, fmt.Sprintf("%s must be 32 hexadecimal characters", field), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Identical keys make failover to the secondary key meaningless
+	var warnings []string
+	if req.SecondaryKey != "" && strings.TrimSpace(req.SecondaryKey) == strings.TrimSpace(req.PrimaryKey) {
+		if cfg.StrictKeyValidation {
+			logger.Println("ERROR: secondaryKey is identical to primaryKey")
+			sendError(w, r, errInvalidRequest, "secondaryKey must differ from primaryKey", http.StatusBadRequest)
+			return
+		}
+		logger.Println("WARNING: secondaryKey is identical to primaryKey, failover will not help")
+		warnings = append(warnings, "secondaryKey is identical to primaryKey, so it provides no failover")
+	}
+
+	// A full callback URL replaces the host-only field when both are given
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			logger.Printf("ERROR: Invalid callback URL - %v", err)
+			sendError(w, r, errInvalidCallbackHost, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.CallbackHost != "" {
+			logger.Printf("INFO: Both callbackUrl and callbackHost provided, using callbackUrl")
+		}
+		req.CallbackHost = req.CallbackURL
+	}
+
+	if cfg.NormalizeCallbackHost && req.CallbackHost != "" {
+		if normalized := normalizeCallbackHost(req.CallbackHost); normalized != req.CallbackHost {
+			logger.Printf("INFO: Normalized callback host %q to %q", req.CallbackHost, normalized)
+			req.CallbackHost = normalized
+		}
+	}
+
+	// Reject callback hosts MTN would refuse anyway, with a clearer error than MTN's
+	if len(req.CallbackHost) > cfg.MaxCallbackHostLength {
+		logger.Printf("ERROR: Callback host too long - %d characters (limit %d)", len(req.CallbackHost), cfg.MaxCallbackHostLength)
+		sendError(w, r, errInvalidCallbackHost, fmt.Sprintf("Callback host must be at most %d characters", cfg.MaxCallbackHostLength), http.StatusBadRequest)
 		return
 	}
 
+	// Number of API keys to create for the user (MTN allows at most 2)
+	keyCount, err := intField("keyCount", req.KeyCount, 1, 1, maxKeysPerUser)
+	if err != nil {
+		logger.Printf("ERROR: Invalid key count %q - %v", req.KeyCount, err)
+		sendError(w, r, errInvalidRequest, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Guard against accidental production provisioning from a sandbox-only deployment
+	targetEnv := req.TargetEnvironment
+	if targetEnv == "" {
+		targetEnv = defaultTargetEnv
+	}
+	if !knownMarket(targetEnv) {
+		logger.Printf("ERROR: Unknown target environment %q", targetEnv)
+		sendError(w, r, errInvalidRequest, fmt.Sprintf("targetEnvironment must be one of %s", strings.Join(marketCodes(), ", ")), http.StatusBadRequest)
+		return
+	}
+	if !targetEnvAllowed(targetEnv) {
+		logger.Printf("ERROR: Target environment %q is not in ALLOWED_TARGET_ENVS %v", targetEnv, cfg.AllowedTargetEnvs)
+		sendError(w, r, errTargetEnvNotAllowed, fmt.Sprintf("targetEnvironment %q is not allowed on this server", targetEnv), http.StatusBadRequest)
+		return
+	}
+
+	if req.Product != "" && !knownProduct(req.Product) {
+		logger.Printf("ERROR: Unknown product %q", req.Product)
+		sendError(w, r, errInvalidRequest, fmt.Sprintf("product must be one of %s", strings.Join(momoProducts, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	if req.ReferenceID != "" {
+		if err := referenceIDFormat.Validate(req.ReferenceID); err != nil {
+			logger.Printf("ERROR: Invalid referenceId %q - %v", req.ReferenceID, err)
+			sendError(w, r, errInvalidRequest, fmt.Sprintf("referenceId %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var clientKey *rsa.PublicKey
+	if req.PublicKey != "" {
+		if clientKey, err = parseClientPublicKey(req.PublicKey); err != nil {
+			logger.Printf("ERROR: Invalid publicKey - %v", err)
+			sendError(w, r, errInvalidRequest, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	base64Format := req.Base64Format
+	if base64Format == "" {
+		base64Format = base64UserKey
+	}
+	if base64Format != base64UserKey && base64Format != base64SubscriptionKey {
+		logger.Printf("ERROR: Unknown base64Format %q", req.Base64Format)
+		sendError(w, r, errInvalidRequest, fmt.Sprintf("base64Format must be one of %s", strings.Join(base64Formats, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	// Forcing the fallback is a QA aid for the "generated locally" path, so dev mode only
+	if req.ForceFallback && !cfg.DevMode {
+		logger.Println("ERROR: forceFallback requested but DEV_MODE is not enabled")
+		sendError(w, r, errInvalidRequest, "forceFallback is only available when DEV_MODE is enabled", http.StatusBadRequest)
+		return
+	}
+	if req.ForceFallback && !fallbackAllowed(targetEnv) {
+		logger.Printf("ERROR: forceFallback requested but %s is not in FALLBACK_TARGET_ENVS", targetEnv)
+		sendError(w, r, errInvalidRequest, fmt.Sprintf("forceFallback is not available for targetEnvironment %q", targetEnv), http.StatusBadRequest)
+		return
+	}
+
+	// Credentials can be returned as a .env file instead of JSON
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "env" {
+		logger.Printf("ERROR: Unsupported response format %q", format)
+		sendError(w, r, errInvalidRequest, "Unsupported format, use json or env", http.StatusBadRequest)
+		return
+	}
+	if format == "env" && clientKey != nil {
+		logger.Println("ERROR: format=env requested with a publicKey")
+		sendError(w, r, errInvalidRequest, "format=env cannot be combined with publicKey, the .env file holds the plaintext key", http.StatusBadRequest)
+		return
+	}
+
+	// Debug output of the outbound MTN requests is only available in dev mode
+	debug := r.URL.Query().Get("debug") == "true"
+	if debug && !cfg.DevMode {
+		logger.Println("ERROR: debug=true requested but DEV_MODE is not enabled")
+		sendError(w, r, errInvalidRequest, "debug=true is only available when DEV_MODE is enabled", http.StatusBadRequest)
+		return
+	}
+	if req.IncludeRawResponse && !cfg.DevMode {
+		logger.Println("ERROR: includeRawResponse requested but DEV_MODE is not enabled")
+		sendError(w, r, errInvalidRequest, "includeRawResponse is only available when DEV_MODE is enabled", http.StatusBadRequest)
+		return
+	}
+	// End-to-end verification costs two extra MTN calls, so it is opt-in
+	verify := r.URL.Query().Get("verify") == "true"
+
+	ctx := r.Context()
+	var trace *outboundTrace
+	if debug {
+		logger.Println("DEBUG: Recording outbound MTN MoMo requests for this request")
+		ctx, trace = withOutboundTrace(ctx)
+	}
+	var rawResponses *rawResponseTrace
+	if req.IncludeRawResponse {
+		logger.Println("DEBUG: Capturing raw MTN MoMo responses for this request")
+		ctx, rawResponses = withRawResponses(ctx)
+	}
+
 	// Default callback host if not provided
 	callbackHost := req.CallbackHost
+	if callbackHost == "" && cfg.RequireCallbackHost {
+		logger.Println("ERROR: No callback host provided and REQUIRE_CALLBACK_HOST is enabled")
+		sendError(w, r, errInvalidCallbackHost, "callbackHost (or callbackUrl) is required", http.StatusBadRequest)
+		return
+	}
 	if callbackHost == "" {
-		log.Println("INFO: No callback host provided, using default: example.com")
-		callbackHost = "example.com"
+		logger.Printf("INFO: No callback host provided, using default: %s", defaultCallbackHost)
+		callbackHost = defaultCallbackHost
 	} else {
-		log.Printf("INFO: Using provided callback host: %s", callbackHost)
+		logger.Printf("INFO: Using provided callback host: %s", callbackHost)
+	}
+
+	// Every further log line of this request, MTN calls included, carries these fields
+	logger = logger.With("callback_host", callbackHost).With("target_env", targetEnv)
+	if req.Product != "" {
+		logger = logger.With("product", req.Product)
+	}
+	ctx = withRequestLogger(ctx, logger)
+
+	requestCapture.Record(CapturedRequest{
+		Timestamp:         time.Now().UTC(),
+		CallbackHost:      callbackHost,
+		Product:           req.Product,
+		TargetEnvironment: targetEnv,
+		KeyCount:          keyCount,
+	})
+
+	// Coalesce concurrent requests sharing an Idempotency-Key, and (with GENERATE_DEDUP_WINDOW)
+	// accidental rapid duplicates for the same callback host, target environment,
+	// subscription key and response options, into one MTN user: the first request's
+	// response is replayed to the others
+	var dedupKey string
+	shape := dedupResponseShape(req.PublicKey, keyCount, format, base64Format)
+	if idempotencyKey := r.Header.Get(idempotencyKeyHeader); idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			logger.Printf("ERROR: %s header is %d characters, over the limit of %d", idempotencyKeyHeader, len(idempotencyKey), maxIdempotencyKeyLength)
+			sendError(w, r, errInvalidRequest, fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+		dedupKey = idempotencyDedupKey(idempotencyKey, subscriptionKey, shape)
+	} else if cfg.GenerateDedupWindow > 0 {
+		dedupKey = generateDedupKey(callbackHost, targetEnv, subscriptionKey, shape)
+	}
+	if dedupKey != "" {
+		entry, leader := generateDedup.begin(dedupKey)
+		if !leader {
+			logger.Printf("INFO: Duplicate request for callback host %s, returning the first request's result", callbackHost)
+			select {
+			case <-entry.done:
+				entry.replay(w)
+			case <-ctx.Done():
+				logger.Println("WARNING: Client went away while waiting for a duplicate request to complete")
+			}
+			return
+		}
+		rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				generateDedup.fail(dedupKey, entry, r)
+				panic(p)
+			}
+			generateDedup.finish(entry, rec, cfg.GenerateDedupWindow)
+		}()
+		w = rec
 	}
 
 	// Variables to store our API credentials
 	var apiUser, apiKey string
-	var useRealAPI bool = true
+	var apiKeys []string
+	var useRealAPI bool = !req.ForceFallback
+	var attempts CallAttempts
+	var userCreateTime, keyCreateTime time.Duration
+	var fallbackReason string
+	var mtnErr error
+	generateStart := time.Now()
+
+	if req.ForceFallback {
+		logger.Println("=== FORCED FALLBACK REQUESTED (DEV_MODE) - SKIPPING MTN MOMO API ===")
+		fallbackReason = fallbackForced
+	}
+
+	// User and key creation share one retry and time budget, so the whole operation
+	// fails fast once it is spent instead of each step retrying independently
+	mtnCtx := ctx
+	if cfg.GenerateTimeBudget > 0 {
+		var cancel context.CancelFunc
+		mtnCtx, cancel = context.WithTimeout(ctx, cfg.GenerateTimeBudget)
+		defer cancel()
+	}
+	if cfg.GenerateRetryBudget > 0 {
+		mtnCtx = withRetryBudget(mtnCtx, cfg.GenerateRetryBudget)
+	}
 
-	log.Println("=== ATTEMPTING REAL MTN MOMO API INTEGRATION ===")
 	if useRealAPI {
+		logger.Println("=== ATTEMPTING REAL MTN MOMO API INTEGRATION ===")
 		// Try to use the real MTN MoMo API
-		log.Println("STEP 1/2: Creating API User through MTN MoMo API...")
+		logger.Println("STEP 1/2: Creating API User through MTN MoMo API...")
 
 		// Step 1: Create API User through MTN MoMo API
-		apiUserResult, err := createAPIUser(req.PrimaryKey, callbackHost)
+		start := time.Now()
+		apiUserResult, userAttempts, err := createAPIUser(mtnCtx, subscriptionKey, callbackHost, req.ReferenceID)
+		attempts.UserCreate = userAttempts
+		observeMomoCall("create_user", start, err)
+		userCreateTime = time.Since(start)
 		if err != nil {
-			log.Printf("ERROR: Failed to create API User via MTN MoMo API - %v", err)
-			log.Println("FALLBACK: Will use local generation instead")
+			logger.Printf("ERROR: Failed to create API User via MTN MoMo API - %v", err)
+			logger.Println("FALLBACK: Will use local generation instead")
 			useRealAPI = false
+			fallbackReason = mtnErrorCode(err)
+			mtnErr = err
 		} else {
 			apiUser = apiUserResult
-			log.Printf("SUCCESS: API User created and registered with MTN MoMo: %s", apiUser)
-
-			// Step 2: Create API Key through MTN MoMo API
-			log.Println("STEP 2/2: Creating API Key through MTN MoMo API...")
-			apiKeyResult, err := createAPIKey(req.PrimaryKey, apiUser)
-			if err != nil {
-				log.Printf("ERROR: Failed to create API Key via MTN MoMo API - %v", err)
-				log.Println("FALLBACK: Will use local generation instead")
-				useRealAPI = false
-			} else {
-				apiKey = apiKeyResult
-				log.Printf("SUCCESS: API Key created and registered with MTN MoMo for user %s", apiUser)
-				log.Println("=== MTN MOMO API INTEGRATION SUCCESSFUL ===")
+			logger.Printf("SUCCESS: API User created and registered with MTN MoMo: %s", apiUser)
+
+			// Step 2: Create API Key(s) through MTN MoMo API
+			logger.Println("STEP 2/2: Creating API Key through MTN MoMo API...")
+			for i := 1; i <= keyCount; i++ {
+				start = time.Now()
+				var apiKeyResult string
+				create := func() error {
+					var keyAttempts int
+					var err error
+					apiKeyResult, keyAttempts, err = createAPIKey(mtnCtx, subscriptionKey, apiUser)
+					attempts.KeyCreate += keyAttempts
+					return err
+				}
+				var err error
+				if i == 1 {
+					// The user was created a moment ago and may not have propagated yet
+					err = withPropagationRetry(mtnCtx, "create API key", create)
+				} else {
+					err = create()
+				}
+				observeMomoCall("create_key", start, err)
+				keyCreateTime += time.Since(start)
+				if err != nil && i > 1 {
+					// The earlier key is still usable, so don't discard the registered user
+					logger.Printf("WARNING: Failed to create API Key %d/%d via MTN MoMo API, returning %d key(s) - %v", i, keyCount, len(apiKeys), err)
+					mtnErr = err
+					break
+				}
+				if err != nil {
+					logger.Printf("ERROR: Failed to create API Key via MTN MoMo API - %v", err)
+					logger.Println("FALLBACK: Will use local generation instead")
+					useRealAPI = false
+					fallbackReason = mtnErrorCode(err)
+					mtnErr = err
+					break
+				}
+				apiKeys = append(apiKeys, apiKeyResult)
+				logger.Printf("SUCCESS: API Key %d/%d created and registered with MTN MoMo for user %s (fingerprint %s)", i, keyCount, apiUser, keyFingerprint(apiKeyResult))
+			}
+			if useRealAPI {
+				logger.Println("=== MTN MOMO API INTEGRATION SUCCESSFUL ===")
 			}
 		}
 	}
 
+	// Local credentials are only acceptable where FALLBACK_TARGET_ENVS allows them
+	if !useRealAPI && !fallbackAllowed(targetEnv) {
+		logger.Printf("ERROR: MTN MoMo API failed and local fallback is not allowed for %s, failing the request", targetEnv)
+		sendError(w, r, fallbackReason, fmt.Sprintf("MTN MoMo API call failed and local fallback is disabled for targetEnvironment %q", targetEnv), http.StatusBadGateway)
+		return
+	}
+
 	// If real API failed, fall back to local generation
 	if !useRealAPI {
-		log.Println("=== USING LOCAL GENERATION (NOT REGISTERED WITH MTN MOMO) ===")
-		log.Println("STEP 1/2: Generating API User locally...")
-		apiUser = fallbackGenerateAPIUser()
-		log.Printf("Generated API User locally: %s", apiUser)
+		logger.Println("=== USING LOCAL GENERATION (NOT REGISTERED WITH MTN MOMO) ===")
+		logger.Println("STEP 1/2: Generating API User locally...")
+		if req.ReferenceID != "" {
+			apiUser = req.ReferenceID
+			logger.Printf("Using client-supplied API User reference ID locally: %s", apiUser)
+		} else {
+			apiUser = generator.NewUserID()
+			logger.Printf("Generated API User locally: %s", apiUser)
+		}
+
+		logger.Println("STEP 2/2: Generating API Key locally...")
+		apiKeys = nil
+		for i := 0; i < keyCount; i++ {
+			apiKeys = append(apiKeys, generator.NewAPIKey())
+			logger.Printf("Generated API Key %d/%d locally (fingerprint %s)", i+1, keyCount, keyFingerprint(apiKeys[i]))
+		}
+		logger.Printf("Generated %d API Key(s) locally for user %s", keyCount, apiUser)
+		logger.Println("=== LOCAL GENERATION COMPLETE ===")
+		logger.Println("WARNING: These credentials are NOT registered with MTN MoMo and cannot be used for API calls")
 
-		log.Println("STEP 2/2: Generating API Key locally...")
-		apiKey = fallbackGenerateAPIKey()
-		log.Printf("Generated API Key locally for user %s", apiUser)
-		log.Println("=== LOCAL GENERATION COMPLETE ===")
-		log.Println("WARNING: These credentials are NOT registered with MTN MoMo and cannot be used for API calls")
+		// A forced fallback says nothing about MTN, so only real failures alert operators
+		if fallbackReason != fallbackForced {
+			alertFallbackUsed(logger, fallbackReason, callbackHost, targetEnv)
+		}
+	}
+
+	// The most recently created key is the one MTN keeps active
+	apiKey = apiKeys[len(apiKeys)-1]
+
+	source := sourceLocal
+	if useRealAPI {
+		source = sourceMTN
 	}
 
-	// Create response following MTN MoMo API structure
+	// Create response following MTN MoMo API structure.
+	// DateTime is always UTC so it lines up with MTN's own (UTC) timestamps, written
+	// in the DATETIME_FORMAT preset.
+	now := time.Now()
 	resp := MomoKeyResponse{
 		APIKey:       apiKey,
 		APIUser:      apiUser,
 		UserID:       apiUser, // In MTN MoMo, the API User is the same as the User ID (X-Reference-Id)
 		CallbackHost: callbackHost,
-		DateTime:     time.Now().Format(time.RFC3339),
-		TargetEnv:    "sandbox", // Always sandbox in this simulator
+		DateTime:     formatDateTime(now),
+		TargetEnv:    targetEnv,
+		Attempts:     attempts,
 	}
+	resp.Source = source
+	resp.FallbackReason = fallbackReason
+	resp.Warnings = warnings
+	if trace != nil {
+		resp.Debug = &DebugInfo{OutboundRequests: trace.Requests()}
+	}
+	if rawResponses != nil {
+		resp.RawResponses = rawResponses.Responses()
+	}
+	if cfg.ServerTimezone != nil {
+		resp.ServerTime = now.In(cfg.ServerTimezone).Format(time.RFC3339)
+	}
+	if cfg.IncludeSubscriptionKeySuffix {
+		resp.SubscriptionKeySuffix = subscriptionKeySuffix(subscriptionKey)
+	}
+	if verify {
+		applyVerification(ctx, &resp, subscriptionKey, req.Product)
+	}
+
+	// Keep a local copy of the credentials so operators can manage them later
+	generateRequestsTotal.WithLabelValues(source).Inc()
+	diagnostics.Record(generateOutcome{At: now, Source: source, Latency: time.Since(generateStart), Err: mtnErr})
+	record := CredentialRecord{
+		UserID:       apiUser,
+		CallbackHost: callbackHost,
+		TargetEnv:    resp.TargetEnv,
+		Source:       source,
+		CreatedAt:    now,
+	}
+	record.KeyFingerprint = keyFingerprint(apiKey)
+	if err := store.Save(record, apiKey); err != nil {
+		logger.Printf("ERROR: Failed to store credential record for user %s: %v", apiUser, err)
+	} else {
+		logger.Printf("Stored local credential record for user %s (key fingerprint %s)", apiUser, record.KeyFingerprint)
+		w.Header().Set("Location", routePath("/api/credentials/"+apiUser))
+	}
+	publishEvent(CredentialsEvent{
+		Type:              eventCredentialsGenerated,
+		UserID:            apiUser,
+		CallbackHost:      callbackHost,
+		TargetEnvironment: targetEnv,
+		Source:            source,
+		Timestamp:         now.UTC(),
+	})
 
 	// Generate Base64 auth string and test curl command for the user
-	// Create the auth string (apiUser:apiKey) and encode it in base64
-	authString := fmt.Sprintf("%s:%s", apiUser, apiKey)
-	base64Auth := base64.StdEncoding.EncodeToString([]byte(authString))
+	// Encode the auth string (apiUser:apiKey unless another base64Format was asked for) in base64
+	base64Auth := composeBase64Auth(base64Format, apiUser, apiKey, subscriptionKey)
 
 	// Add the Base64 auth string to the response
 	resp.Base64Auth = base64Auth
+	resp.Base64Format = base64Format
+
+	// List every key when more than one was requested
+	if keyCount > 1 {
+		for i, key := range apiKeys {
+			resp.Keys = append(resp.Keys, IssuedKey{
+				APIKey:     key,
+				Base64Auth: composeBase64Auth(base64Format, apiUser, key, subscriptionKey),
+				Active:     i == len(apiKeys)-1,
+			})
+		}
+	}
+
+	// Optionally add a scannable QR code of the auth string for mobile testing
+	if req.IncludeQR {
+		qrCode, err := qrCodeDataURI(base64Auth)
+		if err != nil {
+			logger.Printf("ERROR: Failed to generate QR code: %v", err)
+		} else {
+			resp.QRCode = qrCode
+			logger.Println("Generated QR code of the base64 auth string")
+		}
+	}
 
 	// Generate the curl command if using real API
 	if useRealAPI {
-		// Generate the curl command
-		testCommand := fmt.Sprintf("\nTest your credentials with this curl command:\n\ncurl --location --request POST 'https://sandbox.momodeveloper.mtn.com/collection/token/' \\\n--header 'Authorization: Basic %s' \\\n--header 'Ocp-Apim-Subscription-Key: %s' \\\n--header 'Content-Type: application/json'\n", base64Auth, req.PrimaryKey)
+		// Generate the curl command; MTN only accepts user:key, whatever base64Format was asked for
+		mtnAuth := composeBase64Auth(base64UserKey, apiUser, apiKey, subscriptionKey)
+		testCommand := fmt.Sprintf("\nTest your credentials with this curl command:\n\ncurl --location --request POST '%s' \\\n--header 'Authorization: Basic %s' \\\n--header 'Ocp-Apim-Subscription-Key: %s' \\\n--header 'Content-Type: application/json'\n", tokenURL(), mtnAuth, subscriptionKey)
 
-		log.Println("Generated test curl command for the user")
-		log.Println(testCommand)
+		logger.Println("Generated test curl command for the user")
+		// The command embeds the base64 auth and the subscription key, so it is only logged on request
+		if cfg.LogTestCommand {
+			logger.Println(testCommand)
+		}
 
 		// Add the test command to the response
 		resp.TestCommand = testCommand
 	}
 
+	// Sandbox users moving to production need to see what changes: host, credentials and target environment
+	if req.IncludeProductionHint && targetEnv == defaultTargetEnv {
+		resp.ProductionTestCommand = productionTestCommand()
+		logger.Println("Generated production migration curl command for the user")
+	}
+
+	// Encrypt last: verification and the key lists above need the plaintext key
+	if clientKey != nil {
+		if err := encryptResponseKeys(&resp, clientKey); err != nil {
+			logger.Printf("ERROR: Failed to encrypt the API key to the client publicKey: %v", err)
+			sendError(w, r, errInternal, "Failed to encrypt the API key", http.StatusInternalServerError)
+			return
+		}
+		logger.Printf("Encrypted the API key to the client publicKey (%s)", encryptionAlgorithm)
+	}
+
+	if req.IncludeTimings {
+		resp.Timings = &Timings{
+			UserCreateMs: userCreateTime.Milliseconds(),
+			KeyCreateMs:  keyCreateTime.Milliseconds(),
+			TotalMs:      time.Since(handlerStart).Milliseconds(),
+		}
+		logger.Printf("Timings: user create %dms, key create %dms, total %dms", resp.Timings.UserCreateMs, resp.Timings.KeyCreateMs, resp.Timings.TotalMs)
+	}
+
+	if format == "env" {
+		logger.Println("Sending credentials as a .env file")
+		writeEnvFile(w, resp, subscriptionKey)
+		logger.Println("=== API Key Generation Request Completed ===")
+		return
+	}
+
 	if useRealAPI {
-		log.Println("Sending response with MTN MoMo registered credentials")
-		sendResponse(w, true, "API User and API Key successfully created and registered with MTN MoMo", resp, http.StatusCreated)
+		logger.Println("Sending response with MTN MoMo registered credentials")
+		sendResponse(w, r, true, "API User and API Key successfully created and registered with MTN MoMo", generateResponseData(resp), cfg.SuccessStatusCode)
 	} else {
-		log.Println("Sending response with locally generated credentials")
-		sendResponse(w, true, "API User and API Key generated locally (not registered with MTN MoMo)", resp, http.StatusCreated)
+		logger.Println("Sending response with locally generated credentials")
+		sendResponse(w, r, true, "API User and API Key generated locally (not registered with MTN MoMo)", generateResponseData(resp), cfg.SuccessStatusCode)
+	}
+
+	logger.Println("=== API Key Generation Request Completed ===")
+}
+
+// productionTestCommand is the production counterpart of the sandbox test command.
+// It only holds placeholders: production credentials are not issued by this server.
+func productionTestCommand() string {
+	return fmt.Sprintf("\nIn production, the same call uses the production host, your production credentials and your market's target environment:\n\ncurl --location --request POST '%s/collection/token/' \\\n--header 'Authorization: Basic <base64 of PRODUCTION_API_USER:PRODUCTION_API_KEY>' \\\n--header 'Ocp-Apim-Subscription-Key: <PRODUCTION_SUBSCRIPTION_KEY>' \\\n--header 'X-Target-Environment: <TARGET_ENVIRONMENT, e.g. mtnghana>' \\\n--header 'Content-Type: application/json'\n", productionBaseURL)
+}
+
+// writeEnvFile writes generated credentials as a downloadable .env file
+func writeEnvFile(w http.ResponseWriter, resp MomoKeyResponse, subscriptionKey string) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# MTN MoMo credentials (%s), generated %s\n", resp.Source, resp.DateTime)
+	fmt.Fprintf(&buf, "MOMO_API_USER=%s\n", resp.APIUser)
+	fmt.Fprintf(&buf, "MOMO_API_KEY=%s\n", resp.APIKey)
+	fmt.Fprintf(&buf, "MOMO_SUBSCRIPTION_KEY=%s\n", subscriptionKey)
+	fmt.Fprintf(&buf, "MOMO_BASE64_AUTH=%s\n", resp.Base64Auth)
+	fmt.Fprintf(&buf, "MOMO_TARGET_ENVIRONMENT=%s\n", resp.TargetEnv)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="momo.env"`)
+	setSignature(w, buf.Bytes())
+	w.WriteHeader(cfg.SuccessStatusCode)
+	w.Write(buf.Bytes())
+}
+
+// CredentialResponse is a stored credential record as returned to clients
+type CredentialResponse struct {
+	CredentialRecord
+	APIKey    string `json:"apiKey"`
+	KeyMasked bool   `json:"keyMasked"`
+}
+
+// handleGetCredential returns a stored credential record with its API key masked:
+// the full key is only returned by /api/generate and the admin reveal endpoint.
+func handleGetCredential(w http.ResponseWriter, r *http.Request) {
+	logger := reqLog(r.Context())
+	userID := mux.Vars(r)["userId"]
+	logger.Printf("=== Credential Read Request Received for user %s ===", userID)
+
+	rec, ok := store.Get(userID)
+	if !ok {
+		logger.Printf("No stored credential record found for user %s", userID)
+		sendError(w, r, errNotFound, "Credential record not found", http.StatusNotFound)
+		return
+	}
+
+	// Let polling clients skip the body when the record has not changed
+	etag := rec.ETag()
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		logger.Printf("Credential record for user %s unchanged (ETag %s), returning 304", userID, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	maskedKey, err := store.MaskedAPIKey(rec)
+	if err != nil {
+		logger.Printf("ERROR: Failed to decrypt stored API key for user %s: %v", userID, err)
+		sendError(w, r, errInternal, "Failed to read stored credential record", http.StatusInternalServerError)
+		return
 	}
 
-	log.Println("=== API Key Generation Request Completed ===")
+	resp := CredentialResponse{CredentialRecord: rec, APIKey: maskedKey, KeyMasked: true}
+	sendResponse(w, r, true, "Credential record found", resp, http.StatusOK)
 }
 
-// sendResponse sends a standardized JSON response
-func sendResponse(w http.ResponseWriter, success bool, message string, data interface{}, statusCode int) {
-	resp := Response{
+// etagMatches reports whether an If-None-Match header value matches the given ETag.
+// Weak comparison is used, as is standard for If-None-Match.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRevealCredential explicitly returns the full stored API key for a user.
+// It must be guarded by requireAdminToken.
+func handleRevealCredential(w http.ResponseWriter, r *http.Request) {
+	logger := reqLog(r.Context())
+	userID := mux.Vars(r)["userId"]
+	logger.Printf("=== Credential Reveal Request Received for user %s ===", userID)
+
+	rec, ok := store.Get(userID)
+	if !ok {
+		logger.Printf("No stored credential record found for user %s", userID)
+		sendError(w, r, errNotFound, "Credential record not found", http.StatusNotFound)
+		return
+	}
+
+	apiKey, err := store.APIKey(rec)
+	if err != nil {
+		logger.Printf("ERROR: Failed to decrypt stored API key for user %s: %v", userID, err)
+		sendError(w, r, errInternal, "Failed to read stored credential record", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("WARNING: Full API key revealed for user %s via admin endpoint", userID)
+	sendResponse(w, r, true, "Credential record revealed", CredentialResponse{CredentialRecord: rec, APIKey: apiKey}, http.StatusOK)
+}
+
+// handleDeleteCredential removes the locally stored credential record for a user.
+// This only purges our local copy (including the encrypted API key); the API user
+// is NOT deleted from MTN MoMo and its credentials remain valid there.
+// It must be guarded by requireAdminToken.
+func handleDeleteCredential(w http.ResponseWriter, r *http.Request) {
+	logger := reqLog(r.Context())
+	userID := mux.Vars(r)["userId"]
+	logger.Printf("=== Credential Deletion Request Received for user %s ===", userID)
+
+	if !store.Delete(userID) {
+		logger.Printf("No stored credential record found for user %s", userID)
+		sendError(w, r, errNotFound, "Credential record not found", http.StatusNotFound)
+		return
+	}
+
+	logger.Printf("Deleted local credential record for user %s (MTN MoMo user is unaffected)", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendResponse sends a standardized JSON response.
+// Successful responses are sent unwrapped (just the Data object) when the client opts
+// out of the envelope; error responses always use the Response structure.
+func sendResponse(w http.ResponseWriter, r *http.Request, success bool, message string, data interface{}, statusCode int) {
+	writeResponse(w, r, Response{
 		Success: success,
 		Message: message,
-		Data:    data,
+		Data:    responseTransformer.Transform(r, data),
+	}, statusCode)
+}
+
+// sendError sends a failure response carrying a machine-readable error code. The
+// message is localized for the client's Accept-Language (see localizeError).
+func sendError(w http.ResponseWriter, r *http.Request, code string, message string, statusCode int) {
+	writeResponse(w, r, Response{
+		Success:   false,
+		Message:   localizeError(w, r, code, message),
+		ErrorCode: code,
+	}, statusCode)
+}
+
+// writeResponse encodes a Response, honoring the client's envelope preference.
+// Server errors are rendered as an HTML page for browsers (see prefersHTML).
+func writeResponse(w http.ResponseWriter, r *http.Request, resp Response, statusCode int) {
+	if statusCode >= http.StatusInternalServerError && prefersHTML(r) {
+		writeHTMLError(w, resp, statusCode)
+		return
+	}
+	var body interface{} = resp
+	if resp.Success && resp.Data != nil && !wantsEnvelope(r) {
+		body = resp.Data
+	}
+
+	// Encoded up front so the exact bytes sent can be signed
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		log.Printf("Error encoding response: %v", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	setSignature(w, buf.Bytes())
 	w.WriteHeader(statusCode)
+	w.Write(buf.Bytes())
+}
 
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		log.Printf("Error encoding response: %v", err)
+// wantsEnvelope reports whether the client wants responses wrapped in the Response
+// envelope. Clients opt out with ?envelope=false or an X-No-Envelope: true header.
+func wantsEnvelope(r *http.Request) bool {
+	if v := r.URL.Query().Get("envelope"); v != "" {
+		if envelope, err := strconv.ParseBool(v); err == nil {
+			return envelope
+		}
+	}
+	if noEnvelope, err := strconv.ParseBool(r.Header.Get("X-No-Envelope")); err == nil && noEnvelope {
+		return false
 	}
+	return true
 }
 
 // setupLogger configures a more detailed logger
 func setupLogger() {
 	// Set log format to include timestamp
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	setLogOutput(os.Stderr)
 	log.Println("Logger initialized with timestamp and file information")
 }
 
+// routePath returns the externally visible path of a route, including API_BASE_PATH
+func routePath(path string) string {
+	return cfg.APIBasePath + path
+}
+
+// newRouter registers all routes, under API_BASE_PATH when one is configured
+func newRouter(c *Config) *mux.Router {
+	root := mux.NewRouter()
+	r := root
+	if c.APIBasePath != "" {
+		r = root.PathPrefix(c.APIBasePath).Subrouter()
+		log.Printf("All routes are served under base path %s", c.APIBasePath)
+	}
+
+	// Define API routes. Slow routes (those calling MTN) get GENERATE_TIMEOUT, others
+	// ROUTE_TIMEOUT; streaming routes use withStreamTimeout or none, since TimeoutHandler buffers responses.
+	r.Handle("/healthz", withTimeout(handleHealthz, healthTimeout)).Methods("GET", "HEAD")
+	log.Printf("Health route registered: GET/HEAD %s", routePath("/healthz"))
+	r.Handle("/version", withTimeout(handleVersion, healthTimeout)).Methods("GET", "HEAD")
+	log.Printf("Version route registered: GET/HEAD %s", routePath("/version"))
+	r.Handle("/api/capabilities", withTimeout(handleCapabilities, c.RouteTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s", routePath("/api/capabilities"))
+	r.Handle("/api/markets", withTimeout(handleMarkets, c.RouteTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s", routePath("/api/markets"))
+	r.Handle("/api/validation-rules", withTimeout(handleValidationRules, c.RouteTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s", routePath("/api/validation-rules"))
+	r.Handle("/api/generate", withTimeout(withMaintenance(withRateLimit(generateLimiter, handleGenerateKeys)), c.GenerateTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s", routePath("/api/generate"))
+	// Not wrapped in withTimeout: each batch item gets its own GENERATE_TIMEOUT deadline instead
+	r.HandleFunc("/api/generate/batch", withMaintenance(generateBatchHandler(generateLimiter))).Methods("POST")
+	log.Printf("API route registered: POST %s", routePath("/api/generate/batch"))
+	r.Handle("/api/subscriptions/validate", withTimeout(handleValidateSubscriptions, c.GenerateTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s", routePath("/api/subscriptions/validate"))
+	r.Handle("/api/subscription/info", withTimeout(handleSubscriptionInfo, c.GenerateTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s", routePath("/api/subscription/info"))
+	r.Handle("/api/base64/decode", withTimeout(handleBase64Decode, c.RouteTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s", routePath("/api/base64/decode"))
+	r.Handle("/api/postman", withTimeout(handlePostmanCollection, c.RouteTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s", routePath("/api/postman"))
+	// Registered before /api/credentials/{userId} so "export" is not taken as a user ID
+	r.Handle("/api/credentials/export", withStreamTimeout(requireAdminToken(handleExportCredentials), c.RouteTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s (admin token required)", routePath("/api/credentials/export"))
+	r.Handle("/api/credentials/{userId}", withTimeout(handleGetCredential, c.RouteTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s", routePath("/api/credentials/{userId}"))
+	r.Handle("/api/credentials/{userId}", withTimeout(requireAdminToken(handleDeleteCredential), c.RouteTimeout)).Methods("DELETE")
+	log.Printf("API route registered: DELETE %s (admin token required)", routePath("/api/credentials/{userId}"))
+	r.Handle("/api/credentials/{userId}/reveal", withTimeout(requireAdminToken(handleRevealCredential), c.RouteTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s (admin token required)", routePath("/api/credentials/{userId}/reveal"))
+	r.Handle("/api/admin/maintenance", withTimeout(requireAdminToken(handleMaintenance), c.RouteTimeout)).Methods("GET", "POST")
+	log.Printf("API route registered: GET/POST %s (admin token required)", routePath("/api/admin/maintenance"))
+	r.Handle("/api/admin/reload", withTimeout(requireAdminToken(handleReload), c.RouteTimeout)).Methods("POST")
+	log.Printf("API route registered: POST %s (admin token required)", routePath("/api/admin/reload"))
+	// Not wrapped in withTimeout: the stream lasts as long as the client stays connected
+	r.HandleFunc("/api/admin/logs/stream", requireAdminToken(handleLogStream)).Methods("GET")
+	log.Printf("API route registered: GET %s (WebSocket, admin token required)", routePath("/api/admin/logs/stream"))
+	r.Handle("/api/key/{userId}", withTimeout(handleGetKey, c.GenerateTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s", routePath("/api/key/{userId}"))
+	r.Handle("/api/diagnostics", withTimeout(handleDiagnostics, c.RouteTimeout)).Methods("GET")
+	log.Printf("API route registered: GET %s", routePath("/api/diagnostics"))
+	r.Handle("/metrics", metricsHandler()).Methods("GET")
+	log.Printf("Metrics route registered: GET %s", routePath("/metrics"))
+
+	return root
+}
+
+// newHandler builds the full handler chain (routes, CORS, debug and access logging)
+// from c. /api/admin/reload builds a new one and swaps it in.
+func newHandler(c *Config) http.Handler {
+	var r http.Handler = recoverPanic(trimTrailingSlash(newRouter(c)))
+	if c.DebugHTTP {
+		r = debugHTTPMiddleware(r)
+		log.Println("HTTP body logging middleware enabled")
+	}
+	r = withMaxDuration(r, c.MaxRequestDuration)
+	r = withBodyReadTimeout(r, c.BodyReadTimeout, c.MaxBodyBytes)
+	if c.RequireHTTPS {
+		r = withRequireHTTPS(r, c.TrustedProxies)
+	}
+	r = withRequestID(r)
+
+	// Add CORS middleware
+	cm := cors.New(cors.Options{
+		AllowedOrigins:   c.CORSAllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-No-Envelope", "If-None-Match", idempotencyKeyHeader, requestIDHeader},
+		ExposedHeaders:   []string{"ETag", "Location", "Content-Disposition", signatureHeader, "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", requestIDHeader},
+		AllowCredentials: true,
+	})
+	handler := cm.Handler(r)
+	log.Printf("CORS middleware configured to allow requests from %s", strings.Join(c.CORSAllowedOrigins, ", "))
+	if c.AccessLogFormat != "" {
+		handler = accessLogMiddleware(c.AccessLogFormat, handler)
+		log.Printf("Access log enabled (%s format, written to stdout)", c.AccessLogFormat)
+	}
+	return handler
+}
+
+// trimTrailingSlash routes /api/generate/ the same as /api/generate. This is done by
+// rewriting the path rather than with mux's StrictSlash, whose redirect would make
+// clients retry a POST as a GET.
+func trimTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = strings.TrimRight(r.URL.RawPath, "/")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func main() {
 	// Setup enhanced logging
 	setupLogger()
+
+	// "replay <capture file>" re-issues captured generations instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	log.Println("=== MTN MoMo API Key Generator Backend Starting ===")
 	log.Println("This backend will attempt to register credentials with MTN MoMo API")
 	log.Println("If MTN MoMo API is unavailable, it will fall back to local generation")
 
-	r := mux.NewRouter()
+	// Load configuration from the environment
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.LogOutput != "" {
+		if err := openLogOutput(cfg.LogOutput); err != nil {
+			log.Fatalf("Failed to open LOG_OUTPUT %s: %v", cfg.LogOutput, err)
+		}
+	}
+	logConfig(cfg)
+	if cfg.RequestCapture != "" {
+		if requestCapture, err = openRequestCapture(cfg.RequestCapture); err != nil {
+			log.Fatalf("Failed to open REQUEST_CAPTURE %s: %v", cfg.RequestCapture, err)
+		}
+	}
+	responseTransformer = responseTransformers[cfg.ResponseTransformer]
+	subscriptionKeyProvider = newSecretProvider(cfg)
+	eventPublisher = eventPublishers[cfg.EventPublisher]
+	referenceIDFormat = referenceIDFormats[cfg.ReferenceIDFormat]
+	initMomoSemaphore(cfg.MaxConcurrency)
+	maintenanceMode.Store(cfg.MaintenanceMode)
+	if cfg.RateLimitPerMinute > 0 {
+		generateLimiter = newRateLimiter(cfg.RateLimitPerMinute)
+	}
+	initMetrics(cfg.MetricsLatencyBuckets)
 
-	// Define API routes
-	r.HandleFunc("/api/generate", handleGenerateKeys).Methods("POST")
-	log.Println("API route registered: POST /api/generate")
+	// Initialize the local credential store
+	store, err = newCredentialStore(cfg.StoreMaxRecords)
+	if err != nil {
+		log.Fatalf("Failed to initialize credential store: %v", err)
+	}
+	log.Println("In-memory credential store initialized")
+	initMomoHTTPClient(cfg)
+	log.Println("Outbound MTN MoMo client configured with a shared transport (HTTP/2 via ALPN when supported)")
 
-	// Add CORS middleware
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	})
-	handler := c.Handler(r)
-	log.Println("CORS middleware configured to allow requests from http://localhost:3000")
+	// Optionally hold off serving until MTN is reachable; this also warms the connection
+	if cfg.WaitForMTN {
+		log.Printf("Waiting up to %s for %s to become reachable...", cfg.WaitForMTNTimeout, momoBaseURL)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.WaitForMTNTimeout)
+		if err := waitForMTN(ctx); err != nil {
+			log.Printf("WARNING: %v; starting anyway, requests will fall back to local generation until MTN is reachable", err)
+		}
+		cancel()
+	}
+
+	// Optionally establish a pooled TLS connection to MTN before serving the first request
+	if cfg.Warmup && !cfg.WaitForMTN {
+		log.Printf("Warming up connection to %s...", momoBaseURL)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.MomoTimeout)
+		if err := warmUpMomoConnection(ctx); err != nil {
+			log.Printf("WARNING: MTN MoMo warm-up failed, continuing startup: %v", err)
+		} else {
+			log.Println("MTN MoMo connection warmed up")
+		}
+		cancel()
+	}
 
-	// Get port from environment variable or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	startup := cfg
+	liveCfg.Store(&startup)
+	liveHandler.Store(newHandler(&startup))
+
+	// Bound every phase of a connection so slow clients (slowloris) cannot hold it open
+	server := &http.Server{
+		Handler:      http.HandlerFunc(serveLive),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
+	server.RegisterOnShutdown(logStream.closeAll)
 
-	log.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, handler))
+	listener, err := newListener(cfg)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", listenAddress(cfg), err)
+	}
+
+	log.Printf("Server starting on %s...\n", listenAddress(cfg))
+	err = serve(server, listener)
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("ERROR: %v", err)
+	}
+
+	// The server has drained (or hit SHUTDOWN_TIMEOUT) by now; any handler still
+	// running has its late log writes dropped rather than failing on a closed file
+	if err := requestCapture.Close(); err != nil {
+		log.Printf("ERROR: Failed to close request capture %s: %v", cfg.RequestCapture, err)
+	}
+	closeLogOutput()
+	if err != nil && err != http.ErrServerClosed {
+		os.Exit(1)
+	}
 }
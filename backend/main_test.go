@@ -0,0 +1,1798 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testAdminToken is the ADMIN_API_TOKEN of tests that call admin endpoints
+const testAdminToken = "test-admin-token"
+
+// testSubscriptionKey is shaped like an MTN subscription key (32 hex characters)
+const testSubscriptionKey = "0123456789abcdef0123456789abcdef"
+
+func TestMain(m *testing.M) {
+	setLogOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// setupTest loads the configuration from the environment plus env and wires the
+// globals the way main does, returning the full handler chain. Retry backoffs are
+// skipped so tests against failing MTN stubs stay fast.
+func setupTest(t *testing.T, env map[string]string) http.Handler {
+	t.Helper()
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	cfg = c
+	responseTransformer = responseTransformers[cfg.ResponseTransformer]
+	subscriptionKeyProvider = newSecretProvider(cfg)
+	eventPublisher = eventPublishers[cfg.EventPublisher]
+	referenceIDFormat = referenceIDFormats[cfg.ReferenceIDFormat]
+	initMomoSemaphore(cfg.MaxConcurrency)
+	maintenanceMode.Store(cfg.MaintenanceMode)
+	generateLimiter = nil
+	if cfg.RateLimitPerMinute > 0 {
+		generateLimiter = newRateLimiter(cfg.RateLimitPerMinute)
+	}
+	initMetrics(cfg.MetricsLatencyBuckets)
+	if store, err = newCredentialStore(cfg.StoreMaxRecords); err != nil {
+		t.Fatalf("newCredentialStore: %v", err)
+	}
+	generateDedup = newDedupCache()
+	resetMomoHTTPClient()
+
+	sleep := retrySleep
+	retrySleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	t.Cleanup(func() { retrySleep = sleep })
+
+	live := cfg
+	liveCfg.Store(&live)
+	h := newHandler(&live)
+	liveHandler.Store(h)
+	return h
+}
+
+// setConfig applies mutate to cfg for the rest of the test, for unit tests of code
+// that reads cfg without going through the handler chain
+func setConfig(t *testing.T, mutate func(c *Config)) {
+	t.Helper()
+	saved := cfg
+	mutate(&cfg)
+	t.Cleanup(func() { cfg = saved })
+}
+
+// resetMomoHTTPClient lets the next MTN call build the shared client from cfg again
+func resetMomoHTTPClient() {
+	momoHTTPClientOnce = sync.Once{}
+	momoHTTPClient = nil
+}
+
+// fakeMTN points the MTN client at a test server running h for the rest of the test
+func fakeMTN(t *testing.T, h http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	base := momoBaseURL
+	momoBaseURL = srv.URL
+	t.Cleanup(func() {
+		momoBaseURL = base
+		srv.Close()
+	})
+	return srv
+}
+
+// mtnSuccess is an MTN stub that creates every user and answers every key request with apiKey
+func mtnSuccess(apiKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/apikey"):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"apiKey":%q}`, apiKey)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/apiuser"):
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+// mtnStatus is an MTN stub answering every request with status
+func mtnStatus(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+// doRequest sends a request through h; headers are given as name/value pairs
+func doRequest(h http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// testEnvelope is Response with Data left undecoded
+type testEnvelope struct {
+	Success   bool            `json:"success"`
+	Message   string          `json:"message"`
+	ErrorCode string          `json:"errorCode"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// decodeEnvelope decodes a response envelope, and its data into data when not nil
+func decodeEnvelope(t *testing.T, rec *httptest.ResponseRecorder, data interface{}) testEnvelope {
+	t.Helper()
+	var env testEnvelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	if data != nil {
+		if err := json.Unmarshal(env.Data, data); err != nil {
+			t.Fatalf("decoding response data %s: %v", env.Data, err)
+		}
+	}
+	return env
+}
+
+// generate runs a successful /api/generate request against the MTN stub already set
+// up, returning the response data
+func generate(t *testing.T, h http.Handler, body string) MomoKeyResponse {
+	t.Helper()
+	rec := doRequest(h, http.MethodPost, "/api/generate", body)
+	if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+		t.Fatalf("generate status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp MomoKeyResponse
+	decodeEnvelope(t, rec, &resp)
+	return resp
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs collects the standard logger's output for the rest of the test
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	var buf syncBuffer
+	setLogOutput(&buf)
+	t.Cleanup(func() { setLogOutput(io.Discard) })
+	return &buf
+}
+
+// waitForLog waits for a line containing substr to be logged, for handlers that keep
+// running after their response, such as ones abandoned by a route timeout
+func waitForLog(t *testing.T, logs *syncBuffer, substr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), substr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for log line %q", substr)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// saveTestRecord stores a credential record for userID holding apiKey
+func saveTestRecord(t *testing.T, userID, apiKey string) {
+	t.Helper()
+	rec := CredentialRecord{
+		UserID:         userID,
+		CallbackHost:   "example.com",
+		TargetEnv:      defaultTargetEnv,
+		Source:         sourceMTN,
+		CreatedAt:      time.Now(),
+		KeyFingerprint: keyFingerprint(apiKey),
+	}
+	if err := store.Save(rec, apiKey); err != nil {
+		t.Fatalf("store.Save: %v", err)
+	}
+}
+
+func TestDeleteCredential(t *testing.T) {
+	const userID = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d"
+	tests := []struct {
+		name       string
+		stored     bool
+		token      string
+		wantStatus int
+		wantStored bool
+	}{
+		{"present record", true, testAdminToken, http.StatusNoContent, false},
+		{"absent record", false, testAdminToken, http.StatusNotFound, false},
+		{"missing token", true, "", http.StatusUnauthorized, true},
+		{"wrong token", true, "not-the-token", http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+			if tt.stored {
+				saveTestRecord(t, userID, "a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			}
+
+			var headers []string
+			if tt.token != "" {
+				headers = []string{"Authorization", "Bearer " + tt.token}
+			}
+			rec := doRequest(h, http.MethodDelete, "/api/credentials/"+userID, "", headers...)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusNoContent && rec.Body.Len() != 0 {
+				t.Errorf("204 response has a body: %s", rec.Body)
+			}
+			if _, ok := store.Get(userID); ok != tt.wantStored {
+				t.Errorf("record stored after request = %t, want %t", ok, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestDeleteCredentialWithoutAdminToken(t *testing.T) {
+	const userID = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d"
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": ""})
+	saveTestRecord(t, userID, "a1b2c3d4e5f60718293a4b5c6d7e8f90")
+
+	rec := doRequest(h, http.MethodDelete, "/api/credentials/"+userID, "", "Authorization", "Bearer anything")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if _, ok := store.Get(userID); !ok {
+		t.Error("record was deleted although the admin endpoints are disabled")
+	}
+}
+
+func TestCallbackHostLengthLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     int
+		wantStatus int
+		wantCode   string
+	}{
+		{"at the limit", 20, http.StatusCreated, ""},
+		{"one over the limit", 21, http.StatusBadRequest, errInvalidCallbackHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"MAX_CALLBACK_HOST_LENGTH": "20"})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			host := strings.Repeat("a", tt.length-len(".com")) + ".com"
+			body := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":%q}`, testSubscriptionKey, host)
+			rec := doRequest(h, http.MethodPost, "/api/generate", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != tt.wantCode {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://example.com/momo/callback", false},
+		{"https://example.com", false},
+		{"http://example.com/momo/callback", true},
+		{"example.com/momo/callback", true},
+		{"/momo/callback", true},
+		{"https:///momo/callback", true},
+		{"://bad", true},
+	}
+	for _, tt := range tests {
+		if err := validateCallbackURL(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("validateCallbackURL(%q) = %v, want error %t", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateCallbackURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		fields       string
+		wantStatus   int
+		wantCallback string
+	}{
+		{"url only", `"callbackUrl":"https://example.com/momo/cb"`, http.StatusCreated, "https://example.com/momo/cb"},
+		{"url takes precedence", `"callbackUrl":"https://example.com/momo/cb","callbackHost":"example.org"`, http.StatusCreated, "https://example.com/momo/cb"},
+		{"host only", `"callbackHost":"example.org"`, http.StatusCreated, "example.org"},
+		{"plain http url", `"callbackUrl":"http://example.com/momo/cb"`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			var sent struct {
+				ProviderCallbackHost string `json:"providerCallbackHost"`
+			}
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/apiuser") {
+					json.NewDecoder(r.Body).Decode(&sent)
+				}
+				success(w, r)
+			}))
+
+			body := fmt.Sprintf(`{"primaryKey":%q,%s}`, testSubscriptionKey, tt.fields)
+			rec := doRequest(h, http.MethodPost, "/api/generate", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidCallbackHost {
+					t.Errorf("errorCode = %q, want %q", env.ErrorCode, errInvalidCallbackHost)
+				}
+				return
+			}
+			if sent.ProviderCallbackHost != tt.wantCallback {
+				t.Errorf("providerCallbackHost sent to MTN = %q, want %q", sent.ProviderCallbackHost, tt.wantCallback)
+			}
+		})
+	}
+}
+
+func TestCredentialReadMasksAPIKey(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess(apiKey))
+	gen := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if gen.APIKey != apiKey {
+		t.Fatalf("generate apiKey = %q, want the full key", gen.APIKey)
+	}
+
+	// Every read after the generate response is masked, the first one included
+	for i := 1; i <= 2; i++ {
+		rec := doRequest(h, http.MethodGet, "/api/credentials/"+gen.UserID, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("read %d: status = %d, body %s", i, rec.Code, rec.Body)
+		}
+		var resp CredentialResponse
+		decodeEnvelope(t, rec, &resp)
+		if !resp.KeyMasked || resp.APIKey != maskSecret(apiKey) {
+			t.Errorf("read %d: apiKey = %q, keyMasked = %t; want %q masked", i, resp.APIKey, resp.KeyMasked, maskSecret(apiKey))
+		}
+		if strings.Contains(rec.Body.String(), apiKey) {
+			t.Errorf("read %d: response contains the full API key", i)
+		}
+	}
+}
+
+func TestRevealCredential(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	const userID = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d"
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"admin token", testAdminToken, http.StatusOK},
+		{"no token", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+			saveTestRecord(t, userID, apiKey)
+
+			var headers []string
+			if tt.token != "" {
+				headers = []string{"Authorization", "Bearer " + tt.token}
+			}
+			rec := doRequest(h, http.MethodPost, "/api/credentials/"+userID+"/reveal", "", headers...)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if strings.Contains(rec.Body.String(), apiKey) {
+					t.Error("rejected reveal response contains the API key")
+				}
+				return
+			}
+			var resp CredentialResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.APIKey != apiKey || resp.KeyMasked {
+				t.Errorf("apiKey = %q, keyMasked = %t; want the full key", resp.APIKey, resp.KeyMasked)
+			}
+
+			// Revealing does not change what plain reads return
+			rec = doRequest(h, http.MethodGet, "/api/credentials/"+userID, "")
+			decodeEnvelope(t, rec, &resp)
+			if resp.APIKey != maskSecret(apiKey) {
+				t.Errorf("read after reveal: apiKey = %q, want it masked", resp.APIKey)
+			}
+		})
+	}
+}
+
+func TestResponseEnvelopeToggle(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		headers      []string
+		wantEnvelope bool
+	}{
+		{"default", "/api/markets", nil, true},
+		{"query opt-out", "/api/markets?envelope=false", nil, false},
+		{"query opt-in", "/api/markets?envelope=true", []string{"X-No-Envelope", "true"}, true},
+		{"header opt-out", "/api/markets", []string{"X-No-Envelope", "true"}, false},
+		{"header false", "/api/markets", []string{"X-No-Envelope", "false"}, true},
+		{"errors are always wrapped", "/api/credentials/absent?envelope=false", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			rec := doRequest(h, http.MethodGet, tt.path, "", tt.headers...)
+
+			var body interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			envelope, isObject := body.(map[string]interface{})
+			_, hasSuccess := envelope["success"]
+			if got := isObject && hasSuccess; got != tt.wantEnvelope {
+				t.Errorf("wrapped in envelope = %t, want %t (body %s)", got, tt.wantEnvelope, rec.Body)
+			}
+			if !tt.wantEnvelope {
+				if _, isList := body.([]interface{}); !isList {
+					t.Errorf("unwrapped body = %s, want the bare market list", rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestSameUserID(t *testing.T) {
+	const id = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d"
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"identical", id, id, true},
+		{"uppercase", id, strings.ToUpper(id), true},
+		{"hyphens stripped", id, strings.ReplaceAll(id, "-", ""), true},
+		{"uppercase and hyphens stripped", id, strings.ToUpper(strings.ReplaceAll(id, "-", "")), true},
+		{"braced", id, "{" + id + "}", true},
+		{"surrounding whitespace", id, " " + id + "\n", true},
+		{"different UUID", id, "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6e", false},
+		{"non-UUID case-insensitive", "user-a", "USER-A", true},
+		{"non-UUID different", "user-a", "user-b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameUserID(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameUserID(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAPIUserToleratesEchoedIDFormat(t *testing.T) {
+	const id = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d"
+	tests := []struct {
+		name    string
+		echoed  string
+		wantErr bool
+	}{
+		{"lowercase formatted", id, false},
+		{"uppercase unformatted", strings.ToUpper(strings.ReplaceAll(id, "-", "")), false},
+		{"not echoed", "", false},
+		{"another user", "00000000-0000-4000-8000-000000000000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, nil)
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(APIUserDetails{UserID: tt.echoed, ProviderCallbackHost: "example.com", TargetEnvironment: "sandbox"})
+			}))
+
+			details, err := getAPIUser(context.Background(), testSubscriptionKey, id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getAPIUser error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && details.ProviderCallbackHost != "example.com" {
+				t.Errorf("providerCallbackHost = %q, want example.com", details.ProviderCallbackHost)
+			}
+		})
+	}
+}
+
+func TestGenerateQRCodeIsValidPNG(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+	resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"includeQR":true}`, testSubscriptionKey))
+	encoded, ok := strings.CutPrefix(resp.QRCode, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("qrCode = %.40q..., want a PNG data URI", resp.QRCode)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decoding the data URI: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("decoding the PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Errorf("image is %dx%d, want 256x256", b.Dx(), b.Dy())
+	}
+
+	// Without includeQR no image is generated
+	if resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)); resp.QRCode != "" {
+		t.Error("qrCode set without includeQR")
+	}
+}
+
+func TestGenerateReportsAttempts(t *testing.T) {
+	tests := []struct {
+		name                      string
+		userFailures, keyFailures int
+	}{
+		{"first attempts succeed", 0, 0},
+		{"user retried", 2, 0},
+		{"both retried", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"MOMO_MAX_RETRIES": "3"})
+			var mu sync.Mutex
+			userCalls, keyCalls := 0, 0
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				var calls *int
+				var failures int
+				if strings.HasSuffix(r.URL.Path, "/apikey") {
+					calls, failures = &keyCalls, tt.keyFailures
+				} else {
+					calls, failures = &userCalls, tt.userFailures
+				}
+				*calls++
+				fail := *calls <= failures
+				mu.Unlock()
+				if fail {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				success(w, r)
+			}))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if resp.Source != sourceMTN {
+				t.Fatalf("source = %q, want %q", resp.Source, sourceMTN)
+			}
+			if resp.Attempts.UserCreate != userCalls || resp.Attempts.KeyCreate != keyCalls {
+				t.Errorf("attempts = %+v, want userCreate %d and keyCreate %d (responses consumed)", resp.Attempts, userCalls, keyCalls)
+			}
+			if userCalls != tt.userFailures+1 || keyCalls != tt.keyFailures+1 {
+				t.Errorf("stub served %d user and %d key responses, want %d and %d", userCalls, keyCalls, tt.userFailures+1, tt.keyFailures+1)
+			}
+		})
+	}
+}
+
+func TestCredentialReadETag(t *testing.T) {
+	const userID = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d"
+	h := setupTest(t, nil)
+	saveTestRecord(t, userID, "a1b2c3d4e5f60718293a4b5c6d7e8f90")
+
+	first := doRequest(h, http.MethodGet, "/api/credentials/"+userID, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first read: status %d, ETag %q; want 200 with an ETag", first.Code, etag)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"matching ETag", etag, http.StatusNotModified},
+		{"weak form of the ETag", "W/" + etag, http.StatusNotModified},
+		{"one of several", `"stale", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"stale ETag", `"stale"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doRequest(h, http.MethodGet, "/api/credentials/"+userID, "", "If-None-Match", tt.ifNoneMatch)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if tt.wantStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 response has a body: %s", rec.Body)
+			}
+		})
+	}
+
+	// A changed record gets a new ETag, so clients holding the old one see the change
+	store.Delete(userID)
+	saveTestRecord(t, userID, "0f1e2d3c4b5a69788796a5b4c3d2e1f0")
+	if rec := doRequest(h, http.MethodGet, "/api/credentials/"+userID, "", "If-None-Match", etag); rec.Code != http.StatusOK {
+		t.Errorf("read of a changed record with the old ETag: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestAPIBasePath(t *testing.T) {
+	h := setupTest(t, map[string]string{"API_BASE_PATH": "/momo"})
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodGet, "/momo/healthz", http.StatusOK},
+		{http.MethodGet, "/momo/api/markets", http.StatusOK},
+		{http.MethodPost, "/momo/api/generate", http.StatusCreated},
+		{http.MethodGet, "/api/markets", http.StatusNotFound},
+		{http.MethodGet, "/healthz", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			body := ""
+			if tt.method == http.MethodPost {
+				body = fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+			}
+			rec := doRequest(h, tt.method, tt.path, body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusCreated {
+				if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/momo/api/credentials/") {
+					t.Errorf("Location = %q, want it under the base path", loc)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateDateTimeIsUTC(t *testing.T) {
+	tests := []struct {
+		name           string
+		timezone       string
+		wantServerTime string // Suffix of serverTime; empty when it must be absent
+	}{
+		{"no server timezone", "", ""},
+		{"server timezone", "Africa/Lagos", "+01:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"SERVER_TIMEZONE": tt.timezone})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if !strings.HasSuffix(resp.DateTime, "Z") {
+				t.Errorf("dateTime = %q, want it to end in Z", resp.DateTime)
+			}
+			if _, err := time.Parse(time.RFC3339, resp.DateTime); err != nil {
+				t.Errorf("dateTime %q is not RFC 3339: %v", resp.DateTime, err)
+			}
+			if tt.wantServerTime == "" {
+				if resp.ServerTime != "" {
+					t.Errorf("serverTime = %q, want it absent", resp.ServerTime)
+				}
+				return
+			}
+			if !strings.HasSuffix(resp.ServerTime, tt.wantServerTime) {
+				t.Errorf("serverTime = %q, want offset %s", resp.ServerTime, tt.wantServerTime)
+			}
+		})
+	}
+}
+
+// stubGenerator returns fixed fallback credentials
+type stubGenerator struct{ userID, apiKey string }
+
+func (g stubGenerator) NewUserID() string { return g.userID }
+func (g stubGenerator) NewAPIKey() string { return g.apiKey }
+
+func TestFallbackUsesGenerator(t *testing.T) {
+	h := setupTest(t, map[string]string{"DEV_MODE": "true"})
+	saved := generator
+	generator = stubGenerator{userID: "gateway-user-0001", apiKey: "GW-KEY-0001"}
+	t.Cleanup(func() { generator = saved })
+
+	resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"forceFallback":true}`, testSubscriptionKey))
+	if resp.Source != sourceLocal {
+		t.Fatalf("source = %q, want %q", resp.Source, sourceLocal)
+	}
+	if resp.APIUser != "gateway-user-0001" || resp.APIKey != "GW-KEY-0001" {
+		t.Errorf("credentials = %s / %s, want the stub generator's", resp.APIUser, resp.APIKey)
+	}
+	wantAuth := base64.StdEncoding.EncodeToString([]byte("gateway-user-0001:GW-KEY-0001"))
+	if resp.Base64Auth != wantAuth {
+		t.Errorf("base64Auth = %q, want %q", resp.Base64Auth, wantAuth)
+	}
+}
+
+func TestDefaultGenerator(t *testing.T) {
+	setupTest(t, nil)
+	var g defaultGenerator
+	if _, err := uuid.Parse(g.NewUserID()); err != nil {
+		t.Errorf("NewUserID is not a UUID: %v", err)
+	}
+	a, b := g.NewAPIKey(), g.NewAPIKey()
+	if a == b {
+		t.Error("NewAPIKey returned the same key twice")
+	}
+}
+
+func TestGenerateKeyCount(t *testing.T) {
+	tests := []struct {
+		keyCount string
+		wantKeys int // Entries in keys; it is only listed for more than one key
+	}{
+		{"1", 0},
+		{"2", 2},
+	}
+	for _, tt := range tests {
+		t.Run("keyCount "+tt.keyCount, func(t *testing.T) {
+			h := setupTest(t, nil)
+			var mu sync.Mutex
+			issued := 0
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if strings.HasSuffix(r.URL.Path, "/apikey") {
+					issued++
+					mtnSuccess(fmt.Sprintf("key-%d", issued))(w, r)
+					return
+				}
+				mtnSuccess("")(w, r)
+			}))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"keyCount":%s}`, testSubscriptionKey, tt.keyCount))
+			wantIssued := max(tt.wantKeys, 1)
+			if issued != wantIssued || resp.Attempts.KeyCreate != wantIssued {
+				t.Errorf("MTN issued %d keys (attempts.keyCreate %d), want %d", issued, resp.Attempts.KeyCreate, wantIssued)
+			}
+			if latest := fmt.Sprintf("key-%d", wantIssued); resp.APIKey != latest {
+				t.Errorf("apiKey = %q, want the latest key %q", resp.APIKey, latest)
+			}
+			if len(resp.Keys) != tt.wantKeys {
+				t.Fatalf("keys = %+v, want %d entries", resp.Keys, tt.wantKeys)
+			}
+			for i, k := range resp.Keys {
+				if want := fmt.Sprintf("key-%d", i+1); k.APIKey != want {
+					t.Errorf("keys[%d].apiKey = %q, want %q", i, k.APIKey, want)
+				}
+				if active := i == len(resp.Keys)-1; k.Active != active {
+					t.Errorf("keys[%d].active = %t, want %t", i, k.Active, active)
+				}
+				if k.Base64Auth != composeBase64Auth(base64UserKey, resp.APIUser, k.APIKey, testSubscriptionKey) {
+					t.Errorf("keys[%d].base64Auth does not encode its own key", i)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveSubscriptionKey(t *testing.T) {
+	const (
+		primary   = "11111111111111111111111111111111"
+		secondary = "22222222222222222222222222222222"
+		server    = "33333333333333333333333333333333"
+	)
+	tests := []struct {
+		name       string
+		serverKey  string
+		req        MomoKeyRequest
+		wantKey    string
+		wantSource string
+	}{
+		{"primary first", server, MomoKeyRequest{PrimaryKey: primary, SecondaryKey: secondary}, primary, "request primaryKey"},
+		{"secondary when primary is empty", server, MomoKeyRequest{SecondaryKey: secondary}, secondary, "request secondaryKey"},
+		{"server key when both are empty", server, MomoKeyRequest{}, server, "server configuration (MOMO_SUBSCRIPTION_KEY)"},
+		{"none available", "", MomoKeyRequest{}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, map[string]string{"MOMO_SUBSCRIPTION_KEY": tt.serverKey})
+			key, source := resolveSubscriptionKey(context.Background(), tt.req)
+			if key != tt.wantKey || source != tt.wantSource {
+				t.Errorf("resolveSubscriptionKey = %q, %q; want %q, %q", key, source, tt.wantKey, tt.wantSource)
+			}
+			if strings.Contains(source, key) && key != "" {
+				t.Errorf("source description %q contains the key", source)
+			}
+		})
+	}
+}
+
+func TestGenerateWithoutAnySubscriptionKey(t *testing.T) {
+	h := setupTest(t, map[string]string{"MOMO_SUBSCRIPTION_KEY": ""})
+	rec := doRequest(h, http.MethodPost, "/api/generate", `{"primaryKey":"","secondaryKey":""}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errMissingSubscriptionKey {
+		t.Errorf("errorCode = %q, want %q", env.ErrorCode, errMissingSubscriptionKey)
+	}
+}
+
+func TestGenerateSuccessStatusCode(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int
+	}{
+		{"", http.StatusCreated},
+		{"200", http.StatusOK},
+		{"202", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("SUCCESS_STATUS_CODE=%q", tt.env), func(t *testing.T) {
+			h := setupTest(t, map[string]string{"SUCCESS_STATUS_CODE": tt.env})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuccessStatusCodeValidation(t *testing.T) {
+	for _, v := range []string{"204", "301", "500", "abc"} {
+		t.Setenv("SUCCESS_STATUS_CODE", v)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("loadConfig accepted SUCCESS_STATUS_CODE=%s", v)
+		}
+	}
+}
+
+func TestAllowedTargetEnvs(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    string
+		targetEnv  string
+		wantStatus int
+		wantCode   string
+	}{
+		{"default allows sandbox", "", "", http.StatusCreated, ""},
+		{"default rejects production", "", "mtnghana", http.StatusBadRequest, errTargetEnvNotAllowed},
+		{"listed production", "sandbox,mtnghana", "mtnghana", http.StatusCreated, ""},
+		{"unlisted production", "sandbox,mtnghana", "mtnuganda", http.StatusBadRequest, errTargetEnvNotAllowed},
+		{"unknown environment", "sandbox", "mars", http.StatusBadRequest, errInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ALLOWED_TARGET_ENVS": tt.allowed})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			body := fmt.Sprintf(`{"primaryKey":%q,"targetEnvironment":%q}`, testSubscriptionKey, tt.targetEnv)
+			rec := doRequest(h, http.MethodPost, "/api/generate", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != tt.wantCode {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAllowedTargetEnvsConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{"sandbox"}, false},
+		{" sandbox , mtnghana ", []string{"sandbox", "mtnghana"}, false},
+		{"sandbox,mars", nil, true},
+		{" , ", nil, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("ALLOWED_TARGET_ENVS", tt.value)
+		c, err := loadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ALLOWED_TARGET_ENVS=%q: error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && fmt.Sprint(c.AllowedTargetEnvs) != fmt.Sprint(tt.want) {
+			t.Errorf("ALLOWED_TARGET_ENVS=%q: allowed = %v, want %v", tt.value, c.AllowedTargetEnvs, tt.want)
+		}
+	}
+}
+
+func TestMetricsLatencyBucketsConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []float64
+		wantErr bool
+	}{
+		{"", defaultLatencyBuckets, false},
+		{"0.05, 0.5,5", []float64{0.05, 0.5, 5}, false},
+		{"1,0.5,2", nil, true},
+		{"0,1,2", nil, true},
+		{"-1,1,2", nil, true},
+		{"0.5,1,1,2", nil, true},
+		{"0.5,fast,2", nil, true},
+		{"0.5,,2", nil, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("METRICS_LATENCY_BUCKETS", tt.value)
+		c, err := loadConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("METRICS_LATENCY_BUCKETS=%q: error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && fmt.Sprint(c.MetricsLatencyBuckets) != fmt.Sprint(tt.want) {
+			t.Errorf("METRICS_LATENCY_BUCKETS=%q: buckets = %v, want %v", tt.value, c.MetricsLatencyBuckets, tt.want)
+		}
+	}
+}
+
+func TestIncludeRawResponse(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	body := fmt.Sprintf(`{"primaryKey":%q,"includeRawResponse":true}`, testSubscriptionKey)
+
+	t.Run("dev mode", func(t *testing.T) {
+		h := setupTest(t, map[string]string{"DEV_MODE": "true"})
+		fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/apiuser") {
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"note":"echo %s"}`, r.Header.Get("Ocp-Apim-Subscription-Key"))
+				return
+			}
+			mtnSuccess(apiKey)(w, r)
+		}))
+
+		resp := generate(t, h, body)
+		if resp.APIKey != apiKey {
+			t.Errorf("apiKey = %q, want the parsed key despite the captured body", resp.APIKey)
+		}
+		want := []RawResponse{
+			{Operation: "create API user", Attempt: 1, StatusCode: http.StatusCreated, Body: `{"note":"echo [REDACTED]"}`},
+			{Operation: "create API key", Attempt: 1, StatusCode: http.StatusCreated, Body: `{"apiKey":"[REDACTED]"}`},
+		}
+		if fmt.Sprint(resp.RawResponses) != fmt.Sprint(want) {
+			t.Errorf("rawResponses = %+v, want %+v", resp.RawResponses, want)
+		}
+	})
+
+	t.Run("without dev mode", func(t *testing.T) {
+		h := setupTest(t, map[string]string{"DEV_MODE": "false"})
+		fakeMTN(t, mtnSuccess(apiKey))
+		rec := doRequest(h, http.MethodPost, "/api/generate", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		h := setupTest(t, map[string]string{"DEV_MODE": "true"})
+		fakeMTN(t, mtnSuccess(apiKey))
+		if resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)); resp.RawResponses != nil {
+			t.Errorf("rawResponses = %+v without includeRawResponse", resp.RawResponses)
+		}
+	})
+}
+
+func TestForceFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		devMode    string
+		wantStatus int
+	}{
+		{"dev mode", "true", http.StatusCreated},
+		{"without dev mode", "false", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"DEV_MODE": tt.devMode})
+			var calls atomic.Int32
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")(w, r)
+			}))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"forceFallback":true}`, testSubscriptionKey))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if n := calls.Load(); n != 0 {
+				t.Errorf("MTN called %d times with forceFallback", n)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				env := decodeEnvelope(t, rec, nil)
+				if env.ErrorCode != errInvalidRequest || !strings.Contains(env.Message, "DEV_MODE") {
+					t.Errorf("error = %s %q, want %s naming DEV_MODE", env.ErrorCode, env.Message, errInvalidRequest)
+				}
+				return
+			}
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.Source != sourceLocal || resp.FallbackReason != fallbackForced {
+				t.Errorf("source = %q, fallbackReason = %q; want %q and %q", resp.Source, resp.FallbackReason, sourceLocal, fallbackForced)
+			}
+		})
+	}
+}
+
+func TestDebugOutboundRequests(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	body := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"shop.example.com"}`, testSubscriptionKey)
+
+	t.Run("dev mode", func(t *testing.T) {
+		h := setupTest(t, map[string]string{"DEV_MODE": "true"})
+		fakeMTN(t, mtnSuccess(apiKey))
+
+		rec := doRequest(h, http.MethodPost, "/api/generate?debug=true", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want 201 (body %s)", rec.Code, rec.Body)
+		}
+		var resp MomoKeyResponse
+		decodeEnvelope(t, rec, &resp)
+		if resp.Debug == nil {
+			t.Fatal("no debug output with debug=true in dev mode")
+		}
+
+		// Both MTN calls are listed, in order
+		want := []struct{ operation, pathSuffix string }{
+			{"create API user", "/apiuser"},
+			{"create API key", "/apiuser/" + resp.APIUser + "/apikey"},
+		}
+		outbound := resp.Debug.OutboundRequests
+		if len(outbound) != len(want) {
+			t.Fatalf("outboundRequests = %+v, want %d MTN calls", outbound, len(want))
+		}
+		for i, w := range want {
+			got := outbound[i]
+			if got.Operation != w.operation || got.Method != http.MethodPost || !strings.HasSuffix(got.URL, w.pathSuffix) {
+				t.Errorf("outbound request %d = %s %s %s, want %s POST ...%s", i, got.Operation, got.Method, got.URL, w.operation, w.pathSuffix)
+			}
+			if key := got.Headers["Ocp-Apim-Subscription-Key"]; key != redactedPlaceholder {
+				t.Errorf("outbound request %d subscription key header = %q, want it redacted", i, key)
+			}
+		}
+		if !strings.Contains(outbound[0].Body, "shop.example.com") {
+			t.Errorf("create API user body = %q, want the callback host", outbound[0].Body)
+		}
+
+		raw, _ := json.Marshal(resp.Debug)
+		for _, secret := range []string{testSubscriptionKey, apiKey} {
+			if strings.Contains(string(raw), secret) {
+				t.Errorf("debug output carries a secret: %s", raw)
+			}
+		}
+	})
+
+	t.Run("without dev mode", func(t *testing.T) {
+		h := setupTest(t, map[string]string{"DEV_MODE": "false"})
+		var calls atomic.Int32
+		fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			mtnSuccess(apiKey)(w, r)
+		}))
+
+		rec := doRequest(h, http.MethodPost, "/api/generate?debug=true", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+		}
+		if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest {
+			t.Errorf("errorCode = %q, want %s", env.ErrorCode, errInvalidRequest)
+		}
+		if n := calls.Load(); n != 0 {
+			t.Errorf("MTN called %d times for a rejected debug request", n)
+		}
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		h := setupTest(t, map[string]string{"DEV_MODE": "true"})
+		fakeMTN(t, mtnSuccess(apiKey))
+		if resp := generate(t, h, body); resp.Debug != nil {
+			t.Errorf("debug = %+v without debug=true", *resp.Debug)
+		}
+	})
+}
+
+func TestIdenticalSecondaryKey(t *testing.T) {
+	const otherKey = "fedcba9876543210fedcba9876543210"
+	tests := []struct {
+		name         string
+		strict       string
+		secondary    string
+		wantStatus   int
+		wantWarnings int
+	}{
+		{"distinct keys", "false", otherKey, http.StatusCreated, 0},
+		{"identical keys warn", "false", testSubscriptionKey, http.StatusCreated, 1},
+		{"identical up to whitespace", "false", " " + testSubscriptionKey + " ", http.StatusCreated, 1},
+		{"distinct keys in strict mode", "true", otherKey, http.StatusCreated, 0},
+		{"identical keys in strict mode", "true", testSubscriptionKey, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"STRICT_KEY_VALIDATION": tt.strict})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			body := fmt.Sprintf(`{"primaryKey":%q,"secondaryKey":%q}`, testSubscriptionKey, tt.secondary)
+			rec := doRequest(h, http.MethodPost, "/api/generate", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest {
+					t.Errorf("errorCode = %q, want %q", env.ErrorCode, errInvalidRequest)
+				}
+				return
+			}
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			if len(resp.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %q, want %d", resp.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestGenerateEnvFormat(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess(apiKey))
+
+	rec := doRequest(h, http.MethodPost, "/api/generate?format=env", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="momo.env"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if !strings.HasPrefix(lines[0], "# ") {
+		t.Errorf("first line %q is not a comment", lines[0])
+	}
+	vars := make(map[string]string)
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			t.Fatalf("line %q is not NAME=value", line)
+		}
+		vars[name] = value
+	}
+	userID := vars["MOMO_API_USER"]
+	if _, err := uuid.Parse(userID); err != nil {
+		t.Errorf("MOMO_API_USER = %q, want a UUID", userID)
+	}
+	for name, want := range map[string]string{
+		"MOMO_API_KEY":            apiKey,
+		"MOMO_SUBSCRIPTION_KEY":   testSubscriptionKey,
+		"MOMO_BASE64_AUTH":        base64.StdEncoding.EncodeToString([]byte(userID + ":" + apiKey)),
+		"MOMO_TARGET_ENVIRONMENT": defaultTargetEnv,
+	} {
+		if vars[name] != want {
+			t.Errorf("%s = %q, want %q", name, vars[name], want)
+		}
+	}
+}
+
+func TestGenerateUnsupportedFormat(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	rec := doRequest(h, http.MethodPost, "/api/generate?format=yaml", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    string
+		wantStatus int
+	}{
+		{"slow MTN exceeds the budget", "100ms", http.StatusServiceUnavailable},
+		{"disabled", "0", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"GENERATE_TIMEOUT": tt.timeout})
+			logs := captureLogs(t)
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(300 * time.Millisecond):
+				}
+				success(w, r)
+			}))
+
+			start := time.Now()
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusServiceUnavailable {
+				return
+			}
+			elapsed := time.Since(start)
+			// The abandoned handler falls back to local generation; let it finish before
+			// the next test rewires the globals it reads
+			defer waitForLog(t, logs, "=== API Key Generation Request Completed ===")
+			if elapsed > 250*time.Millisecond {
+				t.Errorf("timed out after %s, want about 100ms", elapsed)
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errRequestTimeout {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, errRequestTimeout)
+			}
+		})
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey), http.StatusCreated},
+		{http.MethodPost, "/api/generate/", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey), http.StatusCreated},
+		{http.MethodGet, "/api/markets", "", http.StatusOK},
+		{http.MethodGet, "/api/markets/", "", http.StatusOK},
+		{http.MethodGet, "/healthz/", "", http.StatusOK},
+		// Method constraints still apply to the trailing-slash form
+		{http.MethodGet, "/api/generate", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/generate/", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+			rec := doRequest(h, tt.method, tt.path, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	for _, path := range []string{"/healthz", "/version"} {
+		t.Run(path, func(t *testing.T) {
+			h := setupTest(t, nil)
+			get := doRequest(h, http.MethodGet, path, "")
+			// A real server drops HEAD bodies; serve through one so the test sees the wire
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			resp, err := http.Head(srv.URL + path)
+			if err != nil {
+				t.Fatalf("HEAD %s: %v", path, err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("HEAD status = %d, want 200", resp.StatusCode)
+			}
+			if len(body) != 0 {
+				t.Errorf("HEAD response has a body: %q", body)
+			}
+			if got, want := resp.Header.Get("Content-Type"), get.Header().Get("Content-Type"); got != want {
+				t.Errorf("HEAD Content-Type = %q, want GET's %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRequireCallbackHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		require    string
+		fields     string
+		wantStatus int
+		wantHost   string
+	}{
+		{"optional, omitted", "false", "", http.StatusCreated, defaultCallbackHost},
+		{"optional, given", "false", `,"callbackHost":"example.com"`, http.StatusCreated, "example.com"},
+		{"required, omitted", "true", "", http.StatusBadRequest, ""},
+		{"required, given", "true", `,"callbackHost":"example.com"`, http.StatusCreated, "example.com"},
+		{"required, url given", "true", `,"callbackUrl":"https://example.com/cb"`, http.StatusCreated, "https://example.com/cb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"REQUIRE_CALLBACK_HOST": tt.require})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q%s}`, testSubscriptionKey, tt.fields))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidCallbackHost {
+					t.Errorf("errorCode = %q, want %q", env.ErrorCode, errInvalidCallbackHost)
+				}
+				return
+			}
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.CallbackHost != tt.wantHost {
+				t.Errorf("callbackHost = %q, want %q", resp.CallbackHost, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestIntField(t *testing.T) {
+	tests := []struct {
+		in      json.Number
+		want    int
+		wantErr string
+	}{
+		{"", 1, ""},
+		{"0", 1, ""},
+		{"1", 1, ""},
+		{"2", 2, ""},
+		{"3", 0, "keyCount must be between 1 and 2"},
+		{"-1", 0, "keyCount must be between 1 and 2"},
+		{"1.5", 0, "keyCount must be an integer"},
+		{"1e30", 0, "keyCount must be between 1 and 2"},
+		{"99999999999999999999", 0, "keyCount must be between 1 and 2"},
+	}
+	for _, tt := range tests {
+		got, err := intField("keyCount", tt.in, 1, 1, 2)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("intField(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("intField(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestGenerateRejectsNonIntegerKeyCount(t *testing.T) {
+	for _, keyCount := range []string{"1.5", "1e30"} {
+		t.Run(keyCount, func(t *testing.T) {
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"keyCount":%s}`, testSubscriptionKey, keyCount))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest || !strings.Contains(env.Message, "keyCount") {
+				t.Errorf("error = %s %q, want %s naming keyCount", env.ErrorCode, env.Message, errInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestFallbackKeyBytes(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantLen int
+		wantErr bool
+	}{
+		{"", 32, false},
+		{"16", 32, false},
+		{"24", 48, false},
+		{"64", 128, false},
+		{"15", 0, true},
+		{"65", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run("FALLBACK_KEY_BYTES="+tt.value, func(t *testing.T) {
+			t.Setenv("FALLBACK_KEY_BYTES", tt.value)
+			if tt.wantErr {
+				if _, err := loadConfig(); err == nil {
+					t.Error("loadConfig accepted an out-of-range value")
+				}
+				return
+			}
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnStatus(http.StatusServiceUnavailable))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if resp.Source != sourceLocal {
+				t.Fatalf("source = %q, want a local fallback", resp.Source)
+			}
+			if len(resp.APIKey) != tt.wantLen {
+				t.Errorf("fallback key has %d characters, want %d", len(resp.APIKey), tt.wantLen)
+			}
+			if strings.Trim(resp.APIKey, "0123456789abcdef") != "" {
+				t.Errorf("fallback key %q is not lowercase hex", resp.APIKey)
+			}
+		})
+	}
+}
+
+// The full API key appears in the generate response only; logs carry its fingerprint
+func TestAPIKeyNeverLogged(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	tests := []struct {
+		name string
+		mtn  http.HandlerFunc
+	}{
+		{"mtn", mtnSuccess(apiKey)},
+		{"local fallback", mtnStatus(http.StatusServiceUnavailable)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken})
+			fakeMTN(t, tt.mtn)
+			logs := captureLogs(t)
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"keyCount":2}`, testSubscriptionKey))
+			doRequest(h, http.MethodGet, "/api/credentials/"+resp.UserID, "")
+			doRequest(h, http.MethodGet, "/api/key/"+resp.UserID, "")
+			doRequest(h, http.MethodGet, "/api/credentials/export", "", "Authorization", "Bearer "+testAdminToken)
+
+			out := logs.String()
+			for _, key := range append([]string{resp.APIKey}, issuedKeyValues(resp.Keys)...) {
+				if strings.Contains(out, key) {
+					t.Errorf("logs contain the API key %s:\n%s", key, out)
+				}
+			}
+			if !strings.Contains(out, keyFingerprint(resp.APIKey)) {
+				t.Errorf("logs lack the key fingerprint %s", keyFingerprint(resp.APIKey))
+			}
+		})
+	}
+}
+
+func TestTestCommandLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantInLog bool
+	}{
+		{"default", nil, false},
+		{"disabled", map[string]string{"LOG_TEST_COMMAND": "false"}, false},
+		{"enabled", map[string]string{"LOG_TEST_COMMAND": "true"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+			logs := captureLogs(t)
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			auth := base64.StdEncoding.EncodeToString([]byte(resp.UserID + ":" + resp.APIKey))
+			// The response carries the command whether or not it is logged
+			if !strings.Contains(resp.TestCommand, auth) {
+				t.Fatalf("test command lacks the base64 auth %s:\n%s", auth, resp.TestCommand)
+			}
+			if got := strings.Contains(logs.String(), auth); got != tt.wantInLog {
+				t.Errorf("base64 auth in logs = %t, want %t:\n%s", got, tt.wantInLog, logs)
+			}
+		})
+	}
+}
+
+func TestSubscriptionKeySuffix(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"off by default", nil, ""},
+		{"enabled", map[string]string{"INCLUDE_SUBSCRIPTION_KEY_SUFFIX": "true"}, "cdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			var data map[string]interface{}
+			decodeEnvelope(t, rec, &data)
+			got, present := data["subscriptionKeySuffix"]
+			if tt.want == "" {
+				if present {
+					t.Errorf("subscriptionKeySuffix = %v, want it omitted", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("subscriptionKeySuffix = %v, want only the last 4 characters %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionKeySuffixHelper(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{testSubscriptionKey, "cdef"},
+		{"12345678", "5678"},
+		{"1234567", ""}, // Too short: the suffix would give away most of the key
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := subscriptionKeySuffix(tt.key); got != tt.want {
+			t.Errorf("subscriptionKeySuffix(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestProductionTestCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantHint  bool
+		targetEnv string
+	}{
+		{"not requested", `{"primaryKey":%q}`, false, "sandbox"},
+		{"sandbox", `{"primaryKey":%q,"includeProductionHint":true}`, true, "sandbox"},
+		{"already targeting a market", `{"primaryKey":%q,"includeProductionHint":true,"targetEnvironment":"mtnghana"}`, false, "mtnghana"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ALLOWED_TARGET_ENVS": "sandbox,mtnghana"})
+			srv := fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			resp := generate(t, h, fmt.Sprintf(tt.body, testSubscriptionKey))
+			if resp.TargetEnv != tt.targetEnv {
+				t.Fatalf("targetEnvironment = %q, want %q", resp.TargetEnv, tt.targetEnv)
+			}
+			if !strings.Contains(resp.TestCommand, "'"+srv.URL+"/collection/token/'") || strings.Contains(resp.TestCommand, productionBaseURL) {
+				t.Errorf("testCommand does not target the sandbox host %s:\n%s", srv.URL, resp.TestCommand)
+			}
+			if !tt.wantHint {
+				if resp.ProductionTestCommand != "" {
+					t.Errorf("productionTestCommand = %q, want it omitted", resp.ProductionTestCommand)
+				}
+				return
+			}
+
+			prod := resp.ProductionTestCommand
+			for _, want := range []string{"'https://proxy.momoapi.mtn.com/collection/token/'", "X-Target-Environment: <TARGET_ENVIRONMENT", "<PRODUCTION_SUBSCRIPTION_KEY>"} {
+				if !strings.Contains(prod, want) {
+					t.Errorf("productionTestCommand lacks %s:\n%s", want, prod)
+				}
+			}
+			// The production command only holds placeholders, never the sandbox secrets
+			for _, secret := range []string{srv.URL, testSubscriptionKey, resp.APIKey, resp.Base64Auth} {
+				if strings.Contains(prod, secret) {
+					t.Errorf("productionTestCommand contains %s:\n%s", secret, prod)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackPerTargetEnvironment(t *testing.T) {
+	tests := []struct {
+		name      string
+		fallback  string // FALLBACK_TARGET_ENVS; "" keeps the default
+		targetEnv string
+		wantLocal bool
+	}{
+		{"sandbox allows by default", "", "sandbox", true},
+		{"production forbids by default", "", "mtnghana", false},
+		{"listed production market", "sandbox,mtnuganda", "mtnuganda", true},
+		{"unlisted production market", "sandbox,mtnuganda", "mtnghana", false},
+		{"every environment", "*", "mtnghana", true},
+		{"disabled everywhere", "none", "sandbox", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"ALLOWED_TARGET_ENVS": "sandbox,mtnghana,mtnuganda", "DEV_MODE": "true"}
+			if tt.fallback != "" {
+				env["FALLBACK_TARGET_ENVS"] = tt.fallback
+			}
+			h := setupTest(t, env)
+			fakeMTN(t, mtnStatus(http.StatusServiceUnavailable))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"targetEnvironment":%q}`, testSubscriptionKey, tt.targetEnv))
+			if !tt.wantLocal {
+				if rec.Code != http.StatusBadGateway {
+					t.Fatalf("status = %d, want 502 without fallback (body %s)", rec.Code, rec.Body)
+				}
+				if got := decodeEnvelope(t, rec, nil); got.ErrorCode != errMTNUnavailable {
+					t.Errorf("errorCode = %q, want %q", got.ErrorCode, errMTNUnavailable)
+				}
+			} else {
+				var resp MomoKeyResponse
+				decodeEnvelope(t, rec, &resp)
+				if rec.Code != http.StatusCreated || resp.Source != sourceLocal {
+					t.Fatalf("status %d source %q, want 201 local credentials (body %s)", rec.Code, resp.Source, rec.Body)
+				}
+			}
+
+			// forceFallback follows the same policy
+			rec = doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"targetEnvironment":%q,"forceFallback":true}`, testSubscriptionKey, tt.targetEnv))
+			if forced := rec.Code == http.StatusCreated; forced != tt.wantLocal {
+				t.Errorf("forceFallback: status = %d, want fallback allowed = %t", rec.Code, tt.wantLocal)
+			}
+		})
+	}
+}
+
+func TestGenerateTimings(t *testing.T) {
+	const userDelay, keyDelay = 60 * time.Millisecond, 40 * time.Millisecond
+	tests := []struct {
+		name       string
+		keyCount   int
+		wantUserMs int64
+		wantKeyMs  int64
+	}{
+		{"one key", 1, userDelay.Milliseconds(), keyDelay.Milliseconds()},
+		{"two keys", 2, userDelay.Milliseconds(), 2 * keyDelay.Milliseconds()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/apikey") {
+					time.Sleep(keyDelay)
+				} else {
+					time.Sleep(userDelay)
+				}
+				success(w, r)
+			}))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"keyCount":%d,"includeTimings":true}`, testSubscriptionKey, tt.keyCount))
+			timings := resp.Timings
+			if timings == nil {
+				t.Fatal("no timings with includeTimings set")
+			}
+			if timings.UserCreateMs < tt.wantUserMs || timings.KeyCreateMs < tt.wantKeyMs {
+				t.Errorf("timings = %+v, want userCreateMs >= %d and keyCreateMs >= %d", *timings, tt.wantUserMs, tt.wantKeyMs)
+			}
+			// The MTN calls are most of the request; the rest is local work
+			sum := timings.UserCreateMs + timings.KeyCreateMs
+			if sum > timings.TotalMs || timings.TotalMs-sum > 50 {
+				t.Errorf("timings = %+v: sub-timings sum to %dms, want roughly totalMs", *timings, sum)
+			}
+		})
+	}
+
+	t.Run("not requested", func(t *testing.T) {
+		h := setupTest(t, nil)
+		fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+		if resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)); resp.Timings != nil {
+			t.Errorf("timings = %+v without includeTimings", *resp.Timings)
+		}
+	})
+}
+
+// issuedKeyValues returns the keys of a multi-key generate response
+func issuedKeyValues(keys []IssuedKey) []string {
+	var values []string
+	for _, k := range keys {
+		values = append(values, k.APIKey)
+	}
+	return values
+}
+
+func TestBase64Format(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	tests := []struct {
+		format     string
+		wantStatus int
+		wantFirst  func(resp MomoKeyResponse) string
+	}{
+		{"", http.StatusCreated, func(resp MomoKeyResponse) string { return resp.APIUser }},
+		{base64UserKey, http.StatusCreated, func(resp MomoKeyResponse) string { return resp.APIUser }},
+		{base64SubscriptionKey, http.StatusCreated, func(MomoKeyResponse) string { return testSubscriptionKey }},
+		{"key:user", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run("base64Format="+tt.format, func(t *testing.T) {
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnSuccess(apiKey))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"base64Format":%q}`, testSubscriptionKey, tt.format))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantFirst == nil {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest {
+					t.Errorf("errorCode = %q, want %q", env.ErrorCode, errInvalidRequest)
+				}
+				return
+			}
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			want := base64.StdEncoding.EncodeToString([]byte(tt.wantFirst(resp) + ":" + apiKey))
+			if resp.Base64Auth != want {
+				t.Errorf("base64Auth = %q, want %q", resp.Base64Auth, want)
+			}
+			wantFormat := tt.format
+			if wantFormat == "" {
+				wantFormat = base64UserKey
+			}
+			if resp.Base64Format != wantFormat {
+				t.Errorf("base64Format = %q, want %q", resp.Base64Format, wantFormat)
+			}
+		})
+	}
+}
+
+func TestNormalizeCallbackHost(t *testing.T) {
+	tests := map[string]string{
+		"example.com":                           "example.com",
+		"Example.COM":                           "example.com",
+		"example.com.":                          "example.com",
+		"WWW.Example.com":                       "www.example.com",
+		"https://Example.COM./MoMo/Callback":    "https://example.com/MoMo/Callback",
+		"https://EXAMPLE.com:8443/cb":           "https://example.com:8443/cb",
+		"https://[2001:DB8::1]:8443/cb":         "https://[2001:db8::1]:8443/cb",
+		"https://example.com/cb?Token=AbC#Frag": "https://example.com/cb?Token=AbC#Frag",
+	}
+	for in, want := range tests {
+		if got := normalizeCallbackHost(in); got != want {
+			t.Errorf("normalizeCallbackHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGenerateNormalizesCallbackHost(t *testing.T) {
+	tests := []struct {
+		normalize string
+		want      string
+	}{
+		{"true", "example.com"},
+		{"false", "Example.COM."},
+	}
+	for _, tt := range tests {
+		t.Run("NORMALIZE_CALLBACK_HOST="+tt.normalize, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"NORMALIZE_CALLBACK_HOST": tt.normalize})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"Example.COM."}`, testSubscriptionKey))
+			if resp.CallbackHost != tt.want {
+				t.Errorf("callbackHost = %q, want %q", resp.CallbackHost, tt.want)
+			}
+		})
+	}
+}
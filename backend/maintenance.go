@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// maintenanceMode pauses credential generation, e.g. during an MTN maintenance
+// window. It starts from MAINTENANCE_MODE and is toggled via /api/admin/maintenance.
+var maintenanceMode atomic.Bool
+
+// MaintenanceStatus is the body of /api/admin/maintenance requests and responses
+type MaintenanceStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// withMaintenance answers 503 with Retry-After instead of calling next while
+// maintenance mode is on, so we don't create users we know will fail
+func withMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceMode.Load() {
+			next(w, r)
+			return
+		}
+		log.Printf("WARNING: Rejected %s %s - maintenance mode is on", r.Method, r.URL.Path)
+		w.Header().Set("Retry-After", strconv.Itoa(int(cfg.MaintenanceRetryAfter.Seconds())))
+		sendError(w, r, errMaintenance, "Credential generation is paused for maintenance, please retry later", http.StatusServiceUnavailable)
+	}
+}
+
+// handleMaintenance reports (GET) or sets (POST {"enabled": true}) maintenance mode.
+// It must be guarded by requireAdminToken.
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req MaintenanceStatus
+		if err := decodeJSONBody(r, &req); err != nil {
+			log.Printf("ERROR: Invalid request format - %v", err)
+			sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+			return
+		}
+		maintenanceMode.Store(req.Enabled)
+		log.Printf("WARNING: Maintenance mode set to %t via admin endpoint", req.Enabled)
+	}
+	sendResponse(w, r, true, "Maintenance mode status", MaintenanceStatus{Enabled: maintenanceMode.Load()}, http.StatusOK)
+}
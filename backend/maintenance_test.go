@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken, "MAINTENANCE_MODE": "false", "MAINTENANCE_RETRY_AFTER": "2m"})
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+	auth := []string{"Authorization", "Bearer " + testAdminToken}
+
+	setMaintenance := func(enabled bool) {
+		t.Helper()
+		rec := doRequest(h, http.MethodPost, "/api/admin/maintenance", fmt.Sprintf(`{"enabled":%t}`, enabled), auth...)
+		var status MaintenanceStatus
+		decodeEnvelope(t, rec, &status)
+		if rec.Code != http.StatusOK || status.Enabled != enabled {
+			t.Fatalf("setting maintenance %t: status %d, %+v", enabled, rec.Code, status)
+		}
+	}
+
+	if rec := doRequest(h, http.MethodPost, "/api/generate", body); rec.Code != http.StatusCreated {
+		t.Fatalf("generate with maintenance off: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	setMaintenance(true)
+	rec := doRequest(h, http.MethodPost, "/api/generate", body)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("generate with maintenance on: status = %d, want 503", rec.Code)
+	}
+	if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errMaintenance {
+		t.Errorf("errorCode = %q, want %q", env.ErrorCode, errMaintenance)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Retry-After = %q, want 120", got)
+	}
+	// Only generation is paused; the server stays healthy
+	if rec := doRequest(h, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Errorf("/healthz in maintenance: status = %d", rec.Code)
+	}
+	var status MaintenanceStatus
+	decodeEnvelope(t, doRequest(h, http.MethodGet, "/api/admin/maintenance", "", auth...), &status)
+	if !status.Enabled {
+		t.Error("GET maintenance reports disabled while on")
+	}
+
+	setMaintenance(false)
+	if rec := doRequest(h, http.MethodPost, "/api/generate", body); rec.Code != http.StatusCreated {
+		t.Errorf("generate after maintenance: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestMaintenanceRequiresAdminToken(t *testing.T) {
+	h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken, "MAINTENANCE_MODE": "false"})
+	rec := doRequest(h, http.MethodPost, "/api/admin/maintenance", `{"enabled":true}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+	if maintenanceMode.Load() {
+		t.Error("maintenance mode was enabled without the admin token")
+	}
+}
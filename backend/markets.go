@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// momoMarkets are the X-Target-Environment values MTN knows, with display names.
+// It is the single source for targetEnvironment: ALLOWED_TARGET_ENVS may only
+// list these, and /api/markets serves them. Add new markets here.
+var momoMarkets = map[string]string{
+	"sandbox":          "Sandbox (testing)",
+	"mtnbenin":         "MTN Benin",
+	"mtncameroon":      "MTN Cameroon",
+	"mtncongo":         "MTN Congo Brazzaville",
+	"mtnghana":         "MTN Ghana",
+	"mtnguineaconakry": "MTN Guinea Conakry",
+	"mtnivorycoast":    "MTN Côte d'Ivoire",
+	"mtnliberia":       "MTN Liberia",
+	"mtnsouthafrica":   "MTN South Africa",
+	"mtnswaziland":     "MTN Eswatini",
+	"mtnuganda":        "MTN Uganda",
+	"mtnzambia":        "MTN Zambia",
+}
+
+// knownMarket reports whether code is one of momoMarkets
+func knownMarket(code string) bool {
+	_, ok := momoMarkets[code]
+	return ok
+}
+
+// marketCodes lists the momoMarkets codes in order, for errors and /api/markets
+func marketCodes() []string {
+	codes := make([]string, 0, len(momoMarkets))
+	for code := range momoMarkets {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Market is one entry of GET /api/markets
+type Market struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Allowed reports whether this server accepts the market as targetEnvironment (ALLOWED_TARGET_ENVS)
+	Allowed bool `json:"allowed"`
+}
+
+// handleMarkets lists the MTN markets (target environments), for frontend dropdowns
+func handleMarkets(w http.ResponseWriter, r *http.Request) {
+	markets := make([]Market, 0, len(momoMarkets))
+	for _, code := range marketCodes() {
+		markets = append(markets, Market{Code: code, Name: momoMarkets[code], Allowed: targetEnvAllowed(code)})
+	}
+	sendResponse(w, r, true, "Supported MTN markets", markets, http.StatusOK)
+}
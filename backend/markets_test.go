@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// listMarkets calls GET /api/markets
+func listMarkets(t *testing.T, h http.Handler) []Market {
+	t.Helper()
+	rec := doRequest(h, http.MethodGet, "/api/markets", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/markets: status = %d (body %s)", rec.Code, rec.Body)
+	}
+	var markets []Market
+	decodeEnvelope(t, rec, &markets)
+	return markets
+}
+
+func TestMarketsMatchValidation(t *testing.T) {
+	h := setupTest(t, map[string]string{"ALLOWED_TARGET_ENVS": "sandbox,mtnghana"})
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+	markets := listMarkets(t, h)
+	if len(markets) != len(momoMarkets) {
+		t.Fatalf("listed %d markets, want all %d of momoMarkets", len(markets), len(momoMarkets))
+	}
+	if !sort.SliceIsSorted(markets, func(i, j int) bool { return markets[i].Code < markets[j].Code }) {
+		t.Error("markets are not sorted by code")
+	}
+
+	// Every listed market passes targetEnvironment validation, and is accepted
+	// exactly when it is marked allowed
+	for _, m := range markets {
+		if m.Name == "" || m.Name != momoMarkets[m.Code] {
+			t.Errorf("%s: name = %q, want %q", m.Code, m.Name, momoMarkets[m.Code])
+		}
+		rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"targetEnvironment":%q}`, testSubscriptionKey, m.Code))
+		env := decodeEnvelope(t, rec, nil)
+		switch {
+		case m.Allowed && rec.Code != http.StatusCreated:
+			t.Errorf("%s is allowed but generate answered %d %s", m.Code, rec.Code, env.ErrorCode)
+		case !m.Allowed && env.ErrorCode != errTargetEnvNotAllowed:
+			t.Errorf("%s is listed but not allowed, generate answered %d %s, want %s", m.Code, rec.Code, env.ErrorCode, errTargetEnvNotAllowed)
+		}
+	}
+
+	// A code that is not listed is not a known market at all
+	rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"targetEnvironment":"mtnatlantis"}`, testSubscriptionKey))
+	if env := decodeEnvelope(t, rec, nil); rec.Code != http.StatusBadRequest || env.ErrorCode != errInvalidRequest {
+		t.Errorf("unlisted market: %d %s, want 400 %s", rec.Code, env.ErrorCode, errInvalidRequest)
+	}
+}
+
+func TestMarketsAllowedFollowsConfig(t *testing.T) {
+	tests := []struct {
+		allowed string
+		want    []string
+	}{
+		{"", []string{"sandbox"}},
+		{"mtnuganda, mtnzambia", []string{"mtnuganda", "mtnzambia"}},
+		{strings.Join(marketCodes(), ","), marketCodes()},
+	}
+	for _, tt := range tests {
+		t.Run("ALLOWED_TARGET_ENVS="+tt.allowed, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ALLOWED_TARGET_ENVS": tt.allowed})
+			var got []string
+			for _, m := range listMarkets(t, h) {
+				if m.Allowed {
+					got = append(got, m.Code)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("allowed markets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
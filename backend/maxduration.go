@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// withMaxDuration is the server-wide backstop behind the per-route timeouts: every
+// request's context gets a MAX_REQUEST_DURATION deadline, which cancels its outbound
+// MTN calls, and a request still running at the deadline is answered with 504 and
+// anything the handler writes afterwards is discarded. A streaming response that has
+// already started cannot be replaced, so it is only cancelled. A zero d disables it.
+// WebSocket connections (the log stream) are long-lived by design and exempt.
+func withMaxDuration(next http.Handler, d time.Duration) http.Handler {
+	if d == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		dw := &deadlineWriter{w: w, header: make(http.Header), ctx: ctx}
+		done := make(chan struct{})
+		panicCh := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					panicCh <- rec
+				}
+				close(done)
+			}()
+			next.ServeHTTP(dw, r)
+		}()
+
+		answerTimeout := func() {
+			if dw.timeOut() {
+				log.Printf("ERROR: %s %s exceeded MAX_REQUEST_DURATION (%s), answered 504", r.Method, r.URL.Path, d)
+				sendError(w, r, errRequestTimeout, "Request exceeded the maximum processing time", http.StatusGatewayTimeout)
+			} else {
+				log.Printf("ERROR: %s %s exceeded MAX_REQUEST_DURATION (%s) after its response started, cancelled", r.Method, r.URL.Path, d)
+			}
+		}
+		select {
+		case <-done:
+			// A handler that reacted to the deadline quickly (say, by falling back) may
+			// finish before it is noticed here; its late response was held back
+			if ctx.Err() == context.DeadlineExceeded {
+				answerTimeout()
+			}
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				// Cancelled from outside (the client went away), not our deadline
+				<-done
+				break
+			}
+			answerTimeout()
+			// The handler still holds dw; wait for it to notice the cancellation
+			<-done
+		}
+		select {
+		case rec := <-panicCh:
+			panic(rec)
+		default:
+		}
+	})
+}
+
+// deadlineWriter lets withMaxDuration take over a response that has not started.
+// The handler writes headers to its own map, copied over when the response starts,
+// so the 504 never races with the handler's header changes.
+type deadlineWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	ctx    context.Context // Carries the MAX_REQUEST_DURATION deadline
+
+	mu       sync.Mutex
+	started  bool // The handler's response has begun
+	timedOut bool // withMaxDuration answered instead; handler writes are discarded
+}
+
+// timeOut claims the response for the 504, reporting false if the handler's has already begun
+func (dw *deadlineWriter) timeOut() bool {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.started {
+		return false
+	}
+	dw.timedOut = true
+	return true
+}
+
+func (dw *deadlineWriter) Header() http.Header { return dw.header }
+
+func (dw *deadlineWriter) WriteHeader(status int) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	dw.writeHeaderLocked(status)
+}
+
+// writeHeaderLocked starts the handler's response, unless the deadline has passed
+// and the response belongs to the 504; dw.mu must be held
+func (dw *deadlineWriter) writeHeaderLocked(status int) {
+	if dw.started || dw.timedOut {
+		return
+	}
+	if dw.ctx.Err() == context.DeadlineExceeded {
+		dw.timedOut = true
+		return
+	}
+	dw.started = true
+	for k, v := range dw.header {
+		dw.w.Header()[k] = v
+	}
+	dw.w.WriteHeader(status)
+}
+
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	dw.writeHeaderLocked(http.StatusOK)
+	if dw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return dw.w.Write(b)
+}
+
+// Flush keeps server-sent events (batch progress) streaming through the wrapper
+func (dw *deadlineWriter) Flush() {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	dw.writeHeaderLocked(http.StatusOK)
+	if dw.timedOut {
+		return
+	}
+	if f, ok := dw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowMTN is an MTN stub that answers after delay, recording whether a call was
+// cancelled before then
+func slowMTN(delay time.Duration, cancelled *atomic.Bool) http.HandlerFunc {
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The server only watches for the client going away once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			cancelled.Store(true)
+			return
+		case <-time.After(delay):
+		}
+		success(w, r)
+	}
+}
+
+// waitCancelled waits for the stub to see its call cancelled; the server side notices
+// the client's abort a moment after the client gives up
+func waitCancelled(t *testing.T, cancelled *atomic.Bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cancelled.Load() {
+		if time.Now().After(deadline) {
+			t.Error("the outbound MTN call was not cancelled at the deadline")
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMaxRequestDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		cap        string
+		wantStatus int
+	}{
+		{"stub slower than the cap", "100ms", http.StatusGatewayTimeout},
+		{"disabled", "0", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// GENERATE_TIMEOUT=0 leaves the server-wide cap as the only deadline
+			h := setupTest(t, map[string]string{"MAX_REQUEST_DURATION": tt.cap, "GENERATE_TIMEOUT": "0"})
+			var cancelled atomic.Bool
+			fakeMTN(t, slowMTN(400*time.Millisecond, &cancelled))
+
+			start := time.Now()
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			elapsed := time.Since(start)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusGatewayTimeout {
+				return
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errRequestTimeout {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, errRequestTimeout)
+			}
+			if elapsed > 350*time.Millisecond {
+				t.Errorf("answered after %s, want about 100ms", elapsed)
+			}
+			waitCancelled(t, &cancelled)
+		})
+	}
+}
+
+func TestMaxRequestDurationStartedStream(t *testing.T) {
+	h := setupTest(t, map[string]string{"MAX_REQUEST_DURATION": "100ms"})
+	var cancelled atomic.Bool
+	fakeMTN(t, slowMTN(400*time.Millisecond, &cancelled))
+
+	// The event stream has begun by the deadline, so it is cut short rather than replaced
+	rec := doRequest(h, http.MethodPost, "/api/generate/batch", batchBody(1), "Accept", "text/event-stream")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the stream's 200 (body %s)", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "event: result") {
+		t.Errorf("stream has a result after the deadline:\n%s", rec.Body)
+	}
+	waitCancelled(t, &cancelled)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// defaultLatencyBuckets are histogram buckets (in seconds) tuned for external calls to MTN,
+// which typically take hundreds of milliseconds to several seconds
+var defaultLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10}
+
+// Prometheus collectors, created by initMetrics
+var (
+	metricsRegistry *prometheus.Registry
+
+	// momoCallDuration tracks the latency of outbound calls to the MTN MoMo API
+	momoCallDuration *prometheus.HistogramVec
+
+	// generateRequestsTotal counts /api/generate outcomes by credential source
+	generateRequestsTotal *prometheus.CounterVec
+)
+
+func init() {
+	initMetrics(defaultLatencyBuckets)
+}
+
+// initMetrics (re)creates the collectors on a fresh registry using the given latency buckets
+func initMetrics(buckets []float64) {
+	metricsRegistry = prometheus.NewRegistry()
+
+	momoCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "momo_api_call_duration_seconds",
+		Help:    "Latency of outbound calls to the MTN MoMo API.",
+		Buckets: buckets,
+	}, []string{"operation", "outcome"})
+
+	generateRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "momo_generate_requests_total",
+		Help: "Credential generation requests by credential source.",
+	}, []string{"source"})
+
+	metricsRegistry.MustRegister(momoCallDuration, generateRequestsTotal)
+}
+
+// observeMomoCall records the duration and outcome of an MTN MoMo API call
+func observeMomoCall(operation string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	momoCallDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// metricsHandler serves the collected metrics in the Prometheus exposition format
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// resetMetrics zeroes every collector so metric assertions in tests start from a
+// known state. Unlike initMetrics it keeps the collectors and registry in place, and
+// Reset is safe to call while requests are still recording.
+func resetMetrics() {
+	momoCallDuration.Reset()
+	generateRequestsTotal.Reset()
+}
+
+// generateCount returns momo_generate_requests_total for source, as scraped from the registry
+func generateCount(t *testing.T, source string) float64 {
+	t.Helper()
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "momo_generate_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "source" && label.GetValue() == source {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestResetMetricsBetweenGenerates(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+
+	generate(t, h, body)
+	if got := generateCount(t, sourceMTN); got != 1 {
+		t.Fatalf("after the first generate: count = %v, want 1", got)
+	}
+
+	resetMetrics()
+	if got := generateCount(t, sourceMTN); got != 0 {
+		t.Fatalf("after reset: count = %v, want 0", got)
+	}
+
+	generate(t, h, body)
+	if got := generateCount(t, sourceMTN); got != 1 {
+		t.Errorf("after the second generate: count = %v, want 1 counted from the reset", got)
+	}
+}
+
+func TestResetMetricsConcurrent(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+
+	// Resetting while requests record must be race-free (run with -race)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if rec := doRequest(h, http.MethodPost, "/api/generate", body); rec.Code != http.StatusCreated {
+				t.Errorf("generate: status = %d", rec.Code)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			resetMetrics()
+		}()
+	}
+	wg.Wait()
+
+	resetMetrics()
+	if got := generateCount(t, sourceMTN); got != 0 {
+		t.Errorf("count after a final reset = %v, want 0", got)
+	}
+}
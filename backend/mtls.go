@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// loadMomoTLSConfig builds the TLS settings of the MTN client from MOMO_CLIENT_CERT_FILE,
+// MOMO_CLIENT_KEY_FILE and MOMO_CA_FILE, for gateways that require mutual TLS or are
+// signed by a private CA. It returns nil when none is set, keeping Go's defaults.
+func loadMomoTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("MOMO_CLIENT_CERT_FILE and MOMO_CLIENT_KEY_FILE must be set together")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("MOMO_CLIENT_CERT_FILE/MOMO_CLIENT_KEY_FILE: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("MOMO_CA_FILE: %v", err)
+		}
+		// The CA is added to the system roots, so public MTN hosts still verify
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("MOMO_CA_FILE %s holds no PEM certificate", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// momoClientCertSummary describes the MTN client certificate for the startup log
+func momoClientCertSummary(tlsConfig *tls.Config) string {
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 {
+		return "none"
+	}
+	leaf := tlsConfig.Certificates[0].Leaf
+	if leaf == nil {
+		return "configured"
+	}
+	return fmt.Sprintf("%s (expires %s)", leaf.Subject, leaf.NotAfter.UTC().Format("2006-01-02"))
+}
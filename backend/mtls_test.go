@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate issued for a test, with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issueCert creates a certificate from template, signed by parent (self-signed when nil)
+func issueCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return &testCert{cert, key, der}
+}
+
+// writeFiles writes the certificate and key as PEM files in dir, returning their paths
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pemBlock("CERTIFICATE", c.der))
+	writeFile(t, keyFile, pemBlock("EC PRIVATE KEY", keyDER))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// mtlsMTN starts an MTN stub that requires a client certificate issued by ca,
+// reporting the common name of the certificate each request presented
+func mtlsMTN(t *testing.T, ca *testCert) *atomic.Value {
+	t.Helper()
+	server := issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mtn.test"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	var presented atomic.Value
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+		success(w, r)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+	base := momoBaseURL
+	momoBaseURL = srv.URL
+	t.Cleanup(func() {
+		momoBaseURL = base
+		srv.Close()
+	})
+	return &presented
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test MTN CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+	client := issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "momo-key-generator"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	dir := t.TempDir()
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	certFile, keyFile := client.writeFiles(t, dir, "client")
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		wantSource    string
+		wantPresented string
+	}{
+		{"client certificate", map[string]string{"MOMO_CLIENT_CERT_FILE": certFile, "MOMO_CLIENT_KEY_FILE": keyFile, "MOMO_CA_FILE": caFile}, sourceMTN, "momo-key-generator"},
+		{"no client certificate", map[string]string{"MOMO_CA_FILE": caFile}, sourceLocal, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			presented := mtlsMTN(t, ca)
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if resp.Source != tt.wantSource {
+				t.Errorf("source = %q, want %q", resp.Source, tt.wantSource)
+			}
+			if got, _ := presented.Load().(string); got != tt.wantPresented {
+				t.Errorf("MTN saw client certificate %q, want %q", got, tt.wantPresented)
+			}
+		})
+	}
+}
+
+func TestMutualTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	client := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "momo-key-generator"}}, nil)
+	certFile, keyFile := client.writeFiles(t, dir, "client")
+	notPEM := filepath.Join(dir, "not.pem")
+	writeFile(t, notPEM, "not a certificate")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"certificate and key", map[string]string{"MOMO_CLIENT_CERT_FILE": certFile, "MOMO_CLIENT_KEY_FILE": keyFile}, false},
+		{"certificate without key", map[string]string{"MOMO_CLIENT_CERT_FILE": certFile}, true},
+		{"key without certificate", map[string]string{"MOMO_CLIENT_KEY_FILE": keyFile}, true},
+		{"missing certificate file", map[string]string{"MOMO_CLIENT_CERT_FILE": filepath.Join(dir, "missing.crt"), "MOMO_CLIENT_KEY_FILE": keyFile}, true},
+		{"key not PEM", map[string]string{"MOMO_CLIENT_CERT_FILE": certFile, "MOMO_CLIENT_KEY_FILE": notPEM}, true},
+		{"CA file without certificates", map[string]string{"MOMO_CA_FILE": notPEM}, true},
+		{"missing CA file", map[string]string{"MOMO_CA_FILE": filepath.Join(dir, "missing.pem")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := loadConfig(); (err != nil) != tt.wantErr {
+				t.Errorf("loadConfig err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
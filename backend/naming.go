@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Response field naming styles (NAMING_STYLE)
+const (
+	namingCamel = "camel" // apiKey, apiUser (default)
+	namingSnake = "snake" // api_key, api_user, for legacy consumers
+)
+
+// generateResponseData returns the generate response in the configured naming style
+func generateResponseData(resp MomoKeyResponse) interface{} {
+	if cfg.NamingStyle == namingSnake {
+		return snakeCaseFields(reflect.ValueOf(resp))
+	}
+	return resp
+}
+
+// camelToSnake converts a camelCase JSON name to snake_case, keeping acronyms
+// together (apiKey -> api_key, base64Auth -> base64_auth, outboundURL -> outbound_url)
+func camelToSnake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// snakeCaseFields converts a value into one that encodes with the snake_case
+// versions of its structs' JSON field names. It honors json tags, "-" and
+// omitempty; map keys (such as header names) are data and are left alone.
+func snakeCaseFields(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return snakeCaseFields(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = snakeCaseFields(v.Index(i))
+		}
+		return items
+	case reflect.Struct:
+		if _, ok := v.Interface().(json.Marshaler); ok {
+			return v.Interface()
+		}
+		var fields orderedFields
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if strings.Contains(opts, "omitempty") && isEmptyJSONValue(v.Field(i)) {
+				continue
+			}
+			fields = append(fields, orderedField{camelToSnake(name), snakeCaseFields(v.Field(i))})
+		}
+		return fields
+	}
+	return v.Interface()
+}
+
+// isEmptyJSONValue reports whether encoding/json's omitempty would omit v
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// orderedField is one key/value of an orderedFields object
+type orderedField struct {
+	name  string
+	value interface{}
+}
+
+// orderedFields is a JSON object that keeps its fields in struct declaration order
+type orderedFields []orderedField
+
+func (f orderedFields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(field.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCamelToSnake(t *testing.T) {
+	tests := map[string]string{
+		"apiKey":            "api_key",
+		"apiUser":           "api_user",
+		"base64Auth":        "base64_auth",
+		"targetEnvironment": "target_environment",
+		"outboundURL":       "outbound_url",
+		"qrCode":            "qr_code",
+		"source":            "source",
+	}
+	for in, want := range tests {
+		if got := camelToSnake(in); got != want {
+			t.Errorf("camelToSnake(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGenerateNamingStyle(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	tests := []struct {
+		style   string
+		want    []string
+		notWant []string
+	}{
+		{"", []string{"apiKey", "apiUser", "userId", "targetEnvironment", "base64Auth"}, []string{"api_key", "api_user"}},
+		{namingCamel, []string{"apiKey", "apiUser", "userId", "targetEnvironment", "base64Auth"}, []string{"api_key", "api_user"}},
+		{namingSnake, []string{"api_key", "api_user", "user_id", "target_environment", "base64_auth"}, []string{"apiKey", "apiUser", "qr_code"}},
+	}
+	for _, tt := range tests {
+		t.Run("style="+tt.style, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"NAMING_STYLE": tt.style})
+			fakeMTN(t, mtnSuccess(apiKey))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			var data map[string]json.RawMessage
+			decodeEnvelope(t, rec, &data)
+			for _, field := range tt.want {
+				if _, ok := data[field]; !ok {
+					t.Errorf("response lacks %q: %s", field, rec.Body)
+				}
+			}
+			for _, field := range tt.notWant {
+				if _, ok := data[field]; ok {
+					t.Errorf("response has %q: %s", field, rec.Body)
+				}
+			}
+			key := data["apiKey"]
+			if tt.style == namingSnake {
+				key = data["api_key"]
+			}
+			if string(key) != fmt.Sprintf("%q", apiKey) {
+				t.Errorf("api key = %s, want %q", key, apiKey)
+			}
+		})
+	}
+}
+
+func TestNamingStyleValidation(t *testing.T) {
+	t.Setenv("NAMING_STYLE", "kebab")
+	if _, err := loadConfig(); err == nil {
+		t.Error("loadConfig accepted NAMING_STYLE=kebab")
+	}
+}
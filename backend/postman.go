@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// postmanSchema is the Postman collection format we produce
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// PostmanRequest is the body of POST /api/postman. The subscription key is
+// resolved like /api/generate: primaryKey, secondaryKey, then MOMO_SUBSCRIPTION_KEY.
+type PostmanRequest struct {
+	APIUser           string `json:"apiUser"`
+	APIKey            string `json:"apiKey"`
+	PrimaryKey        string `json:"primaryKey"`
+	SecondaryKey      string `json:"secondaryKey"`
+	TargetEnvironment string `json:"targetEnvironment"`
+}
+
+// Postman v2.1 collection structure (only the parts we use)
+type (
+	postmanCollection struct {
+		Info     postmanInfo       `json:"info"`
+		Variable []postmanVariable `json:"variable"`
+		Item     []postmanItem     `json:"item"`
+	}
+	postmanInfo struct {
+		Name   string `json:"name"`
+		Schema string `json:"schema"`
+	}
+	postmanVariable struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	postmanItem struct {
+		Name    string         `json:"name"`
+		Event   []postmanEvent `json:"event,omitempty"`
+		Request postmanRequest `json:"request"`
+	}
+	postmanEvent struct {
+		Listen string        `json:"listen"`
+		Script postmanScript `json:"script"`
+	}
+	postmanScript struct {
+		Type string   `json:"type"`
+		Exec []string `json:"exec"`
+	}
+	postmanRequest struct {
+		Method string            `json:"method"`
+		Header []postmanVariable `json:"header"`
+		Auth   *postmanAuth      `json:"auth,omitempty"`
+		Body   *postmanBody      `json:"body,omitempty"`
+		URL    string            `json:"url"`
+	}
+	postmanAuth struct {
+		Type  string            `json:"type"`
+		Basic []postmanVariable `json:"basic,omitempty"`
+	}
+	postmanBody struct {
+		Mode string `json:"mode"`
+		Raw  string `json:"raw"`
+	}
+)
+
+// newPostmanCollection builds a collection that fetches an access token with the
+// given credentials and sends a sample request-to-pay with it. All credentials
+// are collection variables so they can be edited in one place.
+func newPostmanCollection(apiUser, apiKey, subscriptionKey, targetEnv string) postmanCollection {
+	subscriptionHeader := postmanVariable{Key: "Ocp-Apim-Subscription-Key", Value: "{{subscriptionKey}}"}
+
+	return postmanCollection{
+		Info: postmanInfo{Name: "MTN MoMo Collection (" + targetEnv + ")", Schema: postmanSchema},
+		Variable: []postmanVariable{
+			{Key: "baseUrl", Value: momoBaseURL},
+			{Key: "apiUser", Value: apiUser},
+			{Key: "apiKey", Value: apiKey},
+			{Key: "subscriptionKey", Value: subscriptionKey},
+			{Key: "targetEnvironment", Value: targetEnv},
+			{Key: "accessToken", Value: ""},
+		},
+		Item: []postmanItem{
+			{
+				Name: "Create access token",
+				// Saves the token so the request-to-pay below can use it straight away
+				Event: []postmanEvent{{
+					Listen: "test",
+					Script: postmanScript{Type: "text/javascript", Exec: []string{
+						`pm.collectionVariables.set("accessToken", pm.response.json().access_token);`,
+					}},
+				}},
+				Request: postmanRequest{
+					Method: "POST",
+					Header: []postmanVariable{subscriptionHeader},
+					Auth: &postmanAuth{Type: "basic", Basic: []postmanVariable{
+						{Key: "username", Value: "{{apiUser}}"},
+						{Key: "password", Value: "{{apiKey}}"},
+					}},
+					URL: "{{baseUrl}}/collection/token/",
+				},
+			},
+			{
+				Name: "Request to pay (sample)",
+				Request: postmanRequest{
+					Method: "POST",
+					Header: []postmanVariable{
+						{Key: "Authorization", Value: "Bearer {{accessToken}}"},
+						{Key: "X-Reference-Id", Value: "{{$guid}}"},
+						{Key: "X-Target-Environment", Value: "{{targetEnvironment}}"},
+						{Key: "Content-Type", Value: "application/json"},
+						subscriptionHeader,
+					},
+					Body: &postmanBody{Mode: "raw", Raw: `{
+  "amount": "5",
+  "currency": "EUR",
+  "externalId": "123456",
+  "payer": { "partyIdType": "MSISDN", "partyId": "46733123450" },
+  "payerMessage": "Test payment",
+  "payeeNote": "Test payment"
+}`},
+					URL: "{{baseUrl}}/collection/v1_0/requesttopay",
+				},
+			},
+		},
+	}
+}
+
+// handlePostmanCollection returns a downloadable Postman collection for a set of credentials
+func handlePostmanCollection(w http.ResponseWriter, r *http.Request) {
+	log.Println("=== Postman Collection Request Received ===")
+
+	var req PostmanRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("ERROR: Invalid request format - %v", err)
+		sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+	if req.APIUser == "" || req.APIKey == "" {
+		log.Println("ERROR: apiUser and apiKey are required for a Postman collection")
+		sendError(w, r, errInvalidRequest, "apiUser and apiKey are required", http.StatusBadRequest)
+		return
+	}
+
+	subscriptionKey, keySource := resolveSubscriptionKey(r.Context(), MomoKeyRequest{PrimaryKey: req.PrimaryKey, SecondaryKey: req.SecondaryKey})
+	if subscriptionKey == "" {
+		log.Println("ERROR: No subscription key in the request (primary or secondary) or in MOMO_SUBSCRIPTION_KEY")
+		sendError(w, r, errMissingSubscriptionKey, "no subscription key available from request or server configuration", http.StatusBadRequest)
+		return
+	}
+	log.Printf("INFO: Using subscription key from %s", keySource)
+
+	targetEnv := req.TargetEnvironment
+	if targetEnv == "" {
+		targetEnv = defaultTargetEnv
+	}
+
+	body, err := json.Marshal(newPostmanCollection(req.APIUser, req.APIKey, subscriptionKey, targetEnv))
+	if err != nil {
+		log.Printf("ERROR: Failed to encode Postman collection: %v", err)
+		sendError(w, r, errInternal, "Failed to build Postman collection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="momo-collection.postman_collection.json"`)
+	setSignature(w, body)
+	w.Write(body)
+	log.Printf("Postman collection generated for user %s", req.APIUser)
+}
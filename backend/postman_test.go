@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPostmanCollection(t *testing.T) {
+	const apiUser, apiKey = "5f8c2d2e-6a41-4b3b-9d7e-1c2f3a4b5c6d", "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	h := setupTest(t, nil)
+
+	body := fmt.Sprintf(`{"apiUser":%q,"apiKey":%q,"primaryKey":%q}`, apiUser, apiKey, testSubscriptionKey)
+	rec := doRequest(h, http.MethodPost, "/api/postman", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") || !strings.Contains(cd, ".postman_collection.json") {
+		t.Errorf("Content-Disposition = %q, want a collection attachment", cd)
+	}
+
+	// Decode loosely so the test checks the wire format rather than our own structs
+	var collection struct {
+		Info struct {
+			Name   string `json:"name"`
+			Schema string `json:"schema"`
+		} `json:"info"`
+		Variable []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"variable"`
+		Item []struct {
+			Name    string `json:"name"`
+			Request struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+				Header []struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				} `json:"header"`
+			} `json:"request"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &collection); err != nil {
+		t.Fatalf("decoding collection %s: %v", rec.Body, err)
+	}
+
+	if collection.Info.Schema != postmanSchema {
+		t.Errorf("schema = %q, want %q", collection.Info.Schema, postmanSchema)
+	}
+	vars := make(map[string]string)
+	for _, v := range collection.Variable {
+		vars[v.Key] = v.Value
+	}
+	for key, want := range map[string]string{
+		"apiUser":           apiUser,
+		"apiKey":            apiKey,
+		"subscriptionKey":   testSubscriptionKey,
+		"targetEnvironment": defaultTargetEnv,
+		"baseUrl":           momoBaseURL,
+	} {
+		if vars[key] != want {
+			t.Errorf("variable %s = %q, want %q", key, vars[key], want)
+		}
+	}
+
+	wantItems := []struct{ method, url string }{
+		{"POST", "{{baseUrl}}/collection/token/"},
+		{"POST", "{{baseUrl}}/collection/v1_0/requesttopay"},
+	}
+	if len(collection.Item) != len(wantItems) {
+		t.Fatalf("collection has %d items, want %d", len(collection.Item), len(wantItems))
+	}
+	for i, want := range wantItems {
+		req := collection.Item[i].Request
+		if req.Method != want.method || req.URL != want.url {
+			t.Errorf("item %d (%s) = %s %s, want %s %s", i, collection.Item[i].Name, req.Method, req.URL, want.method, want.url)
+		}
+		// Credentials are only ever referenced through variables
+		for _, header := range req.Header {
+			if strings.Contains(header.Value, apiKey) || strings.Contains(header.Value, testSubscriptionKey) {
+				t.Errorf("item %d header %s holds a literal credential", i, header.Key)
+			}
+		}
+	}
+}
+
+func TestPostmanCollectionRequiresCredentials(t *testing.T) {
+	h := setupTest(t, nil)
+	rec := doRequest(h, http.MethodPost, "/api/postman", fmt.Sprintf(`{"apiUser":"u","primaryKey":%q}`, testSubscriptionKey))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+	}
+	if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest {
+		t.Errorf("errorCode = %q, want %q", env.ErrorCode, errInvalidRequest)
+	}
+}
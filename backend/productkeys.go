@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProductKeysResponse is the data of a generate request with productKeys: one
+// result per product, each what /api/generate would have returned for that
+// product's subscription key on its own
+type ProductKeysResponse struct {
+	Succeeded int                        `json:"succeeded"`
+	Failed    int                        `json:"failed"`
+	Results   map[string]BatchItemResult `json:"results"`
+}
+
+// handleGenerateProducts creates credentials for every product→subscription key of
+// req.ProductKeys. Each product runs through handleGenerateKeys like a batch item,
+// with the rest of req unchanged, so one product's failure (or fallback) does not
+// affect the others.
+func handleGenerateProducts(w http.ResponseWriter, r *http.Request, req MomoKeyRequest) {
+	logger := reqLog(r.Context())
+	products := make([]string, 0, len(req.ProductKeys))
+	for product, key := range req.ProductKeys {
+		if !knownProduct(product) {
+			logger.Printf("ERROR: Unknown product %q in productKeys", product)
+			sendError(w, r, errInvalidRequest, fmt.Sprintf("productKeys products must be one of %s", strings.Join(momoProducts, ", ")), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(key) == "" {
+			logger.Printf("ERROR: Empty subscription key for product %s in productKeys", product)
+			sendError(w, r, errMissingSubscriptionKey, fmt.Sprintf("productKeys has no subscription key for %s", product), http.StatusBadRequest)
+			return
+		}
+		products = append(products, product)
+	}
+	sort.Strings(products)
+	if req.ReferenceID != "" {
+		logger.Println("ERROR: referenceId given with productKeys")
+		sendError(w, r, errInvalidRequest, "referenceId cannot be combined with productKeys, each product gets its own user", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("format") == "env" {
+		logger.Println("ERROR: format=env requested with productKeys")
+		sendError(w, r, errInvalidRequest, "format=env cannot be combined with productKeys", http.StatusBadRequest)
+		return
+	}
+
+	// Products run concurrently (bounded by the shared outbound semaphore) so the
+	// whole call fits the GENERATE_TIMEOUT of a single request
+	logger.Printf("Generating credentials for %d products: %s", len(products), strings.Join(products, ", "))
+	results := make([]BatchItemResult, len(products))
+	var wg sync.WaitGroup
+	for i, product := range products {
+		item := req
+		item.ProductKeys = nil
+		item.PrimaryKey = req.ProductKeys[product]
+		item.SecondaryKey = ""
+		item.Product = product
+		body, err := json.Marshal(item)
+		if err != nil {
+			logger.Printf("ERROR: Failed to encode the %s request: %v", product, err)
+			results[i] = BatchItemResult{Status: http.StatusInternalServerError, Message: "Failed to build product request", ErrorCode: errInternal}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, product string) {
+			defer wg.Done()
+			results[i] = runBatchItem(r, body)
+			logger.Printf("Product %s: success=%t status=%d %s", product, results[i].Success, results[i].Status, results[i].ErrorCode)
+		}(i, product)
+	}
+	wg.Wait()
+	if err := r.Context().Err(); err != nil {
+		logger.Printf("WARNING: Per-product generation cancelled - %v", err)
+		return
+	}
+
+	resp := ProductKeysResponse{Results: make(map[string]BatchItemResult, len(products))}
+	for i, product := range products {
+		resp.Results[product] = results[i]
+		if results[i].Success {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	logger.Printf("Per-product generation complete: %d succeeded, %d failed", resp.Succeeded, resp.Failed)
+	sendResponse(w, r, true, "Credentials generated per product", resp, http.StatusOK)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// badSubscriptionKey is a subscription key the MTN stub of these tests rejects
+const badSubscriptionKey = "ffffffffffffffffffffffffffffffff"
+
+func TestGenerateProductKeysOneInvalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		fallback      string
+		wantSucceeded int
+	}{
+		// Without fallback the invalid key fails its product only
+		{"fallback disabled", "none", 1},
+		// With fallback its product gets local credentials, flagged as such
+		{"fallback enabled", "sandbox", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"FALLBACK_TARGET_ENVS": tt.fallback})
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Ocp-Apim-Subscription-Key") == badSubscriptionKey {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"statusCode":401,"message":"Access denied due to invalid subscription key."}`)
+					return
+				}
+				success(w, r)
+			}))
+
+			body := fmt.Sprintf(`{"productKeys":{"collection":%q,"disbursement":%q}}`, testSubscriptionKey, badSubscriptionKey)
+			rec := doRequest(h, http.MethodPost, "/api/generate", body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 with per-product results (body %s)", rec.Code, rec.Body)
+			}
+			var resp ProductKeysResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.Succeeded != tt.wantSucceeded || resp.Succeeded+resp.Failed != 2 || len(resp.Results) != 2 {
+				t.Fatalf("succeeded %d, failed %d, %d results; want %d of 2 succeeded", resp.Succeeded, resp.Failed, len(resp.Results), tt.wantSucceeded)
+			}
+
+			good := resp.Results["collection"]
+			if !good.Success || good.Status != http.StatusCreated || good.Source != sourceMTN {
+				t.Errorf("collection = %+v, want MTN credentials created", good)
+			}
+			var data MomoKeyResponse
+			if err := json.Unmarshal(good.Data, &data); err != nil {
+				t.Fatalf("collection data: %v", err)
+			}
+			if data.APIKey == "" {
+				t.Error("collection data has no API key")
+			}
+
+			bad := resp.Results["disbursement"]
+			if tt.wantSucceeded == 1 {
+				if bad.Success || bad.ErrorCode != errMTNAuthFailed || len(bad.Data) != 0 {
+					t.Errorf("disbursement = %+v, want a %s failure without credentials", bad, errMTNAuthFailed)
+				}
+				return
+			}
+			if !bad.Success || bad.Source != sourceLocal || bad.FallbackReason != errMTNAuthFailed {
+				t.Errorf("disbursement = %+v, want local credentials with fallbackReason %s", bad, errMTNAuthFailed)
+			}
+		})
+	}
+}
+
+func TestGenerateProductKeysValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode string
+	}{
+		{"unknown product", `{"productKeys":{"payments":%q}}`, errInvalidRequest},
+		{"empty key", `{"productKeys":{"collection":%q,"remittance":""}}`, errMissingSubscriptionKey},
+		{"with referenceId", `{"productKeys":{"collection":%q},"referenceId":"3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b"}`, errInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(tt.body, testSubscriptionKey))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body)
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != tt.wantCode {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, tt.wantCode)
+			}
+		})
+	}
+}
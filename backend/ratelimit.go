@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitWindow is the fixed window RATE_LIMIT_PER_MINUTE is counted over
+const rateLimitWindow = time.Minute
+
+// rateLimiter is a per-client-IP fixed-window request limiter
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	windows map[string]*rateWindow
+	now     func() time.Time
+}
+
+// rateWindow counts one client's requests in the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int) *rateLimiter {
+	return &rateLimiter{limit: limit, windows: make(map[string]*rateWindow), now: time.Now}
+}
+
+// allow counts a request from client and reports whether it is within the limit,
+// along with the requests remaining and when the current window resets
+func (l *rateLimiter) allow(client string) (ok bool, remaining int, reset time.Time) {
+	return l.allowN(client, 1)
+}
+
+// allowN is allow for n requests at once: they are counted only if all of them fit
+func (l *rateLimiter) allowN(client string, n int) (ok bool, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= rateLimitWindow {
+			delete(l.windows, key)
+		}
+	}
+
+	w, found := l.windows[client]
+	if !found {
+		w = &rateWindow{start: now}
+		l.windows[client] = w
+	}
+	reset = w.start.Add(rateLimitWindow)
+	if w.count+n > l.limit {
+		return false, l.limit - w.count, reset
+	}
+	w.count += n
+	return true, l.limit - w.count, reset
+}
+
+// rateLimitClient identifies the client a request is counted against: its remote IP.
+// Forwarding headers are not trusted, as they are set by the client.
+func rateLimitClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withRateLimit rejects requests beyond the limiter's budget with 429 (see chargeRateLimit).
+// A nil limiter disables rate limiting.
+func withRateLimit(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if chargeRateLimit(w, r, l, 1) {
+			next(w, r)
+		}
+	}
+}
+
+// chargeRateLimit counts n requests from r's client against l, answering 429 and
+// reporting false when they do not all fit. Every response carries X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset (Unix time in seconds when the window
+// resets) so well-behaved clients can self-throttle. A nil l allows everything.
+func chargeRateLimit(w http.ResponseWriter, r *http.Request, l *rateLimiter, n int) bool {
+	if l == nil {
+		return true
+	}
+	ok, remaining, reset := l.allowN(rateLimitClient(r), n)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if !ok {
+		retryAfter := int(time.Until(reset).Seconds()) + 1
+		log.Printf("WARNING: Rate limit exceeded for client %s (%d requests asked, %d remaining)", rateLimitClient(r), n, remaining)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		sendError(w, r, errRateLimited, "Rate limit exceeded, retry after the window resets", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// generateLimiter limits /api/generate per client IP when RATE_LIMIT_PER_MINUTE is set
+var generateLimiter *rateLimiter
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGenerateRateLimitHeaders(t *testing.T) {
+	h := setupTest(t, map[string]string{"RATE_LIMIT_PER_MINUTE": "3"})
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+
+	tests := []struct {
+		wantStatus    int
+		wantRemaining string
+	}{
+		{http.StatusCreated, "2"},
+		{http.StatusCreated, "1"},
+		{http.StatusCreated, "0"},
+		{http.StatusTooManyRequests, "0"},
+	}
+	for i, tt := range tests {
+		rec := doRequest(h, http.MethodPost, "/api/generate", body)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("request %d: status = %d, want %d (body %s)", i+1, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 3", i+1, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %s", i+1, got, tt.wantRemaining)
+		}
+		reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || time.Until(time.Unix(reset, 0)) > rateLimitWindow {
+			t.Errorf("request %d: X-RateLimit-Reset = %q, want a Unix time within the window", i+1, rec.Header().Get("X-RateLimit-Reset"))
+		}
+		if tt.wantStatus != http.StatusTooManyRequests {
+			continue
+		}
+		if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errRateLimited {
+			t.Errorf("errorCode = %q, want %q", env.ErrorCode, errRateLimited)
+		}
+		if retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 61 {
+			t.Errorf("Retry-After = %q, want 1-61 seconds", rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance       time.Duration
+		client        string
+		wantOK        bool
+		wantRemaining int
+	}{
+		{0, "192.0.2.1", true, 1},
+		{10 * time.Second, "192.0.2.1", true, 0},
+		{10 * time.Second, "192.0.2.1", false, 0},
+		{0, "192.0.2.2", true, 1},                // Other clients have their own budget
+		{40 * time.Second, "192.0.2.1", true, 1}, // A minute after the first request, the window resets
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		ok, remaining, _ := l.allow(s.client)
+		if ok != s.wantOK || remaining != s.wantRemaining {
+			t.Errorf("step %d: allow(%s) = %t, %d, want %t, %d", i+1, s.client, ok, remaining, s.wantOK, s.wantRemaining)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	called := false
+	h := withRateLimit(nil, func(w http.ResponseWriter, r *http.Request) { called = true })
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/api/generate", nil))
+	if !called || rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("nil limiter: handler called = %t, headers %v", called, rec.Header())
+	}
+}
+
+func TestBatchChargesRateLimitPerItem(t *testing.T) {
+	h := setupTest(t, map[string]string{"RATE_LIMIT_PER_MINUTE": "5"})
+	mtn, creates := countUserCreates(mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	fakeMTN(t, mtn)
+
+	tests := []struct {
+		path          string
+		body          string
+		wantStatus    int
+		wantRemaining string
+		wantCreates   int32
+	}{
+		{"/api/generate/batch", batchBody(3), http.StatusOK, "2", 3},
+		{"/api/generate/batch", batchBody(3), http.StatusTooManyRequests, "2", 3},
+		{"/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey), http.StatusCreated, "1", 4},
+		{"/api/generate/batch", batchBody(2), http.StatusTooManyRequests, "1", 4},
+		{"/api/generate/batch", batchBody(1), http.StatusOK, "0", 5},
+		{"/api/generate/batch", batchBody(1), http.StatusTooManyRequests, "0", 5},
+	}
+	for i, tt := range tests {
+		rec := doRequest(h, http.MethodPost, tt.path, tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("request %d: status = %d, want %d (body %s)", i+1, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %s", i+1, got, tt.wantRemaining)
+		}
+		if got := creates.Load(); got != tt.wantCreates {
+			t.Errorf("request %d: MTN users created = %d, want %d", i+1, got, tt.wantCreates)
+		}
+		if tt.wantStatus == http.StatusTooManyRequests {
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errRateLimited {
+				t.Errorf("request %d: errorCode = %q, want %q", i+1, env.ErrorCode, errRateLimited)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactedPlaceholder replaces secret values in anything we log
+const redactedPlaceholder = "[REDACTED]"
+
+// secretFieldPattern matches JSON fields that carry secrets, whatever their value.
+// Names match in camelCase and in the snake_case of NAMING_STYLE=snake.
+var secretFieldPattern = regexp.MustCompile(`(?i)"(api_?key|encrypted_?api_?key|base64_?auth|test_?command|primary_?key|secondary_?key|subscription_?key|Ocp-Apim-Subscription-Key|access_?token|Authorization)"\s*:\s*"[^"]*"`)
+
+// secretEnvLinePattern matches secret-bearing lines of a ?format=env credentials file
+var secretEnvLinePattern = regexp.MustCompile(`(?m)^(MOMO_API_KEY|MOMO_SUBSCRIPTION_KEY|MOMO_BASE64_AUTH)=.*$`)
+
+// secretHeaderPattern matches secret header values written out as text, such as
+// in the test curl command logged under LOG_TEST_COMMAND
+var secretHeaderPattern = regexp.MustCompile(`(?i)(Authorization: Basic |Ocp-Apim-Subscription-Key: )[A-Za-z0-9+/=_-]+`)
+
+// redactSecrets removes known secret values and secret-bearing JSON fields and headers from s
+func redactSecrets(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		// Very short values would redact unrelated text, and are not real secrets anyway
+		if len(secret) >= 4 {
+			s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+		}
+	}
+	s = secretEnvLinePattern.ReplaceAllString(s, "$1="+redactedPlaceholder)
+	s = secretHeaderPattern.ReplaceAllString(s, "${1}"+redactedPlaceholder)
+	return secretFieldPattern.ReplaceAllString(s, `"$1":"`+redactedPlaceholder+`"`)
+}
+
+// Modes for logging MTN error response bodies (MTN_ERROR_BODY_LOG)
+const (
+	errorBodyFull     = "full"     // Log the whole (redacted) body
+	errorBodyTruncate = "truncate" // Log the (redacted) body up to MTN_ERROR_BODY_LOG_BYTES
+	errorBodyOmit     = "omit"     // Log only the status code
+)
+
+// formatErrorBody shapes an MTN error response body for logs and error messages
+// according to the configured mode. Redaction is applied in every mode.
+func formatErrorBody(body []byte, secrets ...string) string {
+	if cfg.ErrorBodyLogMode == errorBodyOmit {
+		return ""
+	}
+
+	redacted := redactSecrets(string(body), secrets...)
+	if cfg.ErrorBodyLogMode == errorBodyTruncate && len(redacted) > cfg.ErrorBodyLogBytes {
+		return fmt.Sprintf("%s...(truncated, %d bytes total)", redacted[:cfg.ErrorBodyLogBytes], len(redacted))
+	}
+	return redacted
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFormatErrorBodyTruncation(t *testing.T) {
+	body := []byte(`{"code":"RESOURCE_ALREADY_EXIST","message":"` + strings.Repeat("x", 200) + `"}`)
+	tests := []struct {
+		name      string
+		mode      string
+		limit     int
+		wantShown int // Characters of the body kept; -1 for all of it
+	}{
+		{"truncated to the limit", errorBodyTruncate, 64, 64},
+		{"limit of one byte", errorBodyTruncate, 1, 1},
+		{"shorter than the limit", errorBodyTruncate, 4096, -1},
+		{"full mode ignores the limit", errorBodyFull, 64, -1},
+		{"omit mode", errorBodyOmit, 64, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, func(c *Config) {
+				c.ErrorBodyLogMode = tt.mode
+				c.ErrorBodyLogBytes = tt.limit
+			})
+
+			got := formatErrorBody(body)
+			switch tt.wantShown {
+			case -1:
+				if got != string(body) {
+					t.Errorf("formatErrorBody = %q, want the whole body", got)
+				}
+			case 0:
+				if got != "" {
+					t.Errorf("formatErrorBody = %q, want it omitted", got)
+				}
+			default:
+				kept, suffix, ok := strings.Cut(got, "...(truncated")
+				if !ok {
+					t.Fatalf("formatErrorBody = %q, want a truncation marker", got)
+				}
+				if len(kept) != tt.wantShown || kept != string(body[:tt.wantShown]) {
+					t.Errorf("kept %d bytes %q, want the first %d bytes", len(kept), kept, tt.wantShown)
+				}
+				if want := fmt.Sprintf(", %d bytes total)", len(body)); suffix != want {
+					t.Errorf("marker suffix = %q, want %q", suffix, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactSecretFieldNames(t *testing.T) {
+	fields := []string{
+		"apiKey", "api_key", "encryptedApiKey", "encrypted_api_key", "base64Auth", "base64_auth",
+		"testCommand", "test_command", "primaryKey", "primary_key", "secondaryKey", "secondary_key",
+		"subscriptionKey", "subscription_key", "Ocp-Apim-Subscription-Key", "access_token", "Authorization",
+	}
+	for _, field := range fields {
+		in := fmt.Sprintf(`{"userId":"u-1",%q: "s3cret-value"}`, field)
+		want := fmt.Sprintf(`{"userId":"u-1","%s":%q}`, field, redactedPlaceholder)
+		if got := redactSecrets(in); got != want {
+			t.Errorf("redactSecrets(%s) = %s, want %s", in, got, want)
+		}
+	}
+	// A non-secret field is left alone
+	if in := `{"callback_host":"example.com"}`; redactSecrets(in) != in {
+		t.Errorf("redactSecrets changed %s", in)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ReferenceIDFormat is the contract for the X-Reference-Id that identifies a new API
+// user: it generates IDs for users the server creates and validates IDs supplied by
+// clients as referenceId. MTN requires a UUID (version 4) today; markets that accept
+// other formats can register an implementation from an init function with
+// registerReferenceIDFormat and select it with REFERENCE_ID_FORMAT.
+type ReferenceIDFormat interface {
+	New() string
+	Validate(id string) error
+}
+
+// uuidV4Format is MTN's documented format: a version 4 UUID in canonical form
+type uuidV4Format struct{}
+
+func (uuidV4Format) New() string { return uuid.New().String() }
+
+func (uuidV4Format) Validate(id string) error {
+	u, err := uuid.Parse(id)
+	if err != nil || u.String() != strings.ToLower(id) {
+		return fmt.Errorf("must be a UUID such as %s", uuid.Nil)
+	}
+	if u.Version() != 4 {
+		return fmt.Errorf("must be a version 4 UUID, got version %d", u.Version())
+	}
+	return nil
+}
+
+// uuidAnyFormat accepts a UUID of any version in canonical form, for markets that do
+// not check the version. Generated IDs are still version 4.
+type uuidAnyFormat struct{}
+
+func (uuidAnyFormat) New() string { return uuid.New().String() }
+
+func (uuidAnyFormat) Validate(id string) error {
+	if u, err := uuid.Parse(id); err != nil || u.String() != strings.ToLower(id) {
+		return fmt.Errorf("must be a UUID such as %s", uuid.Nil)
+	}
+	return nil
+}
+
+// referenceIDFormats are the formats selectable with REFERENCE_ID_FORMAT, by name
+var referenceIDFormats = map[string]ReferenceIDFormat{
+	"uuid4": uuidV4Format{},
+	"uuid":  uuidAnyFormat{},
+}
+
+// registerReferenceIDFormat makes a format selectable by name. It must be called
+// before configuration is loaded, i.e. from an init function.
+func registerReferenceIDFormat(name string, f ReferenceIDFormat) {
+	referenceIDFormats[name] = f
+}
+
+// referenceIDFormatNames lists the registered format names, for config errors
+func referenceIDFormatNames() []string {
+	names := make([]string, 0, len(referenceIDFormats))
+	for name := range referenceIDFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// referenceIDFormat is the ReferenceIDFormat new API user IDs are generated and validated with
+var referenceIDFormat ReferenceIDFormat = uuidV4Format{}
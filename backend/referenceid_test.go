@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	testReferenceIDv4 = "3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b"
+	testReferenceIDv1 = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+)
+
+func TestReferenceIDFormats(t *testing.T) {
+	tests := []struct {
+		format  string
+		id      string
+		wantErr bool
+	}{
+		{"uuid4", testReferenceIDv4, false},
+		{"uuid4", strings.ToUpper(testReferenceIDv4), false},
+		{"uuid4", testReferenceIDv1, true},
+		{"uuid4", strings.ReplaceAll(testReferenceIDv4, "-", ""), true},
+		{"uuid4", "{" + testReferenceIDv4 + "}", true},
+		{"uuid4", "urn:uuid:" + testReferenceIDv4, true},
+		{"uuid4", "not-a-uuid", true},
+		{"uuid", testReferenceIDv4, false},
+		{"uuid", testReferenceIDv1, false},
+		{"uuid", strings.ReplaceAll(testReferenceIDv1, "-", ""), true},
+		{"uuid", "not-a-uuid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format+"/"+tt.id, func(t *testing.T) {
+			err := referenceIDFormats[tt.format].Validate(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) = %v, wantErr %t", tt.id, err, tt.wantErr)
+			}
+		})
+	}
+
+	// Generated IDs always satisfy their own format
+	for name, f := range referenceIDFormats {
+		if id := f.New(); f.Validate(id) != nil {
+			t.Errorf("%s: generated ID %q fails its own validation", name, id)
+		}
+	}
+}
+
+func TestGenerateReferenceID(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		referenceID string
+		wantStatus  int
+	}{
+		{"valid v4", "uuid4", testReferenceIDv4, http.StatusCreated},
+		{"v1 rejected by default", "uuid4", testReferenceIDv1, http.StatusBadRequest},
+		{"v1 allowed by the uuid format", "uuid", testReferenceIDv1, http.StatusCreated},
+		{"not a UUID", "uuid4", "user-42", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"REFERENCE_ID_FORMAT": tt.format})
+			var sentID atomic.Value
+			var calls atomic.Int32
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if strings.HasSuffix(r.URL.Path, "/apiuser") {
+					sentID.Store(r.Header.Get("X-Reference-Id"))
+				}
+				success(w, r)
+			}))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q,"referenceId":%q}`, testSubscriptionKey, tt.referenceID))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errInvalidRequest || !strings.Contains(env.Message, "referenceId") {
+					t.Errorf("error = %s %q, want %s about referenceId", env.ErrorCode, env.Message, errInvalidRequest)
+				}
+				if n := calls.Load(); n != 0 {
+					t.Errorf("MTN called %d times for an invalid referenceId", n)
+				}
+				return
+			}
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			if got, _ := sentID.Load().(string); got != tt.referenceID {
+				t.Errorf("X-Reference-Id = %q, want the supplied %q", got, tt.referenceID)
+			}
+			if resp.UserID != tt.referenceID {
+				t.Errorf("userId = %q, want the supplied %q", resp.UserID, tt.referenceID)
+			}
+		})
+	}
+}
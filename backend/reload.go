@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// hotReloadable are the Config fields /api/admin/reload applies to the running server.
+// They are all consumed when the handler chain is built, so swapping in a handler
+// built from the new config applies them without touching open connections. Every
+// other setting (listen address, server timeouts, the credential store...) only
+// changes on restart.
+var hotReloadable = map[string]bool{
+	"RouteTimeout":       true,
+	"MaxRequestDuration": true,
+	"BodyReadTimeout":    true,
+	"MaxBodyBytes":       true,
+	"GenerateTimeout":    true,
+	"RateLimitPerMinute": true,
+	"CORSAllowedOrigins": true,
+	"DebugHTTP":          true,
+	"AccessLogFormat":    true,
+}
+
+// ReloadResult is the response of POST /api/admin/reload. Settings are named by
+// their Config field.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requiresRestart"`
+}
+
+// liveCfg is the config the current handler chain was built from. cfg keeps the
+// startup values; request-time reads of hot-reloadable settings use liveConfig.
+var liveCfg atomic.Pointer[Config]
+
+// liveConfig returns the config the server is currently running with
+func liveConfig() *Config {
+	if c := liveCfg.Load(); c != nil {
+		return c
+	}
+	return &cfg
+}
+
+// liveHandler is the handler chain the server dispatches to, swapped on reload
+var liveHandler atomic.Value
+
+// serveLive dispatches to the current handler chain
+func serveLive(w http.ResponseWriter, r *http.Request) {
+	liveHandler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// reloadMu serializes reloads
+var reloadMu sync.Mutex
+
+// handleReload re-reads CONFIG_FILE and the environment and swaps in a handler chain
+// built from the hot-reloadable settings of the result. An invalid configuration is
+// rejected and the running one kept. It must be guarded by requireAdminToken.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	log.Println("=== Configuration Reload Requested ===")
+	next, err := loadConfig()
+	if err != nil {
+		log.Printf("ERROR: Configuration reload rejected, keeping the running configuration: %v", err)
+		sendError(w, r, errInvalidConfig, fmt.Sprintf("Invalid configuration: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	current := liveConfig()
+	applied := *current
+	result := ReloadResult{Applied: []string{}, RequiresRestart: []string{}}
+	cv, nv, av := reflect.ValueOf(*current), reflect.ValueOf(next), reflect.ValueOf(&applied).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		name := cv.Type().Field(i).Name
+		if reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if hotReloadable[name] {
+			av.Field(i).Set(nv.Field(i))
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RequiresRestart = append(result.RequiresRestart, name)
+		}
+	}
+
+	if len(result.Applied) > 0 {
+		if applied.RateLimitPerMinute != current.RateLimitPerMinute {
+			generateLimiter = nil
+			if applied.RateLimitPerMinute > 0 {
+				generateLimiter = newRateLimiter(applied.RateLimitPerMinute)
+			}
+		}
+		liveHandler.Store(newHandler(&applied))
+		liveCfg.Store(&applied)
+		log.Printf("SUCCESS: Configuration reloaded, applied %v", result.Applied)
+	} else {
+		log.Println("INFO: Configuration reloaded, no hot-reloadable setting changed")
+	}
+	if len(result.RequiresRestart) > 0 {
+		log.Printf("WARNING: Changed settings not applied until restart: %v", result.RequiresRestart)
+	}
+	sendResponse(w, r, true, "Configuration reloaded", result, http.StatusOK)
+}
+
+// configFileKeys are the variables the environment got from CONFIG_FILE, so a
+// reload can tell them from real environment variables and drop removed ones
+var configFileKeys = map[string]bool{}
+
+// applyConfigFile sets the KEY=VALUE lines of path as environment variables. Blank
+// lines and lines starting with # are skipped, and values may be quoted. Variables
+// set in the real environment are left alone.
+func applyConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for key := range configFileKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(configFileKeys, key)
+		}
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !configFileKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		configFileKeys[key] = true
+	}
+	return nil
+}
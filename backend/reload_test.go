@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// reload calls POST /api/admin/reload through h
+func reload(h http.Handler, headers ...string) *httptest.ResponseRecorder {
+	return doRequest(h, http.MethodPost, "/api/admin/reload", "", append([]string{"Authorization", "Bearer " + testAdminToken}, headers...)...)
+}
+
+func TestReloadedTimeoutTakesEffect(t *testing.T) {
+	setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken, "GENERATE_TIMEOUT": "0"})
+	h := http.HandlerFunc(serveLive)
+	logs := captureLogs(t)
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(300 * time.Millisecond):
+		}
+		success(w, r)
+	}))
+	body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+
+	if rec := doRequest(h, http.MethodPost, "/api/generate", body); rec.Code != http.StatusCreated {
+		t.Fatalf("before reload: status = %d, want 201 (body %s)", rec.Code, rec.Body)
+	}
+
+	t.Setenv("GENERATE_TIMEOUT", "100ms")
+	t.Setenv("PORT", "9999")
+	rec := reload(h)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload: status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	var result ReloadResult
+	decodeEnvelope(t, rec, &result)
+	if !contains(result.Applied, "GenerateTimeout") || contains(result.Applied, "Port") {
+		t.Errorf("applied = %v, want GenerateTimeout and not Port", result.Applied)
+	}
+	if !contains(result.RequiresRestart, "Port") {
+		t.Errorf("requiresRestart = %v, want Port", result.RequiresRestart)
+	}
+
+	rec = doRequest(h, http.MethodPost, "/api/generate", body)
+	// The abandoned handler falls back to local generation; let it finish before the
+	// next test rewires the globals it reads
+	defer waitForLog(t, logs, "=== API Key Generation Request Completed ===")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("after reload: status = %d, want 503 from the reloaded 100ms timeout (body %s)", rec.Code, rec.Body)
+	}
+	if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errRequestTimeout {
+		t.Errorf("errorCode = %q, want %q", env.ErrorCode, errRequestTimeout)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	for _, lang := range []string{"en", "fr"} {
+		t.Run(lang, func(t *testing.T) {
+			setupTest(t, map[string]string{"ADMIN_API_TOKEN": testAdminToken, "GENERATE_TIMEOUT": "1s"})
+			h := http.HandlerFunc(serveLive)
+
+			t.Setenv("GENERATE_TIMEOUT", "soon")
+			rec := reload(h, "Accept-Language", lang)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want 422 (body %s)", rec.Code, rec.Body)
+			}
+			env := decodeEnvelope(t, rec, nil)
+			if env.ErrorCode != errInvalidConfig {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, errInvalidConfig)
+			}
+			if lang == "fr" && env.Message != frenchMessages[errInvalidConfig] {
+				t.Errorf("French message = %q, want %q", env.Message, frenchMessages[errInvalidConfig])
+			}
+			if got := liveConfig().GenerateTimeout; got != time.Second {
+				t.Errorf("live GENERATE_TIMEOUT = %s after a rejected reload, want the running 1s", got)
+			}
+		})
+	}
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
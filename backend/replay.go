@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// ReplayResult is printed to stdout, one JSON line per replayed capture entry
+type ReplayResult struct {
+	Line              int      `json:"line"`
+	CallbackHost      string   `json:"callbackHost"`
+	Product           string   `json:"product,omitempty"`
+	TargetEnvironment string   `json:"targetEnvironment"`
+	DryRun            bool     `json:"dryRun,omitempty"`
+	APIUser           string   `json:"apiUser,omitempty"`
+	APIKeys           []string `json:"apiKeys,omitempty"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// capturedLine is a capture entry with its line number in the capture file
+type capturedLine struct {
+	line  int
+	entry CapturedRequest
+}
+
+// runReplay implements the replay subcommand: every entry of a REQUEST_CAPTURE file
+// is re-issued against MTN with the server subscription key, so a fresh subscription can be
+// provisioned with the same callback hosts. It returns the process exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print the generations that would be issued without calling MTN")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: momo-key-generator replay [-dry-run] <capture file, or - for stdin>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		log.Printf("ERROR: Invalid configuration: %v", err)
+		return 1
+	}
+
+	entries, err := readCaptureFile(fs.Arg(0))
+	if err != nil {
+		log.Printf("ERROR: Failed to read capture %s: %v", fs.Arg(0), err)
+		return 1
+	}
+	log.Printf("=== Replaying %d captured generation requests (dry run: %t) ===", len(entries), *dryRun)
+
+	var subscriptionKey string
+	if !*dryRun {
+		subscriptionKeyProvider = newSecretProvider(cfg)
+		if subscriptionKey = serverSubscriptionKey(context.Background()); subscriptionKey == "" {
+			log.Println("ERROR: A server subscription key (MOMO_SUBSCRIPTION_KEY or SECRET_PROVIDER) is required to replay against MTN MoMo")
+			return 1
+		}
+		initMomoSemaphore(cfg.MaxConcurrency)
+		initMomoHTTPClient(cfg)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	failed := 0
+	for _, c := range entries {
+		result := replayEntry(context.Background(), c, subscriptionKey, *dryRun)
+		if result.Error != "" {
+			failed++
+		}
+		if err := out.Encode(result); err != nil {
+			log.Printf("ERROR: Failed to write replay result: %v", err)
+			return 1
+		}
+	}
+
+	log.Printf("Replay finished: %d of %d entries failed", failed, len(entries))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replayEntry re-issues one captured generation, or only describes it in a dry run
+func replayEntry(ctx context.Context, c capturedLine, subscriptionKey string, dryRun bool) ReplayResult {
+	result := ReplayResult{
+		Line:              c.line,
+		CallbackHost:      c.entry.CallbackHost,
+		Product:           c.entry.Product,
+		TargetEnvironment: c.entry.TargetEnvironment,
+		DryRun:            dryRun,
+	}
+	keyCount := c.entry.KeyCount
+	if keyCount < 1 || keyCount > maxKeysPerUser {
+		keyCount = 1
+	}
+	if dryRun {
+		log.Printf("DRY RUN: Would create an API user for callback host %s with %d key(s)", c.entry.CallbackHost, keyCount)
+		return result
+	}
+
+	apiUser, _, err := createAPIUser(ctx, subscriptionKey, c.entry.CallbackHost, "")
+	if err != nil {
+		log.Printf("ERROR: Replay of line %d failed to create API user: %v", c.line, err)
+		result.Error = err.Error()
+		return result
+	}
+	result.APIUser = apiUser
+	for i := 0; i < keyCount; i++ {
+		apiKey, _, err := createAPIKey(ctx, subscriptionKey, apiUser)
+		if err != nil {
+			log.Printf("ERROR: Replay of line %d failed to create API key: %v", c.line, err)
+			result.Error = err.Error()
+			return result
+		}
+		result.APIKeys = append(result.APIKeys, apiKey)
+	}
+	log.Printf("SUCCESS: Replayed line %d as API user %s", c.line, apiUser)
+	return result
+}
+
+// readCaptureFile reads the capture entries from path ("-" for stdin)
+func readCaptureFile(path string) ([]capturedLine, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return readCapture(r)
+}
+
+// readCapture parses a capture log, skipping blank lines
+func readCapture(r io.Reader) ([]capturedLine, error) {
+	var entries []capturedLine
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry CapturedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if entry.CallbackHost == "" {
+			return nil, fmt.Errorf("line %d: missing callbackHost", line)
+		}
+		entries = append(entries, capturedLine{line: line, entry: entry})
+	}
+	return entries, scanner.Err()
+}
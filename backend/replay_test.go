@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdoutOf runs f with os.Stdout redirected, returning what it printed
+func captureStdoutOf(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func() { os.Stdout = saved }()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestRequestCaptureAndDryRunReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	sink, err := openRequestCapture(path)
+	if err != nil {
+		t.Fatalf("openRequestCapture: %v", err)
+	}
+	requestCapture = sink
+	t.Cleanup(func() {
+		requestCapture = nil
+		sink.Close()
+	})
+
+	generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"example.com","product":"collection","keyCount":2}`, testSubscriptionKey))
+	generate(t, h, fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"example.org"}`, testSubscriptionKey))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading capture: %v", err)
+	}
+	if strings.Contains(string(data), testSubscriptionKey) {
+		t.Errorf("capture holds the subscription key:\n%s", data)
+	}
+	entries, err := readCapture(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("readCapture: %v", err)
+	}
+	want := []CapturedRequest{
+		{CallbackHost: "example.com", Product: "collection", TargetEnvironment: defaultTargetEnv, KeyCount: 2},
+		{CallbackHost: "example.org", TargetEnvironment: defaultTargetEnv, KeyCount: 1},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("captured %d entries, want %d:\n%s", len(entries), len(want), data)
+	}
+	for i, e := range entries {
+		if e.entry.Timestamp.IsZero() {
+			t.Errorf("entry %d has no timestamp", i+1)
+		}
+		e.entry.Timestamp = want[i].Timestamp
+		if e.entry != want[i] || e.line != i+1 {
+			t.Errorf("entry %d = line %d %+v, want %+v", i+1, e.line, e.entry, want[i])
+		}
+	}
+
+	var code int
+	out := captureStdoutOf(t, func() { code = runReplay([]string{"-dry-run", path}) })
+	if code != 0 {
+		t.Fatalf("dry-run replay exit code = %d", code)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	var results []ReplayResult
+	for scanner.Scan() {
+		var r ReplayResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("replay output line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 2 || results[0].CallbackHost != "example.com" || results[1].CallbackHost != "example.org" {
+		t.Fatalf("replay results = %+v", results)
+	}
+	for _, r := range results {
+		if !r.DryRun || r.APIUser != "" || len(r.APIKeys) != 0 {
+			t.Errorf("dry-run result %+v created credentials", r)
+		}
+	}
+}
+
+func TestReplayEntry(t *testing.T) {
+	setupTest(t, nil)
+	var creates int
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/apiuser") {
+			creates++
+		}
+		success(w, r)
+	}))
+	entry := capturedLine{line: 3, entry: CapturedRequest{CallbackHost: "example.com", TargetEnvironment: defaultTargetEnv, KeyCount: 2}}
+
+	if r := replayEntry(context.Background(), entry, testSubscriptionKey, true); creates != 0 || r.APIUser != "" {
+		t.Errorf("dry run called MTN %d times, result %+v", creates, r)
+	}
+	r := replayEntry(context.Background(), entry, testSubscriptionKey, false)
+	if creates != 1 || r.APIUser == "" || len(r.APIKeys) != 2 || r.Error != "" || r.Line != 3 {
+		t.Errorf("replay called MTN %d times, result %+v", creates, r)
+	}
+}
+
+func TestReadCaptureErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid json":         "{\"callbackHost\":\"example.com\"}\nnot json\n",
+		"missing callbackHost": "{\"targetEnvironment\":\"sandbox\"}\n",
+	}
+	for name, input := range tests {
+		if _, err := readCapture(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: readCapture accepted %q", name, input)
+		}
+	}
+}
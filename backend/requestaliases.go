@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// requestFieldAliases maps alternative MomoKeyRequest field names, as used by MTN's
+// own documentation and popular tutorials, to the canonical field. Add new aliases here.
+var requestFieldAliases = map[string]string{
+	"subscriptionKey":      "primaryKey",
+	"providerCallbackHost": "callbackHost",
+}
+
+// requestFieldError reports a request body field that is not part of MomoKeyRequest,
+// or an alias given together with its canonical field
+type requestFieldError struct {
+	Field     string
+	Canonical string // Set when Field is an alias that conflicts with Canonical
+}
+
+func (e *requestFieldError) Error() string {
+	if e.Canonical != "" {
+		return fmt.Sprintf("field %q is an alias of %q, give only one of them", e.Field, e.Canonical)
+	}
+	return fmt.Sprintf("unknown field %q", e.Field)
+}
+
+// UnmarshalJSON decodes a generate request, accepting requestFieldAliases for their
+// canonical fields. Unknown fields are rejected, so a typo such as "primarykey_"
+// is reported instead of the request silently running without it.
+func (req *MomoKeyRequest) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name, value := range fields {
+		canonical, ok := requestFieldAliases[name]
+		if !ok {
+			continue
+		}
+		for other := range fields {
+			if strings.EqualFold(other, canonical) {
+				return &requestFieldError{Field: name, Canonical: canonical}
+			}
+		}
+		delete(fields, name)
+		fields[canonical] = value
+	}
+
+	canonicalBody, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	// plain has MomoKeyRequest's fields but not this method, so decoding into it does not recurse
+	type plain MomoKeyRequest
+	dec := json.NewDecoder(bytes.NewReader(canonicalBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode((*plain)(req)); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return &requestFieldError{Field: strings.Trim(field, `"`)}
+		}
+		return err
+	}
+	return nil
+}
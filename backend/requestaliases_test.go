@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRequestFieldAliases(t *testing.T) {
+	if len(requestFieldAliases) == 0 {
+		t.Fatal("no aliases to test")
+	}
+	for alias, canonical := range requestFieldAliases {
+		t.Run(alias, func(t *testing.T) {
+			var want, got MomoKeyRequest
+			if err := json.Unmarshal([]byte(fmt.Sprintf(`{%q:"value-1"}`, canonical)), &want); err != nil {
+				t.Fatalf("decode canonical %s: %v", canonical, err)
+			}
+			if err := json.Unmarshal([]byte(fmt.Sprintf(`{%q:"value-1"}`, alias)), &got); err != nil {
+				t.Fatalf("decode alias %s: %v", alias, err)
+			}
+			if reflect.DeepEqual(want, MomoKeyRequest{}) {
+				t.Fatalf("canonical field %s set nothing", canonical)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s decoded to %+v, want the same as %s: %+v", alias, got, canonical, want)
+			}
+
+			// Giving both is ambiguous
+			var both MomoKeyRequest
+			err := json.Unmarshal([]byte(fmt.Sprintf(`{%q:"a",%q:"b"}`, alias, canonical)), &both)
+			if fieldErr, ok := err.(*requestFieldError); !ok || fieldErr.Field != alias || fieldErr.Canonical != canonical {
+				t.Errorf("alias with its canonical field: err = %v, want a conflict between %s and %s", err, alias, canonical)
+			}
+		})
+	}
+}
+
+func TestRequestUnknownFields(t *testing.T) {
+	tests := []struct {
+		body      string
+		wantField string
+	}{
+		{`{"primaryKey":"k","primarykey_":"k"}`, "primarykey_"},
+		{`{"apiKey":"k"}`, "apiKey"},
+		{`{"callbackHost":"h","options":{}}`, "options"},
+	}
+	for _, tt := range tests {
+		var req MomoKeyRequest
+		err := json.Unmarshal([]byte(tt.body), &req)
+		if fieldErr, ok := err.(*requestFieldError); !ok || fieldErr.Field != tt.wantField || fieldErr.Canonical != "" {
+			t.Errorf("decode %s: err = %v, want unknown field %q", tt.body, err, tt.wantField)
+		}
+	}
+}
+
+func TestGenerateWithAliases(t *testing.T) {
+	h := setupTest(t, nil)
+	var sentKey atomic.Value
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sentKey.Store(r.Header.Get("Ocp-Apim-Subscription-Key"))
+		success(w, r)
+	}))
+
+	resp := generate(t, h, fmt.Sprintf(`{"subscriptionKey":%q,"providerCallbackHost":"alias.example.com"}`, testSubscriptionKey))
+	if got, _ := sentKey.Load().(string); got != testSubscriptionKey {
+		t.Errorf("MTN got subscription key %q, want the subscriptionKey alias value", got)
+	}
+	if resp.CallbackHost != "alias.example.com" {
+		t.Errorf("callbackHost = %q, want the providerCallbackHost alias value", resp.CallbackHost)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request ID: taken from the client when it sends a
+// usable one, generated otherwise, and always echoed on the response
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds a client-supplied request ID
+const maxRequestIDLength = 128
+
+// requestLogger writes to the standard logger, prefixing every line with the
+// request-scoped fields (request ID, callback host...) it was built up With, so
+// lines of interleaved requests can be told apart
+type requestLogger struct {
+	fields string
+}
+
+// With returns a logger that also prefixes lines with key=value
+func (l *requestLogger) With(key, value string) *requestLogger {
+	if strings.ContainsAny(value, " \t\r\n\"") {
+		value = fmt.Sprintf("%q", value)
+	}
+	return &requestLogger{fields: l.fields + key + "=" + value + " "}
+}
+
+// Printf logs like log.Printf, with the logger's fields
+func (l *requestLogger) Printf(format string, v ...interface{}) {
+	l.output(fmt.Sprintf(format, v...))
+}
+
+// Println logs like log.Println, with the logger's fields
+func (l *requestLogger) Println(v ...interface{}) {
+	l.output(fmt.Sprintln(v...))
+}
+
+func (l *requestLogger) output(msg string) {
+	if l.fields != "" {
+		msg = "[" + strings.TrimSuffix(l.fields, " ") + "] " + msg
+	}
+	// Depth 3 reports the caller of Printf/Println, as log.Lshortfile expects
+	log.Default().Output(3, msg)
+}
+
+// requestLoggerKey is the context key of the request's requestLogger
+type requestLoggerKey struct{}
+
+// withRequestLogger returns a context carrying l, for reqLog
+func withRequestLogger(ctx context.Context, l *requestLogger) context.Context {
+	return context.WithValue(ctx, requestLoggerKey{}, l)
+}
+
+// reqLog returns the request's logger, or one without fields outside a request
+func reqLog(ctx context.Context) *requestLogger {
+	if l, ok := ctx.Value(requestLoggerKey{}).(*requestLogger); ok {
+		return l
+	}
+	return &requestLogger{}
+}
+
+// withRequestID gives every request an ID, echoed in X-Request-Id, and a
+// requestLogger carrying it. Handlers add their own fields with With.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		logger := reqLog(r.Context()).With("request_id", id)
+		next.ServeHTTP(w, r.WithContext(withRequestLogger(r.Context(), logger)))
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
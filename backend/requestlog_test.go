@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// linesWith returns the log lines containing substr
+func linesWith(logs, substr string) []string {
+	var lines []string
+	for _, line := range strings.Split(logs, "\n") {
+		if strings.Contains(line, substr) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestLogLinesCarryRequestID(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	logs := captureLogs(t)
+
+	body := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"shop.example.com","product":"collection"}`, testSubscriptionKey)
+	rec := doRequest(h, http.MethodPost, "/api/generate", body, requestIDHeader, "order-42")
+	if got := rec.Header().Get(requestIDHeader); got != "order-42" {
+		t.Errorf("%s = %q, want the client's order-42 echoed", requestIDHeader, got)
+	}
+
+	out := logs.String()
+	for _, msg := range []string{"=== New API Key Generation Request Received ===", "STEP 1/2: Creating API User", "SUCCESS: API User created"} {
+		lines := linesWith(out, msg)
+		if len(lines) == 0 {
+			t.Fatalf("no log line %q:\n%s", msg, out)
+		}
+		if !strings.Contains(lines[0], "request_id=order-42") {
+			t.Errorf("log line lacks request_id=order-42: %s", lines[0])
+		}
+	}
+	// Lines after validation also carry the request's callback host, target env and product
+	line := linesWith(out, "STEP 1/2: Creating API User")[0]
+	for _, field := range []string{"callback_host=shop.example.com", "target_env=sandbox", "product=collection"} {
+		if !strings.Contains(line, field) {
+			t.Errorf("log line lacks %s: %s", field, line)
+		}
+	}
+}
+
+func TestConcurrentRequestsLogTheirOwnID(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+	logs := captureLogs(t)
+
+	ids := []string{"req-a", "req-b", "req-c", "req-d"}
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"primaryKey":%q,"callbackHost":"host%d.example.com"}`, testSubscriptionKey, i)
+			doRequest(h, http.MethodPost, "/api/generate", body, requestIDHeader, id)
+		}(i, id)
+	}
+	wg.Wait()
+
+	out := logs.String()
+	for i, id := range ids {
+		lines := linesWith(out, "request_id="+id+" ")
+		if len(lines) == 0 {
+			t.Errorf("no log lines for %s", id)
+		}
+		for _, line := range lines {
+			if strings.Contains(line, "callback_host=") && !strings.Contains(line, fmt.Sprintf("callback_host=host%d.example.com", i)) {
+				t.Errorf("line of %s carries another request's callback host: %s", id, line)
+			}
+		}
+	}
+}
+
+func TestGeneratedRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+	}{
+		{"none sent", ""},
+		{"control characters", "bad\x01id"},
+		{"spaces", "two words"},
+		{"too long", strings.Repeat("x", maxRequestIDLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			logs := captureLogs(t)
+
+			rec := doRequest(h, http.MethodGet, "/api/markets", "", requestIDHeader, tt.sent)
+			id := rec.Header().Get(requestIDHeader)
+			if _, err := uuid.Parse(id); err != nil {
+				t.Fatalf("%s = %q, want a generated UUID in place of %q", requestIDHeader, id, tt.sent)
+			}
+			if tt.sent != "" && strings.Contains(logs.String(), tt.sent) {
+				t.Errorf("logs carry the rejected request ID %q", tt.sent)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerWith(t *testing.T) {
+	logs := captureLogs(t)
+	reqLog(context.Background()).Println("no fields")
+	(&requestLogger{}).With("request_id", "r1").With("callback_host", "a b").Printf("with %s", "fields")
+
+	out := logs.String()
+	if lines := linesWith(out, "no fields"); len(lines) != 1 || strings.Contains(lines[0], "[") {
+		t.Errorf("logger outside a request added fields: %v", lines)
+	}
+	if lines := linesWith(out, "with fields"); len(lines) != 1 || !strings.Contains(lines[0], `[request_id=r1 callback_host="a b"] with fields`) {
+		t.Errorf("fields line = %v, want request_id and a quoted callback_host", lines)
+	}
+}
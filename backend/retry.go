@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// retryPolicy describes how failed MTN MoMo calls are retried
+type retryPolicy struct {
+	MaxRetries int           // Retries after the first attempt; 0 disables retries
+	BaseDelay  time.Duration // Backoff before the first retry, doubled for each further retry
+	MaxDelay   time.Duration // Upper bound on any single backoff
+	Jitter     bool          // Apply full jitter (random between 0 and the backoff)
+}
+
+// retrySleep waits between attempts; it returns early if the context is cancelled
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the delay before the given retry (1 for the first retry).
+// With jitter enabled the delay is drawn uniformly from [0, backoff] so that many
+// requests retrying after the same MTN outage do not hit MTN in lockstep.
+func (p retryPolicy) backoff(retry int, rng *rand.Rand) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter && d > 0 {
+		d = time.Duration(rng.Int63n(int64(d) + 1))
+	}
+	return d
+}
+
+// isRetryable reports whether a failed MTN call is worth retrying: network errors,
+// rate limiting and server-side failures are; client errors are not.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *momoAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true
+}
+
+// isConnectionReset reports whether an MTN call failed because the connection was
+// closed or reset under it (EOF, ECONNRESET), which MTN's gateway occasionally does
+// to valid requests
+func isConnectionReset(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryBudget is a number of retries shared by several MTN calls, carried in a context
+type retryBudget struct {
+	remaining atomic.Int64
+}
+
+type retryBudgetKey struct{}
+
+// withRetryBudget returns a context whose MTN calls share at most retries retries in total
+func withRetryBudget(ctx context.Context, retries int) context.Context {
+	b := &retryBudget{}
+	b.remaining.Store(int64(retries))
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// takeRetry consumes one retry from the context's budget, reporting false once it is
+// spent. Contexts without a budget always allow the retry.
+func takeRetry(ctx context.Context) bool {
+	b, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		return true
+	}
+	return b.remaining.Add(-1) >= 0
+}
+
+// withRetry runs fn until it succeeds, fails with a non-retryable error, or the
+// configured retries are exhausted. Retries also stop once the context's shared
+// retry budget is spent, or when its deadline would pass during the backoff.
+// When the call is idempotent, a connection reset is retried up to MOMO_RESET_RETRIES
+// more times even once the regular retries are spent (or disabled).
+// It returns the number of attempts made.
+func withRetry(ctx context.Context, operation string, idempotent bool, fn func(attempt int) error) (int, error) {
+	logger := reqLog(ctx)
+	policy := cfg.Retry
+	// Each call gets its own source so concurrent requests draw independent jitter
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	resetRetries := 0
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			if attempt > 1 {
+				logger.Printf("RETRY: %s succeeded on attempt %d", operation, attempt)
+			}
+			return attempt, nil
+		}
+
+		if attempt > policy.MaxRetries+resetRetries || !isRetryable(err) {
+			if !idempotent || !isConnectionReset(err) || resetRetries >= cfg.ResetRetries {
+				return attempt, err
+			}
+			resetRetries++
+			logger.Printf("RETRY: %s connection was reset on attempt %d, retrying the idempotent call (reset retry %d/%d)", operation, attempt, resetRetries, cfg.ResetRetries)
+		}
+
+		delay := policy.backoff(attempt, rng)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			logger.Printf("RETRY: %s failed on attempt %d, not retrying: the time budget runs out before the %s backoff - %v", operation, attempt, delay, err)
+			return attempt, err
+		}
+		if !takeRetry(ctx) {
+			logger.Printf("RETRY: %s failed on attempt %d, not retrying: the shared retry budget is spent - %v", operation, attempt, err)
+			return attempt, err
+		}
+		logger.Printf("RETRY: %s failed on attempt %d/%d, retrying in %s - %v", operation, attempt, policy.MaxRetries+resetRetries+1, delay, err)
+		if sleepErr := retrySleep(ctx, delay); sleepErr != nil {
+			return attempt, err
+		}
+	}
+}
+
+// isNotPropagated reports whether an MTN call failed with 404 because a just-created
+// API user has not propagated through MTN's sandbox yet
+func isNotPropagated(err error) bool {
+	var apiErr *momoAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// withPropagationRetry runs fn, retrying while it fails with 404 under the
+// MOMO_PROPAGATION_RETRIES policy. This is separate from withRetry, which never
+// retries 404s: it is only used for calls made right after the user was created,
+// where a 404 means "not yet" rather than "does not exist".
+func withPropagationRetry(ctx context.Context, operation string, fn func() error) error {
+	logger := reqLog(ctx)
+	policy := cfg.PropagationRetry
+	for retry := 1; ; retry++ {
+		err := fn()
+		if err == nil || retry > policy.MaxRetries || !isNotPropagated(err) {
+			return err
+		}
+
+		delay := policy.backoff(retry, nil)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		logger.Printf("RETRY: %s returned 404, the new API user may not have propagated yet; retrying in %s (%d/%d)", operation, delay, retry, policy.MaxRetries)
+		if sleepErr := retrySleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
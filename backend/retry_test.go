@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordSleeps replaces retrySleep for the rest of the test with one that returns at
+// once, recording the requested backoffs
+func recordSleeps(t *testing.T) func() []time.Duration {
+	t.Helper()
+	var mu sync.Mutex
+	var sleeps []time.Duration
+	saved := retrySleep
+	retrySleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { retrySleep = saved })
+	return func() []time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Duration(nil), sleeps...)
+	}
+}
+
+// errUnavailable is a retryable MTN failure
+var errUnavailable = &momoAPIError{Operation: "test", StatusCode: http.StatusServiceUnavailable}
+
+func TestBackoffJitterBounds(t *testing.T) {
+	policy := retryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: true}
+	rng := rand.New(rand.NewSource(1))
+	for retry, ceiling := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second, // Capped at MaxDelay
+		9: time.Second,
+	} {
+		for i := 0; i < 200; i++ {
+			if d := policy.backoff(retry, rng); d < 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %s, want within [0, %s]", retry, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetrySleepsWithinJitteredBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter string
+	}{
+		{"jitter on", "true"},
+		{"jitter off", "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t, map[string]string{
+				"MOMO_MAX_RETRIES":      "4",
+				"MOMO_RETRY_BASE_DELAY": "100ms",
+				"MOMO_RETRY_MAX_DELAY":  "300ms",
+				"MOMO_RETRY_JITTER":     tt.jitter,
+			})
+			sleeps := recordSleeps(t)
+
+			attempts, err := withRetry(context.Background(), "test", true, func(int) error { return errUnavailable })
+			if !errors.Is(err, errUnavailable) || attempts != 5 {
+				t.Fatalf("withRetry = %d attempts, %v; want 5 attempts and the last error", attempts, err)
+			}
+
+			ceilings := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+			got := sleeps()
+			if len(got) != len(ceilings) {
+				t.Fatalf("slept %d times (%v), want %d", len(got), got, len(ceilings))
+			}
+			for i, d := range got {
+				if tt.jitter == "false" && d != ceilings[i] {
+					t.Errorf("sleep %d = %s, want exactly %s without jitter", i+1, d, ceilings[i])
+				}
+				if d < 0 || d > ceilings[i] {
+					t.Errorf("sleep %d = %s, want within [0, %s]", i+1, d, ceilings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSharedRetryBudget(t *testing.T) {
+	setupTest(t, map[string]string{"MOMO_MAX_RETRIES": "4"})
+	sleeps := recordSleeps(t)
+	ctx := withRetryBudget(context.Background(), 3)
+
+	// The first call spends the whole budget: 1 attempt plus 3 retries, not 4
+	first, err := withRetry(ctx, "first", true, func(int) error { return errUnavailable })
+	if first != 4 || !errors.Is(err, errUnavailable) {
+		t.Errorf("first call = %d attempts, %v; want 4 attempts", first, err)
+	}
+	// The second call shares the spent budget, so it is not retried at all
+	second, err := withRetry(ctx, "second", true, func(int) error { return errUnavailable })
+	if second != 1 || !errors.Is(err, errUnavailable) {
+		t.Errorf("second call = %d attempts, %v; want 1 attempt", second, err)
+	}
+	if got := len(sleeps()); got != 3 {
+		t.Errorf("slept %d times, want 3", got)
+	}
+
+	// Without a budget each call gets its own MOMO_MAX_RETRIES
+	if n, _ := withRetry(context.Background(), "unbudgeted", true, func(int) error { return errUnavailable }); n != 5 {
+		t.Errorf("unbudgeted call = %d attempts, want 5", n)
+	}
+}
+
+func TestGenerateRetryBudget(t *testing.T) {
+	tests := []struct {
+		budget          string
+		wantUserAttempt int
+	}{
+		{"0", 5}, // Unbounded: MOMO_MAX_RETRIES alone
+		{"2", 3},
+	}
+	for _, tt := range tests {
+		t.Run("GENERATE_RETRY_BUDGET="+tt.budget, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"MOMO_MAX_RETRIES": "4", "GENERATE_RETRY_BUDGET": tt.budget})
+			fakeMTN(t, mtnStatus(http.StatusServiceUnavailable))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if resp.Attempts.UserCreate != tt.wantUserAttempt {
+				t.Errorf("attempts.userCreate = %d, want %d", resp.Attempts.UserCreate, tt.wantUserAttempt)
+			}
+			if resp.Source != sourceLocal {
+				t.Errorf("source = %q, want a local fallback", resp.Source)
+			}
+		})
+	}
+}
+
+func TestKeyCreationPropagationRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      string
+		keyNotFound  int32 // 404s from the key call before it succeeds
+		wantSource   string
+		wantAttempts int
+		wantSleeps   []time.Duration
+	}{
+		{"propagated at once", "3", 0, sourceMTN, 1, nil},
+		{"404 then 201", "3", 1, sourceMTN, 2, []time.Duration{500 * time.Millisecond}},
+		{"last retry succeeds", "3", 3, sourceMTN, 4, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}},
+		{"never propagates", "3", 4, sourceLocal, 4, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}},
+		{"retries disabled", "0", 1, sourceLocal, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"MOMO_PROPAGATION_RETRIES": tt.retries})
+			sleeps := recordSleeps(t)
+			var userCalls, keyCalls atomic.Int32
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/apiuser"):
+					userCalls.Add(1)
+					w.WriteHeader(http.StatusCreated)
+				case strings.HasSuffix(r.URL.Path, "/apikey"):
+					if keyCalls.Add(1) <= tt.keyNotFound {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")(w, r)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+
+			resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			if resp.Source != tt.wantSource {
+				t.Errorf("source = %q, want %q", resp.Source, tt.wantSource)
+			}
+			if resp.Attempts.KeyCreate != tt.wantAttempts {
+				t.Errorf("attempts.keyCreate = %d, want %d", resp.Attempts.KeyCreate, tt.wantAttempts)
+			}
+			if n := userCalls.Load(); n != 1 {
+				t.Errorf("user created %d times, want once", n)
+			}
+			if got := sleeps(); !reflect.DeepEqual(got, tt.wantSleeps) {
+				t.Errorf("backoffs = %v, want %v", got, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+// resetConnection drops the request's connection without answering: with rst, the
+// client sees ECONNRESET, otherwise EOF
+func resetConnection(t *testing.T, w http.ResponseWriter, r *http.Request, rst bool) {
+	io.Copy(io.Discard, r.Body)
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		t.Errorf("hijack MTN stub connection: %v", err)
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok && rst {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func TestConnectionResetRetry(t *testing.T) {
+	const referenceID = "3f2b8c1e-9d4a-4b6f-8e21-5c7a0d9e1f34"
+	tests := []struct {
+		name         string
+		resetRetries string
+		resetPath    string // The MTN call whose first attempt is reset
+		rst          bool
+		referenceID  string
+		wantSource   string
+		wantAttempts CallAttempts
+	}{
+		{"user create EOF then success", "1", "/apiuser", false, referenceID, sourceMTN, CallAttempts{UserCreate: 2, KeyCreate: 1}},
+		{"user create ECONNRESET then success", "1", "/apiuser", true, referenceID, sourceMTN, CallAttempts{UserCreate: 2, KeyCreate: 1}},
+		{"user create without a reference ID", "1", "/apiuser", false, "", sourceLocal, CallAttempts{UserCreate: 1}},
+		{"key create EOF then success", "1", "/apikey", false, "", sourceMTN, CallAttempts{UserCreate: 1, KeyCreate: 2}},
+		{"key create ECONNRESET then success", "1", "/apikey", true, "", sourceMTN, CallAttempts{UserCreate: 1, KeyCreate: 2}},
+		{"reset retries disabled", "0", "/apiuser", false, referenceID, sourceLocal, CallAttempts{UserCreate: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Regular retries are off, so only the reset retry can recover
+			h := setupTest(t, map[string]string{"MOMO_MAX_RETRIES": "0", "MOMO_RESET_RETRIES": tt.resetRetries})
+			var reset atomic.Bool
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, tt.resetPath) && reset.CompareAndSwap(false, true) {
+					resetConnection(t, w, r, tt.rst)
+					return
+				}
+				success(w, r)
+			}))
+
+			body := fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)
+			if tt.referenceID != "" {
+				body = fmt.Sprintf(`{"primaryKey":%q,"referenceId":%q}`, testSubscriptionKey, tt.referenceID)
+			}
+			resp := generate(t, h, body)
+			if resp.Source != tt.wantSource {
+				t.Errorf("source = %q, want %q", resp.Source, tt.wantSource)
+			}
+			if resp.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %+v, want %+v", resp.Attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret providers selectable with SECRET_PROVIDER
+const (
+	secretProviderEnv   = "env"   // MOMO_SUBSCRIPTION_KEY
+	secretProviderVault = "vault" // HashiCorp Vault KV secret
+	secretProviderAWS   = "aws"   // AWS Secrets Manager secret
+)
+
+// secretFetchTimeout bounds one fetch from a secret manager
+const secretFetchTimeout = 10 * time.Second
+
+// SecretProvider supplies the server-side subscription key, used when a request
+// carries none
+type SecretProvider interface {
+	GetSubscriptionKey(ctx context.Context) (string, error)
+}
+
+// subscriptionKeyProvider is the SECRET_PROVIDER in use
+var subscriptionKeyProvider SecretProvider = envSecretProvider{}
+
+// secretHTTPClient is used for secret manager calls, apart from the MTN client
+var secretHTTPClient = &http.Client{Timeout: secretFetchTimeout}
+
+// serverSubscriptionKey returns the server-side subscription key, or "" if there is
+// none or it could not be fetched
+func serverSubscriptionKey(ctx context.Context) string {
+	key, err := subscriptionKeyProvider.GetSubscriptionKey(ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to get the subscription key from the %s secret provider: %v", cfg.SecretProvider, err)
+		return ""
+	}
+	return key
+}
+
+// newSecretProvider builds the provider selected by c.SecretProvider. Secret manager
+// providers are cached and refreshed every c.SecretRefreshInterval in the background.
+func newSecretProvider(c Config) SecretProvider {
+	var next SecretProvider
+	switch c.SecretProvider {
+	case secretProviderVault:
+		next = &vaultSecretProvider{addr: c.VaultAddr, token: c.VaultToken, path: c.VaultSecretPath, field: c.SecretField}
+	case secretProviderAWS:
+		next = &awsSecretProvider{
+			region:       c.AWSRegion,
+			secretID:     c.AWSSecretID,
+			field:        c.SecretField,
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	default:
+		return envSecretProvider{}
+	}
+	p := &cachingSecretProvider{next: next}
+	go p.refreshEvery(c.SecretRefreshInterval)
+	return p
+}
+
+// envSecretProvider returns MOMO_SUBSCRIPTION_KEY
+type envSecretProvider struct{}
+
+func (envSecretProvider) GetSubscriptionKey(context.Context) (string, error) {
+	return cfg.SubscriptionKey, nil
+}
+
+// cachingSecretProvider keeps the last key fetched from a secret manager, so requests
+// never wait on it once the key is known. If a refresh fails the cached key stays in use.
+type cachingSecretProvider struct {
+	next SecretProvider
+
+	mu    sync.Mutex
+	value string
+}
+
+func (p *cachingSecretProvider) GetSubscriptionKey(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	value := p.value
+	p.mu.Unlock()
+	if value != "" {
+		return value, nil
+	}
+	return p.refresh(ctx)
+}
+
+// refresh fetches the key from the secret manager and caches it
+func (p *cachingSecretProvider) refresh(ctx context.Context) (string, error) {
+	value, err := p.next.GetSubscriptionKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	p.value = value
+	p.mu.Unlock()
+	return value, nil
+}
+
+// refreshEvery refreshes the cached key on an interval, picking up rotated keys
+func (p *cachingSecretProvider) refreshEvery(interval time.Duration) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+		_, err := p.refresh(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("WARNING: Failed to refresh the subscription key from the secret provider, keeping the cached key: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// vaultSecretProvider reads the key from a HashiCorp Vault KV secret (version 1 or 2)
+type vaultSecretProvider struct {
+	addr, token, path, field string
+}
+
+func (p *vaultSecretProvider) GetSubscriptionKey(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+strings.TrimPrefix(p.path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	resp, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned status %d for %s", resp.StatusCode, p.path)
+	}
+
+	// KV version 2 nests the secret under data.data, version 1 directly under data
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse vault response: %v", err)
+	}
+	data := result.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[p.field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("vault secret %s has no %q field", p.path, p.field)
+	}
+	return value, nil
+}
+
+// awsSecretProvider reads the key from an AWS Secrets Manager secret, either a plain
+// string or a JSON object holding it in field. Requests are signed with Signature
+// Version 4 using the standard AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY variables.
+type awsSecretProvider struct {
+	region, secretID, field            string
+	accessKey, secretKey, sessionToken string
+}
+
+func (p *awsSecretProvider) GetSubscriptionKey(ctx context.Context) (string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": p.secretID})
+	if err != nil {
+		return "", err
+	}
+	endpoint := fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", p.region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSv4(req, body, p.region, "secretsmanager", p.accessKey, p.secretKey, p.sessionToken, time.Now().UTC())
+
+	resp, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("AWS Secrets Manager returned status %d for %s", resp.StatusCode, p.secretID)
+	}
+	var result struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse AWS Secrets Manager response: %v", err)
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(result.SecretString), "{") {
+		return result.SecretString, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(result.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not valid JSON: %v", p.secretID, err)
+	}
+	value, ok := fields[p.field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("secret %s has no %q field", p.secretID, p.field)
+	}
+	return value, nil
+}
+
+// signAWSv4 adds AWS Signature Version 4 headers to a request with the given body
+func signAWSv4(req *http.Request, body []byte, region, service, accessKey, secretKey, sessionToken string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
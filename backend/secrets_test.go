@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// stubSecretProvider returns a fixed key or error and counts its calls
+type stubSecretProvider struct {
+	key   string
+	err   error
+	calls atomic.Int32
+}
+
+func (p *stubSecretProvider) GetSubscriptionKey(context.Context) (string, error) {
+	p.calls.Add(1)
+	return p.key, p.err
+}
+
+// useSecretProvider installs p as the server's secret provider for the test
+func useSecretProvider(t *testing.T, p SecretProvider) {
+	t.Helper()
+	prev := subscriptionKeyProvider
+	subscriptionKeyProvider = p
+	t.Cleanup(func() { subscriptionKeyProvider = prev })
+}
+
+func TestGenerateUsesSecretProvider(t *testing.T) {
+	const providerKey = "fedcba9876543210fedcba9876543210"
+	tests := []struct {
+		name       string
+		body       string
+		provider   *stubSecretProvider
+		wantStatus int
+		wantKey    string
+	}{
+		{"provider key when the request has none", `{}`, &stubSecretProvider{key: providerKey}, http.StatusCreated, providerKey},
+		{"request key wins", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey), &stubSecretProvider{key: providerKey}, http.StatusCreated, testSubscriptionKey},
+		{"provider failure", `{}`, &stubSecretProvider{err: errors.New("vault sealed")}, http.StatusBadRequest, ""},
+		{"provider has no key", `{}`, &stubSecretProvider{}, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			useSecretProvider(t, tt.provider)
+			var sentKey atomic.Value
+			success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+			fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sentKey.Store(r.Header.Get("Ocp-Apim-Subscription-Key"))
+				success(w, r)
+			}))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if env := decodeEnvelope(t, rec, nil); env.ErrorCode != errMissingSubscriptionKey {
+					t.Errorf("errorCode = %q, want %q", env.ErrorCode, errMissingSubscriptionKey)
+				}
+				return
+			}
+			if got, _ := sentKey.Load().(string); got != tt.wantKey {
+				t.Errorf("MTN got subscription key %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestCachingSecretProvider(t *testing.T) {
+	setupTest(t, nil)
+	next := &stubSecretProvider{key: "first"}
+	p := &cachingSecretProvider{next: next}
+
+	for i := 0; i < 3; i++ {
+		if key, err := p.GetSubscriptionKey(context.Background()); err != nil || key != "first" {
+			t.Fatalf("GetSubscriptionKey = %q, %v; want first", key, err)
+		}
+	}
+	if n := next.calls.Load(); n != 1 {
+		t.Errorf("secret manager fetched %d times, want once then cached", n)
+	}
+
+	// A rotated key is picked up on refresh
+	next.key = "second"
+	if _, err := p.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if key, _ := p.GetSubscriptionKey(context.Background()); key != "second" {
+		t.Errorf("key after rotation = %q, want second", key)
+	}
+
+	// A failed refresh keeps the cached key
+	next.err = errors.New("unavailable")
+	if _, err := p.refresh(context.Background()); err == nil {
+		t.Fatal("refresh succeeded with a failing secret manager")
+	}
+	if key, err := p.GetSubscriptionKey(context.Background()); err != nil || key != "second" {
+		t.Errorf("key after a failed refresh = %q, %v; want the cached second", key, err)
+	}
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"kv version 1", http.StatusOK, `{"data":{"subscriptionKey":"kv1-key"}}`, "kv1-key", false},
+		{"kv version 2", http.StatusOK, `{"data":{"data":{"subscriptionKey":"kv2-key"},"metadata":{"version":3}}}`, "kv2-key", false},
+		{"missing field", http.StatusOK, `{"data":{"other":"x"}}`, "", true},
+		{"forbidden", http.StatusForbidden, `{"errors":["permission denied"]}`, "", true},
+		{"malformed", http.StatusOK, `not json`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/secret/data/momo" || r.Header.Get("X-Vault-Token") != "vault-token" {
+					http.Error(w, "unexpected request", http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			p := &vaultSecretProvider{addr: srv.URL, token: "vault-token", path: "/secret/data/momo", field: "subscriptionKey"}
+			got, err := p.GetSubscriptionKey(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSubscriptionKey error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetSubscriptionKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
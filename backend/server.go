@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// newListener listens on the Unix socket at LISTEN_SOCKET when configured, or on the TCP port otherwise
+func newListener(c Config) (net.Listener, error) {
+	if c.ListenSocket == "" {
+		return net.Listen("tcp", ":"+c.Port)
+	}
+
+	// Remove a stale socket file left behind by an unclean exit
+	if err := os.Remove(c.ListenSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", c.ListenSocket)
+}
+
+// listenAddress describes where the server is reachable, for logging
+func listenAddress(c Config) string {
+	if c.ListenSocket != "" {
+		return "unix socket " + c.ListenSocket
+	}
+	return "port " + c.Port
+}
+
+// serve runs the server until SIGINT or SIGTERM, then shuts it down gracefully,
+// letting in-flight requests finish and removing the Unix socket file if one was used
+func serve(server *http.Server, listener net.Listener) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down (waiting up to %s for in-flight requests)...", sig, cfg.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+
+	if cfg.ListenSocket != "" {
+		if rmErr := os.Remove(cfg.ListenSocket); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			log.Printf("ERROR: Failed to remove unix socket %s: %v", cfg.ListenSocket, rmErr)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	log.Println("=== Server stopped ===")
+	return nil
+}
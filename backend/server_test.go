@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// shortTempDir is a temporary directory with a path short enough for a Unix socket
+// (about 100 bytes at most), which t.TempDir does not guarantee
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "momo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	socket := filepath.Join(shortTempDir(t), "momo.sock")
+	// A stale socket file from an unclean exit must not block startup
+	if err := os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := setupTest(t, map[string]string{"LISTEN_SOCKET": socket})
+
+	listener, err := newListener(cfg)
+	if err != nil {
+		t.Fatalf("newListener: %v", err)
+	}
+	server := &http.Server{Handler: h}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://momo/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz over %s: %v", socket, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := listenAddress(cfg); got != "unix socket "+socket {
+		t.Errorf("listenAddress = %q", got)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// signatureHeader carries the HMAC of the response body when RESPONSE_SIGNING_KEY is set
+const signatureHeader = "X-Signature"
+
+// setSignature adds an X-Signature header with the HMAC-SHA256 of body, keyed with
+// RESPONSE_SIGNING_KEY, if one is configured. The body is signed byte for byte as
+// sent (no re-serialization or whitespace normalization, including the trailing
+// newline), so clients must verify against the raw bytes they received.
+// It must be called before the header is written.
+func setSignature(w http.ResponseWriter, body []byte) {
+	if cfg.ResponseSigningKey == "" {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.ResponseSigningKey))
+	mac.Write(body)
+	w.Header().Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResponseSignature(t *testing.T) {
+	const signingKey = "test-signing-key"
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"generate json", http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)},
+		{"generate env", http.MethodPost, "/api/generate?format=env", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey)},
+		{"error", http.MethodPost, "/api/generate", `{"primaryKey":`},
+		{"other route", http.MethodGet, "/api/markets", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"RESPONSE_SIGNING_KEY": signingKey})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, tt.method, tt.path, tt.body)
+			mac := hmac.New(sha256.New, []byte(signingKey))
+			mac.Write(rec.Body.Bytes())
+			want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			if got := rec.Header().Get(signatureHeader); got != want {
+				t.Errorf("%s = %q, want %q recomputed over the %d-byte body", signatureHeader, got, want, rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestResponseSignatureDisabled(t *testing.T) {
+	h := setupTest(t, map[string]string{"RESPONSE_SIGNING_KEY": ""})
+	if rec := doRequest(h, http.MethodGet, "/api/markets", ""); rec.Header().Get(signatureHeader) != "" {
+		t.Errorf("%s set without RESPONSE_SIGNING_KEY", signatureHeader)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Credential sources recorded alongside each stored record
+const (
+	sourceMTN   = "mtn"   // Registered with the MTN MoMo API
+	sourceLocal = "local" // Generated locally by the fallback path
+)
+
+// CredentialRecord is our local copy of a generated credential set.
+// The API key itself is only ever held encrypted.
+type CredentialRecord struct {
+	UserID       string    `json:"userId"`
+	CallbackHost string    `json:"callbackHost"`
+	TargetEnv    string    `json:"targetEnvironment"`
+	Source       string    `json:"source"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// KeyFingerprint identifies the API key (see keyFingerprint) without revealing it
+	KeyFingerprint string `json:"keyFingerprint"`
+
+	encryptedKey []byte
+}
+
+// ETag returns a strong entity tag for the record's current state. It changes
+// whenever any field changes.
+func (rec CredentialRecord) ETag() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%d", rec.UserID, rec.CallbackHost, rec.TargetEnv, rec.Source, rec.CreatedAt.UnixNano())
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// credentialStore is an in-memory store of generated credentials.
+// Removing a record only removes our local copy; it does not delete the
+// API user from MTN MoMo. The same applies to records evicted once the
+// store holds maxRecords.
+type credentialStore struct {
+	mu      sync.RWMutex
+	records map[string]*CredentialRecord
+	aead    cipher.AEAD
+
+	// maxRecords caps the number of records (0 means unbounded). Once it is
+	// exceeded the least recently used record is evicted; recency is tracked in
+	// lru (front is most recent), whose elements hold store keys.
+	maxRecords int
+	lru        *list.List
+	lruElems   map[string]*list.Element
+}
+
+// newCredentialStore creates an empty store with a fresh per-process encryption key,
+// holding at most maxRecords records (0 for no limit)
+func newCredentialStore(maxRecords int) (*credentialStore, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentialStore{
+		records:    make(map[string]*CredentialRecord),
+		aead:       aead,
+		maxRecords: maxRecords,
+		lru:        list.New(),
+		lruElems:   make(map[string]*list.Element),
+	}, nil
+}
+
+// touch marks the record stored under key as most recently used. The caller must hold mu.
+func (s *credentialStore) touch(key string) {
+	if e, ok := s.lruElems[key]; ok {
+		s.lru.MoveToFront(e)
+		return
+	}
+	s.lruElems[key] = s.lru.PushFront(key)
+}
+
+// forget drops key from the recency list. The caller must hold mu.
+func (s *credentialStore) forget(key string) {
+	if e, ok := s.lruElems[key]; ok {
+		s.lru.Remove(e)
+		delete(s.lruElems, key)
+	}
+}
+
+// storeKey normalizes a user ID so lookups tolerate differences in case and UUID formatting
+func storeKey(userID string) string {
+	userID = strings.TrimSpace(userID)
+	if id, err := uuid.Parse(userID); err == nil {
+		return id.String()
+	}
+	return strings.ToLower(userID)
+}
+
+// Save encrypts the API key and stores the record, replacing any existing one
+func (s *credentialStore) Save(rec CredentialRecord, apiKey string) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// The nonce is prepended to the ciphertext so it can be recovered on decrypt
+	rec.encryptedKey = s.aead.Seal(nonce, nonce, []byte(apiKey), []byte(rec.UserID))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := storeKey(rec.UserID)
+	s.records[key] = &rec
+	s.touch(key)
+
+	for s.maxRecords > 0 && len(s.records) > s.maxRecords {
+		oldest := s.lru.Back().Value.(string)
+		log.Printf("INFO: Credential store full (%d records), evicting local record for user %s (the MTN user is not deleted)", s.maxRecords, s.records[oldest].UserID)
+		delete(s.records, oldest)
+		s.forget(oldest)
+	}
+	return nil
+}
+
+// Get returns a copy of the stored record for the given user ID and counts as a use for eviction
+func (s *credentialStore) Get(userID string) (CredentialRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := storeKey(userID)
+	rec, ok := s.records[key]
+	if !ok {
+		return CredentialRecord{}, false
+	}
+	s.touch(key)
+	return *rec, true
+}
+
+// APIKey decrypts the API key held in a stored record
+func (s *credentialStore) APIKey(rec CredentialRecord) (string, error) {
+	nonceSize := s.aead.NonceSize()
+	if len(rec.encryptedKey) < nonceSize {
+		return "", errors.New("stored API key is corrupt")
+	}
+
+	nonce, ciphertext := rec.encryptedKey[:nonceSize], rec.encryptedKey[nonceSize:]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(rec.UserID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// MaskedAPIKey returns the stored API key with all but its last four characters
+// hidden. Every ordinary read of a stored key goes through it; only the admin
+// reveal endpoint uses APIKey directly.
+func (s *credentialStore) MaskedAPIKey(rec CredentialRecord) (string, error) {
+	apiKey, err := s.APIKey(rec)
+	if err != nil {
+		return "", err
+	}
+	return maskSecret(apiKey), nil
+}
+
+// List returns copies of all stored records, oldest first
+func (s *credentialStore) List() []CredentialRecord {
+	s.mu.RLock()
+	records := make([]CredentialRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, *rec)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records
+}
+
+// Delete removes the record (and its encrypted key) for the given user ID.
+// It reports whether a record was present.
+func (s *credentialStore) Delete(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := storeKey(userID)
+	if _, ok := s.records[key]; !ok {
+		return false
+	}
+	delete(s.records, key)
+	s.forget(key)
+	return true
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
+
+// subscriptionKeySuffix returns the last 4 characters of a subscription key, or ""
+// for a key so short that its suffix would give away most of it
+func subscriptionKeySuffix(key string) string {
+	if len(key) < 8 {
+		return ""
+	}
+	return key[len(key)-4:]
+}
+
+// keyFingerprint returns the first 8 hex characters of the SHA-256 of an API key.
+// It is for correlation only: logs and exports carry it in place of the key, so
+// operators can match a key a user reports to its record and log lines. The store
+// itself still holds the key, encrypted (see Save).
+func keyFingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return fmt.Sprintf("%x", sum[:4])
+}
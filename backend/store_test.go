@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// storedUserIDs lists the user IDs in s, sorted
+func storedUserIDs(s *credentialStore) []string {
+	var ids []string
+	for _, rec := range s.List() {
+		ids = append(ids, rec.UserID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestStoreEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRecords int
+		ops        []string // "save:<id>" or "get:<id>"
+		want       []string
+	}{
+		{"under capacity", 3, []string{"save:a", "save:b"}, []string{"a", "b"}},
+		{"oldest evicted", 3, []string{"save:a", "save:b", "save:c", "save:d"}, []string{"b", "c", "d"}},
+		{"read counts as use", 3, []string{"save:a", "save:b", "save:c", "get:a", "save:d"}, []string{"a", "c", "d"}},
+		{"resave counts as use", 3, []string{"save:a", "save:b", "save:c", "save:a", "save:d", "save:e"}, []string{"a", "d", "e"}},
+		{"resave does not grow", 2, []string{"save:a", "save:b", "save:b", "save:b"}, []string{"a", "b"}},
+		{"capacity of one", 1, []string{"save:a", "save:b", "get:a"}, []string{"b"}},
+		{"unbounded", 0, []string{"save:a", "save:b", "save:c", "save:d"}, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newCredentialStore(tt.maxRecords)
+			if err != nil {
+				t.Fatalf("newCredentialStore: %v", err)
+			}
+			for _, op := range tt.ops {
+				action, id, _ := strings.Cut(op, ":")
+				if action == "save" {
+					if err := s.Save(CredentialRecord{UserID: id}, "key-"+id); err != nil {
+						t.Fatalf("Save(%s): %v", id, err)
+					}
+				} else {
+					s.Get(id)
+				}
+			}
+			if got := storedUserIDs(s); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("stored = %v, want %v", got, tt.want)
+			}
+			if tt.maxRecords > 0 && len(s.lruElems) != len(s.records) {
+				t.Errorf("recency list tracks %d keys for %d records", len(s.lruElems), len(s.records))
+			}
+		})
+	}
+}
+
+func TestStoreDeleteForgetsRecency(t *testing.T) {
+	s, err := newCredentialStore(2)
+	if err != nil {
+		t.Fatalf("newCredentialStore: %v", err)
+	}
+	s.Save(CredentialRecord{UserID: "a"}, "key-a")
+	s.Save(CredentialRecord{UserID: "b"}, "key-b")
+	s.Delete("a")
+	s.Save(CredentialRecord{UserID: "c"}, "key-c")
+	if got := storedUserIDs(s); fmt.Sprint(got) != "[b c]" {
+		t.Errorf("stored = %v, want [b c]: deleting must free capacity without evicting", got)
+	}
+}
+
+func TestStoreMaskedAPIKey(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	s, err := newCredentialStore(0)
+	if err != nil {
+		t.Fatalf("newCredentialStore: %v", err)
+	}
+	if err := s.Save(CredentialRecord{UserID: "user"}, apiKey); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	rec, _ := s.Get("user")
+
+	masked, err := s.MaskedAPIKey(rec)
+	if err != nil {
+		t.Fatalf("MaskedAPIKey: %v", err)
+	}
+	if want := strings.Repeat("*", len(apiKey)-4) + "8f90"; masked != want {
+		t.Errorf("MaskedAPIKey = %q, want %q", masked, want)
+	}
+	if full, _ := s.APIKey(rec); full != apiKey {
+		t.Errorf("APIKey = %q, want the full key", full)
+	}
+
+	// The key is bound to its user ID, so a record moved to another ID cannot be read
+	rec.UserID = "someone-else"
+	if _, err := s.MaskedAPIKey(rec); err == nil {
+		t.Error("MaskedAPIKey decrypted a key under another user ID")
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"abcd":      "****",
+		"abcde":     "*bcde",
+		"123456789": "*****6789",
+	}
+	for in, want := range tests {
+		if got := maskSecret(in); got != want {
+			t.Errorf("maskSecret(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	const apiKey = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	// printf %s a1b2c3d4e5f60718293a4b5c6d7e8f90 | sha256sum | cut -c1-8
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte(apiKey)))[:8]
+
+	for i := 0; i < 3; i++ {
+		if got := keyFingerprint(apiKey); got != want {
+			t.Fatalf("keyFingerprint = %q on call %d, want %q", got, i+1, want)
+		}
+	}
+	if keyFingerprint(apiKey+"0") == want {
+		t.Error("different keys share a fingerprint")
+	}
+	if strings.Contains(apiKey, want) {
+		t.Error("fingerprint is a substring of the key")
+	}
+}
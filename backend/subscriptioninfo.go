@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// momoProducts are the MTN products a subscription key may belong to
+var momoProducts = []string{"collection", "disbursement", "remittance"}
+
+// knownProduct reports whether product is one of momoProducts
+func knownProduct(product string) bool {
+	for _, p := range momoProducts {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
+// productProbeTimeout bounds each product probe, which should answer quickly
+const productProbeTimeout = 5 * time.Second
+
+// SubscriptionInfoRequest is the body of POST /api/subscription/info. The key is
+// resolved like /api/generate: primaryKey, secondaryKey, then MOMO_SUBSCRIPTION_KEY.
+type SubscriptionInfoRequest struct {
+	PrimaryKey   string `json:"primaryKey"`
+	SecondaryKey string `json:"secondaryKey"`
+}
+
+// ProductProbeResult reports whether a subscription key appears valid for one product
+type ProductProbeResult struct {
+	Product string `json:"product"`
+	Valid   bool   `json:"valid"`
+	Reason  string `json:"reason"`
+}
+
+// SubscriptionInfo is the response of POST /api/subscription/info. The key itself
+// is never echoed back, only its last four characters.
+type SubscriptionInfo struct {
+	KeySuffix string               `json:"keySuffix"`
+	Products  []ProductProbeResult `json:"products"`
+}
+
+// probeProduct asks a product's token endpoint for a token with deliberately invalid
+// API user credentials. MTN's gateway rejects a subscription key that is not
+// subscribed to the product before looking at the credentials, with a message about
+// the subscription key; any other answer means the key got through to the product.
+func probeProduct(ctx context.Context, key string, product string) ProductProbeResult {
+	result := ProductProbeResult{Product: product}
+
+	ctx, cancel := context.WithTimeout(ctx, productProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", productTokenURL(product), nil)
+	if err != nil {
+		result.Reason = "could not build probe request"
+		return result
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", key)
+	req.SetBasicAuth("00000000-0000-0000-0000-000000000000", "probe")
+
+	release := acquireMomoSlot()
+	defer release()
+	resp, err := momoClient().Do(req)
+	if err != nil {
+		result.Reason = "could not reach MTN MoMo API"
+		return result
+	}
+	defer resp.Body.Close()
+	body := readErrorBody(resp)
+
+	switch {
+	case (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) &&
+		strings.Contains(strings.ToLower(string(body)), "subscription key"):
+		result.Reason = "subscription key is not subscribed to this product"
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusOK:
+		// The key passed the gateway; only the (fake) API user credentials were rejected
+		result.Valid = true
+		result.Reason = "subscription key accepted for this product"
+	default:
+		result.Reason = fmt.Sprintf("unexpected MTN MoMo response (status %d)", resp.StatusCode)
+	}
+	return result
+}
+
+// handleSubscriptionInfo reports which MTN products a subscription key appears to be
+// valid for. Probing the server's own key (none in the request) needs the admin token.
+func handleSubscriptionInfo(w http.ResponseWriter, r *http.Request) {
+	log.Println("=== New Subscription Info Request Received ===")
+
+	var req SubscriptionInfoRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("ERROR: Invalid request format - %v", err)
+		sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	key, keySource := resolveSubscriptionKey(r.Context(), MomoKeyRequest{PrimaryKey: req.PrimaryKey, SecondaryKey: req.SecondaryKey})
+	if key == "" {
+		log.Println("ERROR: No subscription key in the request (primary or secondary) or in MOMO_SUBSCRIPTION_KEY")
+		sendError(w, r, errMissingSubscriptionKey, "no subscription key available from request or server configuration", http.StatusBadRequest)
+		return
+	}
+	if req.PrimaryKey == "" && req.SecondaryKey == "" {
+		// Callers probe the keys they send; the server's own key is described only to admins
+		requireAdminToken(func(w http.ResponseWriter, r *http.Request) {
+			probeSubscriptionProducts(w, r, key, keySource)
+		})(w, r)
+		return
+	}
+	probeSubscriptionProducts(w, r, key, keySource)
+}
+
+// probeSubscriptionProducts answers a subscription info request for key. Products are
+// probed concurrently, bounded by the shared outbound semaphore.
+func probeSubscriptionProducts(w http.ResponseWriter, r *http.Request, key string, keySource string) {
+	log.Printf("INFO: Probing MTN products for subscription key ...%s from %s", subscriptionKeySuffix(key), keySource)
+
+	info := SubscriptionInfo{KeySuffix: subscriptionKeySuffix(key), Products: make([]ProductProbeResult, len(momoProducts))}
+	var wg sync.WaitGroup
+	for i, product := range momoProducts {
+		wg.Add(1)
+		go func(i int, product string) {
+			defer wg.Done()
+			info.Products[i] = probeProduct(r.Context(), key, product)
+			log.Printf("Subscription key ...%s %s valid=%t (%s)", info.KeySuffix, product, info.Products[i].Valid, info.Products[i].Reason)
+		}(i, product)
+	}
+	wg.Wait()
+
+	log.Println("=== Subscription Info Request Completed ===")
+	sendResponse(w, r, true, "Subscription key products probed", info, http.StatusOK)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionInfo(t *testing.T) {
+	h := setupTest(t, nil)
+	// Only collection accepts the key; the others reject it at the gateway
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.URL.Path, "/collection/") {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"login_failed"}`)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"statusCode":401,"message":"Access denied due to invalid subscription key. Make sure to provide a valid key for an active subscription."}`)
+	}))
+
+	rec := doRequest(h, http.MethodPost, "/api/subscription/info", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), testSubscriptionKey) {
+		t.Errorf("response echoes the subscription key: %s", rec.Body)
+	}
+
+	var info SubscriptionInfo
+	decodeEnvelope(t, rec, &info)
+	if info.KeySuffix != subscriptionKeySuffix(testSubscriptionKey) {
+		t.Errorf("keySuffix = %q", info.KeySuffix)
+	}
+	want := map[string]bool{"collection": true, "disbursement": false, "remittance": false}
+	if len(info.Products) != len(want) {
+		t.Fatalf("products = %+v, want %d", info.Products, len(want))
+	}
+	for _, p := range info.Products {
+		if p.Valid != want[p.Product] {
+			t.Errorf("%s valid = %t, want %t (%s)", p.Product, p.Valid, want[p.Product], p.Reason)
+		}
+	}
+}
+
+func TestSubscriptionInfoUnexpectedStatus(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnStatus(http.StatusInternalServerError))
+
+	rec := doRequest(h, http.MethodPost, "/api/subscription/info", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	var info SubscriptionInfo
+	decodeEnvelope(t, rec, &info)
+	for _, p := range info.Products {
+		if p.Valid || !strings.Contains(p.Reason, "500") {
+			t.Errorf("%s = %+v, want invalid with the unexpected status", p.Product, p)
+		}
+	}
+}
+
+func TestSubscriptionInfoServerKeyNeedsAdminToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		body       string
+		headers    []string
+		wantStatus int
+	}{
+		{"client key", testAdminToken, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey), nil, http.StatusOK},
+		{"server key without token", testAdminToken, `{}`, nil, http.StatusUnauthorized},
+		{"server key with a wrong token", testAdminToken, `{}`, []string{"Authorization", "Bearer wrong"}, http.StatusUnauthorized},
+		{"server key with the admin token", testAdminToken, `{}`, []string{"Authorization", "Bearer " + testAdminToken}, http.StatusOK},
+		{"server key, admin endpoints disabled", "", `{}`, nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"ADMIN_API_TOKEN": tt.adminToken, "MOMO_SUBSCRIPTION_KEY": badSubscriptionKey})
+			fakeMTN(t, mtnStatus(http.StatusUnauthorized))
+
+			rec := doRequest(h, http.MethodPost, "/api/subscription/info", tt.body, tt.headers...)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK && strings.Contains(rec.Body.String(), subscriptionKeySuffix(badSubscriptionKey)) {
+				t.Errorf("rejected response describes the server key: %s", rec.Body)
+			}
+		})
+	}
+}
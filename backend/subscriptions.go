@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// maxSubscriptionKeysPerValidation caps how many keys a single validation request may probe
+const maxSubscriptionKeysPerValidation = 50
+
+// ValidateSubscriptionsRequest structure for bulk subscription key validation
+type ValidateSubscriptionsRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// SubscriptionKeyResult reports the validity of one subscription key.
+// The full key is never echoed back, only its last four characters, and not even
+// those for keys too short to spare them (see subscriptionKeySuffix).
+type SubscriptionKeyResult struct {
+	KeySuffix string `json:"keySuffix"`
+	Valid     bool   `json:"valid"`
+	Reason    string `json:"reason"`
+}
+
+// probeSubscriptionKey checks whether MTN accepts a subscription key by looking up a
+// random (non-existent) API user: a 404 means the key authenticated successfully.
+func probeSubscriptionKey(ctx context.Context, key string) SubscriptionKeyResult {
+	result := SubscriptionKeyResult{KeySuffix: subscriptionKeySuffix(key)}
+	if key == "" {
+		result.Reason = "subscription key is empty"
+		return result
+	}
+
+	_, err := getAPIUser(ctx, key, uuid.New().String())
+
+	var apiErr *momoAPIError
+	switch {
+	case err == nil:
+		result.Valid = true
+		result.Reason = "subscription key accepted by MTN MoMo"
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		result.Valid = true
+		result.Reason = "subscription key accepted by MTN MoMo"
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		result.Reason = fmt.Sprintf("subscription key rejected by MTN MoMo (status %d)", apiErr.StatusCode)
+	case errors.As(err, &apiErr):
+		result.Reason = fmt.Sprintf("unexpected MTN MoMo response (status %d)", apiErr.StatusCode)
+	default:
+		result.Reason = "could not reach MTN MoMo API"
+	}
+	return result
+}
+
+// handleValidateSubscriptions probes each submitted subscription key against MTN concurrently.
+// Concurrency is bounded by the shared outbound semaphore.
+func handleValidateSubscriptions(w http.ResponseWriter, r *http.Request) {
+	log.Println("=== New Subscription Key Validation Request Received ===")
+
+	var req ValidateSubscriptionsRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("ERROR: Invalid request format - %v", err)
+		sendError(w, r, errInvalidRequest, decodeErrorMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Keys) == 0 {
+		log.Println("ERROR: No subscription keys provided")
+		sendError(w, r, errMissingSubscriptionKey, "At least one subscription key is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) > maxSubscriptionKeysPerValidation {
+		log.Printf("ERROR: Too many subscription keys - %d (limit %d)", len(req.Keys), maxSubscriptionKeysPerValidation)
+		sendError(w, r, errInvalidRequest, fmt.Sprintf("At most %d subscription keys can be validated per request", maxSubscriptionKeysPerValidation), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Probing %d subscription keys against MTN MoMo API", len(req.Keys))
+	results := make([]SubscriptionKeyResult, len(req.Keys))
+	var wg sync.WaitGroup
+	for i, key := range req.Keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			results[i] = probeSubscriptionKey(r.Context(), key)
+			log.Printf("Subscription key ...%s valid=%t (%s)", results[i].KeySuffix, results[i].Valid, results[i].Reason)
+		}(i, key)
+	}
+	wg.Wait()
+
+	log.Println("=== Subscription Key Validation Request Completed ===")
+	sendResponse(w, r, true, "Subscription keys validated", results, http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateSubscriptions(t *testing.T) {
+	const (
+		validKey   = "0123456789abcdef0123456789abcdef"
+		revokedKey = "fedcba9876543210fedcba9876543210"
+		shortKey   = "abc"
+	)
+	h := setupTest(t, nil)
+	// MTN answers 404 for the random user when the key authenticates, 401 otherwise
+	fakeMTN(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Ocp-Apim-Subscription-Key") == validKey {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	body, _ := json.Marshal(ValidateSubscriptionsRequest{Keys: []string{validKey, revokedKey, "", shortKey}})
+	rec := doRequest(h, http.MethodPost, "/api/subscriptions/validate", string(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var results []SubscriptionKeyResult
+	decodeEnvelope(t, rec, &results)
+
+	want := []SubscriptionKeyResult{
+		{KeySuffix: "cdef", Valid: true},
+		{KeySuffix: "3210", Valid: false},
+		{KeySuffix: "", Valid: false},
+		{KeySuffix: "", Valid: false}, // Too short to show any of it
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		if results[i].KeySuffix != w.KeySuffix || results[i].Valid != w.Valid {
+			t.Errorf("result %d = %+v, want keySuffix %q valid %t", i, results[i], w.KeySuffix, w.Valid)
+		}
+		if results[i].Reason == "" {
+			t.Errorf("result %d has no reason", i)
+		}
+	}
+	for _, key := range []string{validKey, revokedKey} {
+		if strings.Contains(rec.Body.String(), key) {
+			t.Errorf("response echoes the full key %s", key)
+		}
+	}
+	if strings.Contains(rec.Body.String(), `"keySuffix":"abc"`) {
+		t.Error("response echoes the whole short key")
+	}
+}
+
+func TestValidateSubscriptionsRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     int
+		wantCode string
+	}{
+		{"no keys", 0, errMissingSubscriptionKey},
+		{"over the limit", maxSubscriptionKeysPerValidation + 1, errInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, nil)
+			body, _ := json.Marshal(ValidateSubscriptionsRequest{Keys: make([]string, tt.keys)})
+			rec := doRequest(h, http.MethodPost, "/api/subscriptions/validate", string(body))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			if env := decodeEnvelope(t, rec, nil); env.ErrorCode != tt.wantCode {
+				t.Errorf("errorCode = %q, want %q", env.ErrorCode, tt.wantCode)
+			}
+		})
+	}
+}
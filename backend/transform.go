@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// ResponseTransformer adds or modifies fields of a response's Data payload just
+// before it is serialized, e.g. to inject organization-specific fields. It is
+// an extension point for downstream forks: register an implementation from an
+// init function with registerResponseTransformer and select it with RESPONSE_TRANSFORMER.
+type ResponseTransformer interface {
+	Transform(r *http.Request, data interface{}) interface{}
+}
+
+// noopTransformer returns the payload unchanged
+type noopTransformer struct{}
+
+func (noopTransformer) Transform(_ *http.Request, data interface{}) interface{} { return data }
+
+// responseTransformers are the transformers selectable with RESPONSE_TRANSFORMER, by name
+var responseTransformers = map[string]ResponseTransformer{
+	"none": noopTransformer{},
+}
+
+// registerResponseTransformer makes a transformer selectable by name. It must be
+// called before configuration is loaded, i.e. from an init function.
+func registerResponseTransformer(name string, t ResponseTransformer) {
+	responseTransformers[name] = t
+}
+
+// responseTransformerNames lists the registered transformer names, for config errors
+func responseTransformerNames() []string {
+	names := make([]string, 0, len(responseTransformers))
+	for name := range responseTransformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// responseTransformer is the ResponseTransformer applied to every sendResponse payload
+var responseTransformer ResponseTransformer = noopTransformer{}
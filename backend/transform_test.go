@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// orgFieldTransformer adds an organization field to object payloads
+type orgFieldTransformer struct{}
+
+func (orgFieldTransformer) Transform(r *http.Request, data interface{}) interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return data // Not an object
+	}
+	fields["organization"] = "acme"
+	return fields
+}
+
+func TestResponseTransformer(t *testing.T) {
+	registerResponseTransformer("test-org", orgFieldTransformer{})
+	t.Cleanup(func() { delete(responseTransformers, "test-org") })
+
+	tests := []struct {
+		transformer string
+		wantOrg     string
+	}{
+		{"", ""},
+		{"none", ""},
+		{"test-org", "acme"},
+	}
+	for _, tt := range tests {
+		t.Run("transformer="+tt.transformer, func(t *testing.T) {
+			h := setupTest(t, map[string]string{"RESPONSE_TRANSFORMER": tt.transformer})
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			var data struct {
+				APIUser      string `json:"apiUser"`
+				Organization string `json:"organization"`
+			}
+			decodeEnvelope(t, rec, &data)
+			if data.Organization != tt.wantOrg {
+				t.Errorf("organization = %q, want %q", data.Organization, tt.wantOrg)
+			}
+			if data.APIUser == "" {
+				t.Error("transformed payload lost the existing fields")
+			}
+		})
+	}
+}
+
+func TestUnknownResponseTransformer(t *testing.T) {
+	t.Setenv("RESPONSE_TRANSFORMER", "missing")
+	if _, err := loadConfig(); err == nil {
+		t.Error("loadConfig accepted an unregistered RESPONSE_TRANSFORMER")
+	}
+}
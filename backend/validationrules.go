@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// subscriptionKeyPattern is the shape of an MTN subscription key (Ocp-Apim-Subscription-Key):
+// 32 hex characters. It is only enforced with STRICT_KEY_VALIDATION, as MTN itself is
+// the authority on which keys are valid.
+var subscriptionKeyPattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// defaultCallbackHost is registered with MTN when a request gives no callback host
+const defaultCallbackHost = "example.com"
+
+// ValidationRule describes how the server validates one generate request field, so
+// frontends can check input before submitting it
+type ValidationRule struct {
+	Required  bool     `json:"required"`
+	MaxLength int      `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"` // A regular expression valid in Go (RE2) and JavaScript
+	Values    []string `json:"values,omitempty"`  // The only accepted values
+	Default   string   `json:"default,omitempty"` // Used when the field is omitted
+	// Enforced is false for advisory format rules (MaxLength, Pattern, Values), which
+	// the server does not reject on; Required is always enforced
+	Enforced bool `json:"enforced"`
+}
+
+// PatternedReferenceIDFormat is implemented by ReferenceIDFormats whose Validate
+// amounts to a regular expression, so /api/validation-rules can publish it
+type PatternedReferenceIDFormat interface {
+	ReferenceIDFormat
+	Pattern() string
+}
+
+func (uuidV4Format) Pattern() string {
+	return `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-4[0-9a-fA-F]{3}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`
+}
+
+func (uuidAnyFormat) Pattern() string {
+	return `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`
+}
+
+// validationRules builds the rules of the running configuration, from the same
+// settings and constants handleGenerateKeys validates with
+func validationRules() map[string]ValidationRule {
+	referenceID := ValidationRule{Enforced: true}
+	if f, ok := referenceIDFormat.(PatternedReferenceIDFormat); ok {
+		referenceID.Pattern = f.Pattern()
+	}
+	return map[string]ValidationRule{
+		"primaryKey": {
+			Required: cfg.SecretProvider == secretProviderEnv && cfg.SubscriptionKey == "",
+			Pattern:  subscriptionKeyPattern.String(),
+			Enforced: cfg.StrictKeyValidation,
+		},
+		"callbackHost": {
+			Required:  cfg.RequireCallbackHost,
+			MaxLength: cfg.MaxCallbackHostLength,
+			Default:   defaultCallbackHost,
+			Enforced:  true,
+		},
+		"referenceId": referenceID,
+		"targetEnvironment": {
+			Values:   cfg.AllowedTargetEnvs,
+			Default:  defaultTargetEnv,
+			Enforced: true,
+		},
+	}
+}
+
+// handleValidationRules serves the generate request validation rules, keyed by field
+func handleValidationRules(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, true, "Generate request validation rules", validationRules(), http.StatusOK)
+}
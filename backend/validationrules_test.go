@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// ruleAllows reports whether value passes rule, as a frontend would check it
+func ruleAllows(t *testing.T, rule ValidationRule, value string) bool {
+	t.Helper()
+	if value == "" {
+		return !rule.Required
+	}
+	if !rule.Enforced {
+		return true
+	}
+	if rule.MaxLength > 0 && len(value) > rule.MaxLength {
+		return false
+	}
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			t.Fatalf("pattern %q does not compile: %v", rule.Pattern, err)
+		}
+		if !re.MatchString(value) {
+			return false
+		}
+	}
+	return rule.Values == nil || slices.Contains(rule.Values, value)
+}
+
+func TestValidationRulesMatchValidators(t *testing.T) {
+	const uuidV4 = "3f2b8c1e-9d4a-4b6f-8e21-5c7a0d9e1f34"
+	const uuidV1 = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	tests := []struct {
+		name  string
+		env   map[string]string
+		field string
+		value string
+	}{
+		{"key shaped like MTN's", map[string]string{"STRICT_KEY_VALIDATION": "true"}, "primaryKey", testSubscriptionKey},
+		{"malformed key, strict", map[string]string{"STRICT_KEY_VALIDATION": "true"}, "primaryKey", "not-a-key"},
+		{"malformed key, advisory", nil, "primaryKey", "not-a-key"},
+		{"missing key", nil, "primaryKey", ""},
+		{"missing key, server key set", map[string]string{"MOMO_SUBSCRIPTION_KEY": testSubscriptionKey}, "primaryKey", ""},
+		{"callback host", nil, "callbackHost", "shop.example.com"},
+		{"callback host at the limit", map[string]string{"MAX_CALLBACK_HOST_LENGTH": "16"}, "callbackHost", "shop.example.com"},
+		{"callback host over the limit", map[string]string{"MAX_CALLBACK_HOST_LENGTH": "15"}, "callbackHost", "shop.example.com"},
+		{"missing callback host", nil, "callbackHost", ""},
+		{"missing callback host, required", map[string]string{"REQUIRE_CALLBACK_HOST": "true"}, "callbackHost", ""},
+		{"version 4 reference ID", nil, "referenceId", uuidV4},
+		{"uppercase reference ID", nil, "referenceId", strings.ToUpper(uuidV4)},
+		{"version 1 reference ID", nil, "referenceId", uuidV1},
+		{"version 1 reference ID, any version", map[string]string{"REFERENCE_ID_FORMAT": "uuid"}, "referenceId", uuidV1},
+		{"reference ID not a UUID", nil, "referenceId", "order-42"},
+		{"default target environment", nil, "targetEnvironment", "sandbox"},
+		{"target environment not allowed", nil, "targetEnvironment", "mtnghana"},
+		{"unknown target environment", nil, "targetEnvironment", "nowhere"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			fakeMTN(t, mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90"))
+
+			var rules map[string]ValidationRule
+			if rec := doRequest(h, http.MethodGet, "/api/validation-rules", ""); rec.Code != http.StatusOK {
+				t.Fatalf("validation rules status = %d, want 200", rec.Code)
+			} else {
+				decodeEnvelope(t, rec, &rules)
+			}
+			rule, ok := rules[tt.field]
+			if !ok {
+				t.Fatalf("no rule for %s in %v", tt.field, rules)
+			}
+
+			fields := map[string]string{"primaryKey": testSubscriptionKey}
+			if tt.value != "" {
+				fields[tt.field] = tt.value
+			} else {
+				delete(fields, tt.field)
+			}
+			body, _ := json.Marshal(fields)
+			rec := doRequest(h, http.MethodPost, "/api/generate", string(body))
+
+			serverAccepts := rec.Code != http.StatusBadRequest
+			if want := ruleAllows(t, rule, tt.value); serverAccepts != want {
+				t.Errorf("%s %q: rule %+v allows it %t, but the server answered %d (body %s)", tt.field, tt.value, rule, want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// verifyTimeout bounds the end-to-end check of ?verify=true, token and call together
+const verifyTimeout = 15 * time.Second
+
+// tokenResponse is the part of MTN's token response the verification needs
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// verifyCredentials proves freshly created credentials work: it obtains an access
+// token for product with them and makes one read-only authenticated call (the
+// account balance) in targetEnv. MTN may take a moment to accept a brand new key,
+// so the token request gets the same propagation retry as key creation.
+func verifyCredentials(ctx context.Context, subscriptionKey, product, apiUser, apiKey, targetEnv string) error {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	var token string
+	err := withPropagationRetry(ctx, "verify credentials", func() error {
+		var err error
+		token, err = requestAccessToken(ctx, subscriptionKey, product, apiUser, apiKey)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", productURL(product, "/account/balance"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Ocp-Apim-Subscription-Key", subscriptionKey)
+	req.Header.Set("X-Target-Environment", targetEnv)
+
+	release := acquireMomoSlot()
+	defer release()
+	resp, err := momoClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body := readErrorBody(resp)
+		return &momoAPIError{Operation: "get account balance", StatusCode: resp.StatusCode, Body: formatErrorBody(body, subscriptionKey)}
+	}
+	return nil
+}
+
+// requestAccessToken obtains an access token for product with the API user credentials
+func requestAccessToken(ctx context.Context, subscriptionKey, product, apiUser, apiKey string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", productTokenURL(product), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", subscriptionKey)
+	req.SetBasicAuth(apiUser, apiKey)
+
+	release := acquireMomoSlot()
+	defer release()
+	resp, err := momoClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body := readErrorBody(resp)
+		return "", &momoAPIError{Operation: "create access token", StatusCode: resp.StatusCode, Body: formatErrorBody(body, subscriptionKey, apiKey)}
+	}
+
+	var token tokenResponse
+	body, err := responseBody(resp)
+	if err != nil {
+		return "", err
+	}
+	if err := json.NewDecoder(body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+	return token.AccessToken, nil
+}
+
+// applyVerification runs verifyCredentials for a generate response and records the
+// outcome on it. Locally generated credentials cannot work, so they are reported
+// unverified without calling MTN.
+func applyVerification(ctx context.Context, resp *MomoKeyResponse, subscriptionKey, product string) {
+	logger := reqLog(ctx)
+	verified := false
+	resp.Verified = &verified
+	if resp.Source != sourceMTN {
+		resp.VerificationError = "credentials were generated locally and are not registered with MTN MoMo"
+		return
+	}
+	if product == "" {
+		product = "collection"
+	}
+
+	logger.Printf("Verifying credentials for user %s against the %s product...", resp.APIUser, product)
+	start := time.Now()
+	err := verifyCredentials(ctx, subscriptionKey, product, resp.APIUser, resp.APIKey, resp.TargetEnv)
+	observeMomoCall("verify", start, err)
+	if err != nil {
+		logger.Printf("WARNING: Credential verification failed for user %s - %v", resp.APIUser, err)
+		resp.VerificationError = err.Error()
+		return
+	}
+	verified = true
+	logger.Printf("SUCCESS: Credentials for user %s verified with a token and an authenticated call", resp.APIUser)
+}
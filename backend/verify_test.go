@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// mtnVerifiable is an MTN stub that creates credentials and answers the calls of
+// ?verify=true with tokenStatus and balanceStatus, recording the balance path used
+func mtnVerifiable(tokenStatus, balanceStatus int, balancePath *atomic.Value) http.HandlerFunc {
+	success := mtnSuccess("a1b2c3d4e5f60718293a4b5c6d7e8f90")
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/token/"):
+			w.WriteHeader(tokenStatus)
+			if tokenStatus == http.StatusOK {
+				fmt.Fprint(w, `{"access_token":"test-token","token_type":"access_token","expires_in":3600}`)
+			}
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/account/balance"):
+			balancePath.Store(r.URL.Path)
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(balanceStatus)
+			fmt.Fprint(w, `{"availableBalance":"0","currency":"EUR"}`)
+		default:
+			success(w, r)
+		}
+	}
+}
+
+func TestGenerateVerify(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		tokenStatus   int
+		balanceStatus int
+		wantVerified  bool
+		wantError     string
+		wantPath      string
+	}{
+		{"verified", nil, http.StatusOK, http.StatusOK, true, "", "/collection/v1_0/account/balance"},
+		{"configured API version", map[string]string{"MOMO_API_VERSION": "v2_0"}, http.StatusOK, http.StatusOK, true, "", "/collection/v2_0/account/balance"},
+		{"token rejected", nil, http.StatusUnauthorized, http.StatusOK, false, "401", ""},
+		{"balance call fails", nil, http.StatusOK, http.StatusInternalServerError, false, "500", "/collection/v1_0/account/balance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTest(t, tt.env)
+			var balancePath atomic.Value
+			fakeMTN(t, mtnVerifiable(tt.tokenStatus, tt.balanceStatus, &balancePath))
+
+			rec := doRequest(h, http.MethodPost, "/api/generate?verify=true", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+			// A failed verification still returns the credentials
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want 201 (body %s)", rec.Code, rec.Body)
+			}
+			var resp MomoKeyResponse
+			decodeEnvelope(t, rec, &resp)
+			if resp.APIKey == "" {
+				t.Error("response has no API key")
+			}
+			if resp.Verified == nil || *resp.Verified != tt.wantVerified {
+				t.Fatalf("verified = %v, want %t (verificationError %q)", resp.Verified, tt.wantVerified, resp.VerificationError)
+			}
+			if tt.wantError == "" && resp.VerificationError != "" {
+				t.Errorf("verificationError = %q, want none", resp.VerificationError)
+			}
+			if !strings.Contains(resp.VerificationError, tt.wantError) {
+				t.Errorf("verificationError = %q, want it to mention %s", resp.VerificationError, tt.wantError)
+			}
+			if got, _ := balancePath.Load().(string); got != tt.wantPath {
+				t.Errorf("balance call path = %q, want %q", got, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestGenerateVerifyLocalFallback(t *testing.T) {
+	h := setupTest(t, nil)
+	fakeMTN(t, mtnStatus(http.StatusServiceUnavailable))
+
+	rec := doRequest(h, http.MethodPost, "/api/generate?verify=true", fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	var resp MomoKeyResponse
+	decodeEnvelope(t, rec, &resp)
+	if resp.Source != sourceLocal {
+		t.Fatalf("source = %q, want a local fallback", resp.Source)
+	}
+	if resp.Verified == nil || *resp.Verified || !strings.Contains(resp.VerificationError, "locally") {
+		t.Errorf("verified = %v, verificationError = %q; want unverified local credentials", resp.Verified, resp.VerificationError)
+	}
+}
+
+func TestGenerateWithoutVerify(t *testing.T) {
+	h := setupTest(t, nil)
+	var balancePath atomic.Value
+	fakeMTN(t, mtnVerifiable(http.StatusOK, http.StatusOK, &balancePath))
+
+	resp := generate(t, h, fmt.Sprintf(`{"primaryKey":%q}`, testSubscriptionKey))
+	if resp.Verified != nil {
+		t.Errorf("verified = %t without verify=true, want it omitted", *resp.Verified)
+	}
+	if got, _ := balancePath.Load().(string); got != "" {
+		t.Errorf("made a balance call to %s without verify=true", got)
+	}
+}